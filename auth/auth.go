@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Principal 认证通过后的客户端身份，保存在服务端的 Session 上
+type Principal struct {
+	Name string // 客户端身份标识（静态令牌对应的名字，或 HMAC 令牌中的 sub）
+}
+
+// Authenticator 在服务端收到 Conn 包时被调用，根据令牌返回客户端身份
+type Authenticator interface {
+	Authenticate(token []byte) (*Principal, error)
+}
+
+// AuthenticatorFunc 让普通函数也能作为 Authenticator 使用（与 http.HandlerFunc 同理）
+type AuthenticatorFunc func(token []byte) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(token []byte) (*Principal, error) {
+	return f(token)
+}
+
+type staticTokenAuthenticator struct {
+	tokens map[string]string // token -> 客户端身份
+}
+
+// NewStaticTokenAuthenticator 使用预先共享的静态令牌认证，tokens 的 key 为令牌，value 为对应的客户端身份
+func NewStaticTokenAuthenticator(tokens map[string]string) Authenticator {
+	m := make(map[string]string, len(tokens))
+	for token, name := range tokens {
+		m[token] = name
+	}
+	return &staticTokenAuthenticator{tokens: m}
+}
+
+func (a *staticTokenAuthenticator) Authenticate(token []byte) (*Principal, error) {
+	// 逐个做常量时间比较，避免通过响应时间猜出令牌
+	var name string
+	found := false
+	for t, n := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+			name = n
+			found = true
+		}
+	}
+	if !found {
+		return nil, ErrInvalidToken
+	}
+	return &Principal{Name: name}, nil
+}
+
+// HMAC 令牌采用 JWT 的格式：base64url(header).base64url(claims).base64url(signature)
+// 签名算法固定为 HS256，密钥在服务端本地配置
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+type tokenClaims struct {
+	Sub string `json:"sub"`           // 客户端身份
+	Exp int64  `json:"exp"`           // 过期时间（Unix 秒）
+	Iat int64  `json:"iat,omitempty"` // 签发时间（Unix 秒）
+}
+
+type hmacAuthenticator struct {
+	key []byte
+	now func() time.Time // 方便测试时替换当前时间
+}
+
+// NewHMACAuthenticator 使用 HS256 签名的 JWT 风格令牌认证，并检查令牌是否过期
+func NewHMACAuthenticator(key []byte) Authenticator {
+	return &hmacAuthenticator{key: key, now: time.Now}
+}
+
+func (a *hmacAuthenticator) Authenticate(token []byte) (*Principal, error) {
+	parts := strings.Split(string(token), ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal(sig, sign(a.key, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var header tokenHeader
+	if err = decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	var claims tokenClaims
+	if err = decodeSegment(parts[1], &claims); err != nil || claims.Sub == "" {
+		return nil, ErrInvalidToken
+	}
+	if claims.Exp == 0 || a.now().Unix() >= claims.Exp {
+		return nil, ErrTokenExpired
+	}
+	return &Principal{Name: claims.Sub}, nil
+}
+
+// SignHMACToken 签发一个 HMAC 令牌，供客户端在 Conn 包中携带
+func SignHMACToken(key []byte, subject string, expiresAt time.Time) (string, error) {
+	header, err := json.Marshal(tokenHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(tokenClaims{Sub: subject, Exp: expiresAt.Unix(), Iat: time.Now().Unix()})
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(key, signingInput)), nil
+}
+
+func sign(key []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+type chainAuthenticator []Authenticator
+
+// Chain 依次尝试多个 Authenticator，任意一个认证通过即可；全部失败时返回最后一个错误
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chainAuthenticator(authenticators)
+}
+
+func (c chainAuthenticator) Authenticate(token []byte) (*Principal, error) {
+	err := ErrInvalidToken
+	for _, a := range c {
+		var p *Principal
+		p, err = a.Authenticate(token)
+		if err == nil {
+			return p, nil
+		}
+	}
+	return nil, err
+}
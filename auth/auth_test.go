@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStaticTokenAuthenticator(t *testing.T) {
+	a := NewStaticTokenAuthenticator(map[string]string{"secret-1": "alice"})
+	p, err := a.Authenticate([]byte("secret-1"))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if p.Name != "alice" {
+		t.Errorf("want alice, actual %s", p.Name)
+	}
+
+	_, err = a.Authenticate([]byte("secret-2"))
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("want ErrInvalidToken, actual %v", err)
+	}
+}
+
+func TestHMACAuthenticator(t *testing.T) {
+	key := []byte("hmac-key")
+	token, err := SignHMACToken(key, "bob", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	a := NewHMACAuthenticator(key)
+	p, err := a.Authenticate([]byte(token))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if p.Name != "bob" {
+		t.Errorf("want bob, actual %s", p.Name)
+	}
+
+	// 密钥不一致
+	_, err = NewHMACAuthenticator([]byte("other-key")).Authenticate([]byte(token))
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("want ErrInvalidToken, actual %v", err)
+	}
+
+	// 篡改 claims
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + parts[0] + "." + parts[2]
+	_, err = a.Authenticate([]byte(tampered))
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("want ErrInvalidToken, actual %v", err)
+	}
+}
+
+func TestHMACAuthenticator_Expired(t *testing.T) {
+	key := []byte("hmac-key")
+	token, err := SignHMACToken(key, "bob", time.Now().Add(-time.Second))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	_, err = NewHMACAuthenticator(key).Authenticate([]byte(token))
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("want ErrTokenExpired, actual %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	key := []byte("hmac-key")
+	a := Chain(NewStaticTokenAuthenticator(map[string]string{"secret-1": "alice"}), NewHMACAuthenticator(key))
+	token, _ := SignHMACToken(key, "bob", time.Now().Add(time.Hour))
+
+	p, err := a.Authenticate([]byte(token))
+	if err != nil || p.Name != "bob" {
+		t.Errorf("want bob, actual %v %v", p, err)
+	}
+	p, err = a.Authenticate([]byte("secret-1"))
+	if err != nil || p.Name != "alice" {
+		t.Errorf("want alice, actual %v %v", p, err)
+	}
+	_, err = a.Authenticate([]byte("nothing"))
+	if err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"37_tcp-server-demo1/packet"
+	"context"
+	"sync"
+	"time"
+)
+
+// batcher 把 Linger 时间内的多个 Send 合并成一个 SubmitBatch 发送
+type batcher struct {
+	mu      sync.Mutex
+	entries []*batchEntry
+	timer   *time.Timer // 第一条消息入队时启动，到期后不管批次是否已满都发送
+}
+
+// batchEntry 是一个等待批量发送的 Send
+type batchEntry struct {
+	submit *packet.Submit
+	done   chan batchResult
+}
+
+type batchResult struct {
+	result uint8
+	err    error
+}
+
+// sendBatched 把 submit 放入当前批次，并等待批次的应答中属于它的结果。
+// ctx 被取消时只取消这一条消息，批次中的其他消息不受影响
+func (c *Client) sendBatched(ctx context.Context, submit *packet.Submit) (*packet.SubmitAck, error) {
+	entry := &batchEntry{submit: submit, done: make(chan batchResult, 1)}
+	c.batch.mu.Lock()
+	c.batch.entries = append(c.batch.entries, entry)
+	var full []*batchEntry
+	if len(c.batch.entries) >= c.opts.BatchSize {
+		full = c.takeBatchLocked()
+	} else if c.batch.timer == nil {
+		c.batch.timer = time.AfterFunc(c.opts.Linger, c.flushBatch)
+	}
+	c.batch.mu.Unlock()
+	if full != nil {
+		// 凑满一批的 Send 负责发送，其余的 Send 等待结果
+		c.sendBatch(full)
+	}
+
+	var r batchResult
+	select {
+	case r = <-entry.done:
+	case <-ctx.Done():
+		c.cancel(submit.ID)
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return packet.NewSubmitAck(submit.ID, r.result), nil
+}
+
+// takeBatchLocked 取出当前批次，调用方需持有 c.batch.mu
+func (c *Client) takeBatchLocked() []*batchEntry {
+	entries := c.batch.entries
+	c.batch.entries = nil
+	if c.batch.timer != nil {
+		c.batch.timer.Stop()
+		c.batch.timer = nil
+	}
+	return entries
+}
+
+// flushBatch 在 Linger 到期时发送未满的批次
+func (c *Client) flushBatch() {
+	c.batch.mu.Lock()
+	entries := c.takeBatchLocked()
+	c.batch.mu.Unlock()
+	if len(entries) > 0 {
+		c.sendBatch(entries)
+	}
+}
+
+// sendBatch 发送一个批次，并把每条消息的结果交给对应的 Send。
+// 超时后用同一个批次 ID 和相同的消息重发，服务端按消息 ID 去重
+func (c *Client) sendBatch(entries []*batchEntry) {
+	submits := make([]*packet.Submit, len(entries))
+	for i, e := range entries {
+		submits[i] = e.submit
+	}
+	id := c.nextID()
+	ack, err := c.request(context.Background(), id, packet.NewSubmitBatch(id, submits), c.opts.MaxRetries)
+	for i, e := range entries {
+		r := batchResult{err: err}
+		if err == nil {
+			batchAck := ack.(*packet.SubmitBatchAck)
+			switch {
+			case batchAck.Result != packet.ResultOK:
+				r.result = batchAck.Result
+			case i < len(batchAck.Results):
+				r.result = batchAck.Results[i]
+			default:
+				r.result = packet.ResultError // 服务端返回的结果数量不对
+			}
+		}
+		e.done <- r
+	}
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/server"
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_SendBatched(t *testing.T) {
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		if string(submit.Payload) == "bad" {
+			return packet.ResultError
+		}
+		return packet.ResultOK
+	})
+	srv.SigningKey = []byte("secret")
+	addr := startServer(t, srv)
+
+	c, err := Dial(addr, Options{SigningKey: []byte("secret"), BatchSize: 4, Linger: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	payloads := []string{"a", "bad", "b", "c", "d", "bad", "e"}
+	results := make([]uint8, len(payloads))
+	for i, payload := range payloads {
+		wg.Add(1)
+		go func(i int, payload string) {
+			defer wg.Done()
+			submitAck, err := c.Send([]byte(payload))
+			if err != nil {
+				t.Errorf("want nil, actual %s", err.Error())
+				return
+			}
+			results[i] = submitAck.Result
+		}(i, payload)
+	}
+	wg.Wait()
+	for i, payload := range payloads {
+		want := uint8(packet.ResultOK)
+		if payload == "bad" {
+			want = packet.ResultError
+		}
+		if results[i] != want {
+			t.Errorf("%s: want %d, actual %d", payload, want, results[i])
+		}
+	}
+}
+
+func TestClient_BatchFraming(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+
+	// 手写的服务端：记录每个 SubmitBatch 的大小，所有消息都返回成功
+	sizes := make(chan int, 16)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		codec := frame.NewMyFrameCodec()
+		for {
+			framePayload, err := codec.Decode(conn)
+			if err != nil {
+				return
+			}
+			p, err := packet.Decode(framePayload)
+			if err != nil {
+				return
+			}
+			var ack packet.Packet
+			switch t := p.(type) {
+			case *packet.Conn:
+				ack = packet.NewConnAck(t.ID, packet.ResultOK)
+			case *packet.SubmitBatch:
+				sizes <- len(t.Entries)
+				ack = packet.NewSubmitBatchAck(t.ID, packet.ResultOK, make([]uint8, len(t.Entries)))
+			default:
+				return
+			}
+			framePayload, _ = packet.Encode(ack)
+			codec.Encode(conn, framePayload)
+		}
+	}()
+
+	c, err := Dial(l.Addr().String(), Options{BatchSize: 3, Linger: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+
+	// 凑满 3 条立即发送
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Send([]byte("hello")); err != nil {
+				t.Errorf("want nil, actual %s", err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+	if n := <-sizes; n != 3 {
+		t.Errorf("want batch of 3, actual %d", n)
+	}
+
+	// 单独一条等 Linger 到期后发送
+	start := time.Now()
+	if _, err = c.Send([]byte("hello")); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("want at least linger 50ms, actual %s", elapsed)
+	}
+	if n := <-sizes; n != 1 {
+		t.Errorf("want batch of 1, actual %d", n)
+	}
+}
@@ -0,0 +1,512 @@
+package client
+
+import (
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/transport"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	mrand "math/rand/v2"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrAuthFailed = errors.New("authentication failed") // 服务端拒绝了令牌，重试没有意义
+	ErrClosed     = errors.New("client closed")
+	ErrTimeout    = errors.New("wait ack timeout")
+	ErrNoSigning  = errors.New("server does not support submit signing")
+	ErrNoCredit   = errors.New("no send credit") // 等待应答的 Submit 已经占满了服务端通告的窗口
+	ErrBadVersion = errors.New("no mutual protocol version")
+)
+
+// IsRetryable 判断 err 是否值得重新连接/重新发送
+func IsRetryable(err error) bool {
+	return err != nil && !errors.Is(err, ErrAuthFailed)
+}
+
+type Options struct {
+	Token   []byte        // Conn 包中携带的认证令牌
+	Timeout time.Duration // 等待 ConnAck/SubmitAck 的超时时间，默认 5s
+	// SigningKey 为与服务端预共享的签名密钥，非空时对每个 Submit 追加签名 trailer
+	SigningKey []byte
+	// MaxRetries 为等待应答超时后，用同一个 ID 重发 Submit 的最大次数。
+	// 服务端按 ID 去重，重发不会导致同一条消息被处理两次
+	MaxRetries int
+	// ChunkSize 为分块传输时每块数据的大小，默认 64KB
+	ChunkSize int
+	// FailFast 为 true 时，窗口已满的 Send 立即返回 ErrNoCredit；
+	// 默认阻塞等待其他 Submit 的应答归还窗口，最多等待 Timeout
+	FailFast bool
+	// BatchSize 大于 1 时开启批量发送：Send 先进入批次，批次凑满 BatchSize 条
+	// 或者等待了 Linger（默认 5ms）之后，作为一个 SubmitBatch 发出
+	BatchSize int
+	Linger    time.Duration
+	// FrameCodec 创建帧编解码器（见 frame.ParseBinaryCodec），必须与服务端一致，为 nil 时使用 frame.NewMyFrameCodec
+	FrameCodec func() frame.StreamFrameCodec
+	// Caps 为希望开启的能力（packet.CapXXX），实际开启的是服务端也支持的部分，见 Client.Caps。
+	// 开启批量发送时自动请求 packet.CapBatching，服务端不同意时退回逐条发送
+	Caps uint32
+	// SessionID 非空时作为 Conn.ID 发送，并请求 packet.CapSessionResume（服务端需开启认证）：
+	// 服务端按身份和 SessionID 保留去重缓存，断线后用同一个 SessionID 重新连接，
+	// 再用 SendID 重发没有收到应答的消息，不会被重复处理。固定 8 字节，同一身份的不同客户端不能相同
+	SessionID string
+}
+
+type Client struct {
+	conn       net.Conn
+	frameCodec frame.StreamFrameCodec
+	opts       Options
+
+	wmu     sync.Mutex // 保证同一时刻只有一个 goroutine 往连接里写帧
+	counter atomic.Uint64
+	signKey []byte // 握手时派生出的会话签名密钥
+	version uint8  // 协商出的协议版本
+	caps    uint32 // 协商出的能力
+	seq     uint64 // 签名 Seq，在 wmu 保护下递增，保证写入连接的顺序与 Seq 一致
+
+	mu       sync.Mutex
+	pending  map[string]chan packet.Packet // ID -> 等待该 ID 应答的 channel
+	handlers map[string]func(*Message)     // 订阅的主题 -> 收到消息时的回调
+	err      error                         // 响应 goroutine 退出的原因，非 nil 表示连接已不可用
+	done     chan struct{}
+	messages chan *packet.Publish // 响应 goroutine 把服务端推送的消息交给分发 goroutine
+
+	// 以下字段由 mu 保护，实现服务端通告的发送窗口
+	window   uint32        // 服务端允许同时等待应答的 Submit 数量，0 表示不限制
+	inflight uint32        // 已经发出、尚未收到应答的 Submit 数量
+	creditCh chan struct{} // 窗口变化时关闭并替换，用于唤醒等待窗口的 Send
+
+	batch batcher
+}
+
+// Dial 连接服务端并完成 Conn/ConnAck 握手，addr 的格式见 transport.ParseAddr
+func Dial(addr string, opts Options) (*Client, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	conn, err := transport.Dial(addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return New(conn, opts)
+}
+
+// New 在已经建立好的连接上完成握手（例如 mux.Stream），握手失败时会关闭 conn
+func New(conn net.Conn, opts Options) (*Client, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 64 << 10
+	}
+	if opts.BatchSize > packet.MaxBatchEntries {
+		opts.BatchSize = packet.MaxBatchEntries
+	}
+	if opts.Linger <= 0 {
+		opts.Linger = 5 * time.Millisecond
+	}
+	if opts.FrameCodec == nil {
+		opts.FrameCodec = frame.NewMyFrameCodec
+	}
+	if opts.SessionID != "" && len(opts.SessionID) != 8 {
+		conn.Close()
+		return nil, errors.New("session id must be 8 bytes")
+	}
+	c := &Client{
+		conn:       conn,
+		frameCodec: opts.FrameCodec(),
+		opts:       opts,
+		pending:    make(map[string]chan packet.Packet),
+		handlers:   make(map[string]func(*Message)),
+		done:       make(chan struct{}),
+		messages:   make(chan *packet.Publish, 1024),
+		creditCh:   make(chan struct{}),
+	}
+	if opts.SessionID != "" {
+		// 自动生成的 ID 从随机位置开始，避免与同一会话之前的连接用过、仍在去重缓存里的 ID 重复
+		c.counter.Store(mrand.Uint64N(100000000))
+	}
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.readLoop()
+	go c.dispatchLoop()
+	return c, nil
+}
+
+func (c *Client) handshake() error {
+	id := c.nextID()
+	if c.opts.SessionID != "" {
+		id = c.opts.SessionID
+	}
+	conn := packet.NewConn(id, c.opts.Token)
+	conn.Versions, conn.Caps = packet.SupportedVersions, c.opts.Caps
+	if c.opts.BatchSize > 1 {
+		conn.Caps |= packet.CapBatching
+	}
+	if c.opts.SessionID != "" {
+		conn.Caps |= packet.CapSessionResume
+	}
+	if c.opts.SigningKey != nil {
+		conn.Nonce = make([]byte, packet.NonceLen)
+		if _, err := rand.Read(conn.Nonce); err != nil {
+			return err
+		}
+	}
+	if err := c.write(conn); err != nil {
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(c.opts.Timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	framePayload, err := c.frameCodec.Decode(c.conn)
+	if err != nil {
+		return err
+	}
+	p, err := packet.Decode(framePayload)
+	if err != nil {
+		return err
+	}
+	connAck, ok := p.(*packet.ConnAck)
+	if !ok {
+		return errors.New("not connAck")
+	}
+	switch connAck.Result {
+	case packet.ResultOK:
+		if c.opts.SigningKey != nil {
+			if connAck.Nonce == nil {
+				return ErrNoSigning
+			}
+			c.signKey = packet.DeriveSessionKey(c.opts.SigningKey, id, conn.Nonce, connAck.Nonce)
+		}
+		c.window = connAck.Window
+		c.version, c.caps = connAck.Version, connAck.Caps
+		if c.version == 0 {
+			c.version = packet.ProtocolVersion1 // 旧版本的服务端不回复版本
+		}
+		if c.version >= packet.ProtocolVersion2 && c.caps&packet.CapBatching == 0 {
+			c.opts.BatchSize = 0
+		}
+		c.frameCodec = frame.NewTransformCodec(c.frameCodec, frame.Options{
+			Compress: c.caps&packet.CapCompression != 0,
+			Checksum: c.caps&packet.CapChecksum != 0,
+		})
+		return nil
+	case packet.ResultAuthFailed:
+		return ErrAuthFailed
+	case packet.ResultBadVersion:
+		return ErrBadVersion
+	default:
+		return fmt.Errorf("conn rejected, result = %d", connAck.Result)
+	}
+}
+
+// Version 返回握手时协商出的协议版本
+func (c *Client) Version() uint8 {
+	return c.version
+}
+
+// Caps 返回握手时协商出的能力（packet.CapXXX）
+func (c *Client) Caps() uint32 {
+	return c.caps
+}
+
+func (c *Client) nextID() string {
+	return fmt.Sprintf("%08d", c.counter.Add(1)%100000000) // ID 固定 8 字节
+}
+
+func (c *Client) write(p packet.Packet) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.signKey != nil {
+		switch t := p.(type) {
+		case *packet.Submit:
+			c.seq++
+			p = packet.SignSubmit(c.signKey, t, c.seq, time.Now())
+		case *packet.SubmitBatch:
+			// 批次中的每条消息单独签名，服务端按顺序校验 Seq
+			signed := make([]*packet.Submit, len(t.Entries))
+			for i, submit := range t.Entries {
+				c.seq++
+				signed[i] = packet.SignSubmit(c.signKey, submit, c.seq, time.Now())
+			}
+			p = packet.NewSubmitBatch(t.ID, signed)
+		}
+	}
+	framePayload, err := packet.EncodeWith(p, packet.OptionsFromCaps(c.caps))
+	if err != nil {
+		return err
+	}
+	return c.frameCodec.Encode(c.conn, framePayload)
+}
+
+// Send 提交一条消息，并等待服务端的 SubmitAck，超时后按 MaxRetries 重发。
+// 服务端通告的窗口已满时，按 FailFast 立即返回 ErrNoCredit 或等待窗口。
+// 开启批量发送时，返回的 SubmitAck 由 SubmitBatchAck 中对应的结果构造
+func (c *Client) Send(payload []byte) (*packet.SubmitAck, error) {
+	return c.SendContext(context.Background(), payload)
+}
+
+// SendContext 与 Send 相同，ctx 的截止时间随 Submit 一起发给服务端，handler 的 context 会在这个时间被取消。
+// ctx 在收到应答之前被取消时，向服务端发送 Cancel 并返回 ctx.Err()。
+// 批量发送时截止时间不会发给服务端，但取消仍然有效
+func (c *Client) SendContext(ctx context.Context, payload []byte) (*packet.SubmitAck, error) {
+	return c.send(ctx, c.nextID(), payload, c.opts.BatchSize > 1)
+}
+
+// SendID 与 SendContext 相同，但使用调用方指定的 ID（固定 8 字节，协商了 packet.CapLongIDs 时为 1~255 字节）。
+// 配合 Options.SessionID，断线重连后用原来的 ID 重发的消息只会被处理一次，服务端返回第一次处理的结果
+func (c *Client) SendID(ctx context.Context, id string, payload []byte) (*packet.SubmitAck, error) {
+	return c.send(ctx, id, payload, c.opts.BatchSize > 1)
+}
+
+// Call 与 SendContext 相同，但总是单独发送，不进入批次。
+// 批量应答只有结果，需要读取 SubmitAck.Payload（例如 RPC 的返回值）时使用 Call
+func (c *Client) Call(ctx context.Context, payload []byte) (*packet.SubmitAck, error) {
+	return c.send(ctx, c.nextID(), payload, false)
+}
+
+func (c *Client) send(ctx context.Context, id string, payload []byte, batch bool) (*packet.SubmitAck, error) {
+	if err := c.acquireCredit(ctx); err != nil {
+		return nil, err
+	}
+	defer c.releaseCredit() // 重发沿用同一份窗口，收到应答或放弃之后才归还
+	submit := packet.NewSubmit(id, payload)
+	if batch {
+		return c.sendBatched(ctx, submit)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		submit.Deadline = deadline
+	}
+	ack, err := c.request(ctx, id, submit, c.opts.MaxRetries)
+	if ctx.Err() != nil && err == ctx.Err() {
+		c.cancel(id)
+	}
+	if ack == nil {
+		return nil, err
+	}
+	return ack.(*packet.SubmitAck), err
+}
+
+// cancel 通知服务端不再需要 ID 对应的请求，服务端不回应答。发送失败时忽略：
+// 连接已经断开的话服务端会取消该连接上的所有请求，否则请求照常处理完，应答被丢弃
+func (c *Client) cancel(id string) {
+	c.write(packet.NewCancel(id))
+}
+
+// acquireCredit 占用一份发送窗口，窗口已满时等待其他 Submit 的应答或服务端放大窗口
+func (c *Client) acquireCredit(ctx context.Context) error {
+	var timeout <-chan time.Time
+	for {
+		c.mu.Lock()
+		if c.err != nil {
+			c.mu.Unlock()
+			return c.err
+		}
+		if c.window == 0 || c.inflight < c.window {
+			c.inflight++
+			c.mu.Unlock()
+			return nil
+		}
+		ch := c.creditCh
+		c.mu.Unlock()
+
+		if c.opts.FailFast {
+			return ErrNoCredit
+		}
+		if timeout == nil {
+			timer := time.NewTimer(c.opts.Timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-ch:
+		case <-c.done:
+		case <-timeout:
+			return ErrNoCredit
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *Client) releaseCredit() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight--
+	c.notifyCreditLocked()
+}
+
+// setWindow 处理服务端的 WindowUpdate
+func (c *Client) setWindow(n uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.window = n
+	c.notifyCreditLocked()
+}
+
+// notifyCreditLocked 唤醒所有等待窗口的 Send，调用方需持有 c.mu
+func (c *Client) notifyCreditLocked() {
+	close(c.creditCh)
+	c.creditCh = make(chan struct{})
+}
+
+// request 发送请求包 p 并等待 ID 相同的应答包，超时后最多重发 retries 次。
+// 只有服务端会按 ID 去重的请求才能重发。ctx 被取消时立即返回 ctx.Err()
+func (c *Client) request(ctx context.Context, id string, p packet.Packet, retries int) (packet.Packet, error) {
+	ch := make(chan packet.Packet, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	for attempt := 0; ; attempt++ {
+		if err := c.write(p); err != nil {
+			return nil, err
+		}
+		ack, err := c.wait(ctx, ch)
+		if err == ErrTimeout && attempt < retries {
+			continue // 应答可能丢了，用同一个 ID 重发
+		}
+		return ack, err
+	}
+}
+
+// wait 等待 ch 上的应答，直到超时、ctx 被取消或连接不可用
+func (c *Client) wait(ctx context.Context, ch chan packet.Packet) (packet.Packet, error) {
+	timer := time.NewTimer(c.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case ack := <-ch:
+		if ackResult(ack) == packet.ResultAuthFailed {
+			return ack, ErrAuthFailed
+		}
+		return ack, nil
+	case <-c.done:
+		return nil, c.Err()
+	case <-timer.C:
+		return nil, ErrTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// readLoop 是响应 goroutine，把服务端的应答分发给对应的 Send 调用
+func (c *Client) readLoop() {
+	for {
+		framePayload, err := c.frameCodec.Decode(c.conn)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		p, err := packet.DecodeWith(framePayload, packet.OptionsFromCaps(c.caps))
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch t := p.(type) {
+		case *packet.SubmitAck:
+			c.deliver(t.ID, t)
+		case *packet.SubmitBatchAck:
+			c.deliver(t.ID, t)
+		case *packet.SubscribeAck:
+			c.deliver(t.ID, t)
+		case *packet.UnsubscribeAck:
+			c.deliver(t.ID, t)
+		case *packet.PublishAck:
+			c.deliver(t.ID, t)
+		case *packet.TransferBeginAck:
+			c.deliver(t.ID, t)
+		case *packet.TransferChunkAck:
+			c.deliver(t.ID, t)
+		case *packet.TransferEndAck:
+			c.deliver(t.ID, t)
+		case *packet.WindowUpdate:
+			c.setWindow(t.Window)
+		case *packet.Publish:
+			// 服务端推送的消息交给分发 goroutine，避免回调阻塞应答的接收
+			select {
+			case c.messages <- t:
+			case <-c.done:
+				return
+			}
+		default:
+			c.fail(fmt.Errorf("unexpected packet %T", p))
+			return
+		}
+	}
+}
+
+// deliver 把应答交给等待该 ID 的请求
+func (c *Client) deliver(id string, ack packet.Packet) {
+	c.mu.Lock()
+	ch, ok := c.pending[id]
+	c.mu.Unlock()
+	if ok {
+		select { // 重复的应答直接丢弃，不阻塞响应 goroutine
+		case ch <- ack:
+		default:
+		}
+	}
+}
+
+// ackResult 取出各种应答包中的 Result
+func ackResult(p packet.Packet) uint8 {
+	switch t := p.(type) {
+	case *packet.SubmitAck:
+		return t.Result
+	case *packet.SubmitBatchAck:
+		return t.Result
+	case *packet.SubscribeAck:
+		return t.Result
+	case *packet.UnsubscribeAck:
+		return t.Result
+	case *packet.PublishAck:
+		return t.Result
+	case *packet.TransferBeginAck:
+		return t.Result
+	case *packet.TransferChunkAck:
+		return t.Result
+	case *packet.TransferEndAck:
+		return t.Result
+	default:
+		return packet.ResultError
+	}
+}
+
+// fail 记录连接不可用的原因，并唤醒所有等待中的 Send
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.conn.Close()
+}
+
+// Err 返回连接不可用的原因，连接正常时为 nil
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) Close() error {
+	c.fail(ErrClosed)
+	return nil
+}
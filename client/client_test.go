@@ -0,0 +1,488 @@
+package client
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/server"
+	"37_tcp-server-demo1/ws"
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer 在随机端口上启动 srv，测试结束时自动关闭
+func startServer(t *testing.T, srv *server.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func TestClient_Send(t *testing.T) {
+	addr := startServer(t, server.NewServer("", nil))
+	c, err := Dial(addr, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		submitAck, err := c.Send([]byte("hello"))
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if submitAck.Result != packet.ResultOK {
+			t.Errorf("want %d, actual %d", packet.ResultOK, submitAck.Result)
+		}
+	}
+}
+
+func TestClient_AuthFailed(t *testing.T) {
+	srv := server.NewServer("", nil)
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	addr := startServer(t, srv)
+
+	_, err := Dial(addr, Options{Token: []byte("wrong")})
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("want ErrAuthFailed, actual %v", err)
+	}
+	if IsRetryable(err) {
+		t.Errorf("want non-retryable, actual retryable")
+	}
+
+	c, err := Dial(addr, Options{Token: []byte("secret")})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	if _, err = c.Send([]byte("hello")); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+}
+
+func TestClient_SessionResume(t *testing.T) {
+	var calls atomic.Int32
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		calls.Add(1)
+		return packet.ResultOK
+	})
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	addr := startServer(t, srv)
+
+	if _, err := Dial(addr, Options{Token: []byte("secret"), SessionID: "short"}); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+
+	// 断线重连后用同一个 SessionID 和原来的 ID 重发，服务端只处理一次
+	opts := Options{Token: []byte("secret"), SessionID: "sess0001"}
+	for i := 0; i < 2; i++ {
+		c, err := Dial(addr, opts)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if c.Caps()&packet.CapSessionResume == 0 {
+			t.Errorf("want CapSessionResume, actual caps %d", c.Caps())
+		}
+		submitAck, err := c.SendID(context.Background(), "msg00001", []byte("hello"))
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if submitAck.Result != packet.ResultOK {
+			t.Errorf("want %d, actual %d", packet.ResultOK, submitAck.Result)
+		}
+		c.Close()
+	}
+	if calls.Load() != 1 {
+		t.Errorf("want 1, actual %d", calls.Load())
+	}
+}
+
+func TestClient_LongIDs(t *testing.T) {
+	var ids []string
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		ids = append(ids, submit.ID)
+		return packet.ResultOK
+	})
+	addr := startServer(t, srv)
+	c, err := Dial(addr, Options{Caps: packet.CapLongIDs})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	if c.Caps()&packet.CapLongIDs == 0 {
+		t.Fatalf("want CapLongIDs, actual caps %d", c.Caps())
+	}
+
+	// 协商之后 ID 可以不是 8 字节，自动生成的 ID 不受影响
+	id := "order-2026-10-19-000042"
+	submitAck, err := c.SendID(context.Background(), id, []byte("hello"))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if submitAck.ID != id || submitAck.Result != packet.ResultOK {
+		t.Errorf("want ok submitAck[%s], actual %v", id, submitAck)
+	}
+	if _, err = c.Send([]byte("world")); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if len(ids) != 2 || ids[0] != id || len(ids[1]) != 8 {
+		t.Errorf("want [%s <8 bytes>], actual %v", id, ids)
+	}
+}
+
+func TestClient_Signing(t *testing.T) {
+	var payload string
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		payload = string(submit.Payload)
+		return packet.ResultOK
+	})
+	srv.SigningKey = []byte("secret")
+	addr := startServer(t, srv)
+
+	c, err := Dial(addr, Options{SigningKey: []byte("secret")})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	submitAck, err := c.Send([]byte("hello"))
+	if err != nil || submitAck.Result != packet.ResultOK {
+		t.Errorf("want ok, actual %v %v", submitAck, err)
+	}
+	// handler 看到的是去掉签名 trailer 之后的 payload
+	if payload != "hello" {
+		t.Errorf("want hello, actual %s", payload)
+	}
+
+	// 密钥不一致时，签名校验失败
+	c2, err := Dial(addr, Options{SigningKey: []byte("other")})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c2.Close()
+	submitAck, err = c2.Send([]byte("hello"))
+	if err != nil || submitAck.Result != packet.ResultBadSignature {
+		t.Errorf("want bad signature, actual %v %v", submitAck, err)
+	}
+
+	// 服务端未开启签名
+	addr2 := startServer(t, server.NewServer("", nil))
+	if _, err = Dial(addr2, Options{SigningKey: []byte("secret")}); !errors.Is(err, ErrNoSigning) {
+		t.Errorf("want ErrNoSigning, actual %v", err)
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	var calls atomic.Int32
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		if calls.Add(1) == 1 {
+			time.Sleep(150 * time.Millisecond) // 第一次处理得很慢，让客户端超时重发
+		}
+		return packet.ResultOK
+	})
+	addr := startServer(t, srv)
+
+	c, err := Dial(addr, Options{Timeout: 100 * time.Millisecond, MaxRetries: 3})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	submitAck, err := c.Send([]byte("hello"))
+	if err != nil || submitAck.Result != packet.ResultOK {
+		t.Errorf("want ok, actual %v %v", submitAck, err)
+	}
+	// 重发的 Submit 被服务端去重，handler 只处理了一次
+	if _, err = c.Send([]byte("world")); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("want 2, actual %d", n)
+	}
+}
+
+// blockingServer 启动一个窗口为 window 的服务端，handler 收到 Submit 后通知 entered，
+// 并阻塞到 release 被关闭
+func blockingServer(t *testing.T, window uint32, onEnter func(sess *server.Session)) (addr string, entered chan string, release chan struct{}) {
+	entered = make(chan string, 16)
+	release = make(chan struct{})
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		if onEnter != nil {
+			onEnter(sess)
+		}
+		entered <- string(submit.Payload)
+		<-release
+		return packet.ResultOK
+	})
+	srv.Window = window
+	return startServer(t, srv), entered, release
+}
+
+func TestClient_CreditFailFast(t *testing.T) {
+	addr, entered, release := blockingServer(t, 1, nil)
+	c, err := Dial(addr, Options{FailFast: true})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.Send([]byte("first"))
+		errCh <- err
+	}()
+	<-entered
+	if _, err = c.Send([]byte("second")); !errors.Is(err, ErrNoCredit) {
+		t.Errorf("want ErrNoCredit, actual %v", err)
+	}
+	close(release)
+	if err = <-errCh; err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+	// 应答归还了窗口
+	if _, err = c.Send([]byte("third")); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+}
+
+func TestClient_CreditBlocking(t *testing.T) {
+	addr, entered, release := blockingServer(t, 1, nil)
+	c, err := Dial(addr, Options{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := c.Send([]byte("first"))
+		errCh <- err
+	}()
+	<-entered
+	go func() {
+		_, err := c.Send([]byte("second"))
+		errCh <- err
+	}()
+	select {
+	case err = <-errCh:
+		t.Fatalf("want second send blocked, actual returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	c.mu.Lock()
+	inflight := c.inflight
+	c.mu.Unlock()
+	if inflight != 1 {
+		t.Errorf("want 1 inflight, actual %d", inflight)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err = <-errCh; err != nil {
+			t.Errorf("want nil, actual %s", err.Error())
+		}
+	}
+	if payload := <-entered; payload != "second" {
+		t.Errorf("want second, actual %s", payload)
+	}
+
+	// 等不到窗口时超时返回 ErrNoCredit
+	c2, err := Dial(addr, Options{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c2.Close()
+	c2.mu.Lock()
+	c2.inflight = c2.window // 模拟窗口已被占满
+	c2.mu.Unlock()
+	if _, err = c2.Send([]byte("third")); !errors.Is(err, ErrNoCredit) {
+		t.Errorf("want ErrNoCredit, actual %v", err)
+	}
+}
+
+func TestClient_WindowUpdate(t *testing.T) {
+	addr, entered, release := blockingServer(t, 1, func(sess *server.Session) {
+		if err := sess.SetWindow(2); err != nil {
+			t.Errorf("want nil, actual %s", err.Error())
+		}
+	})
+	c, err := Dial(addr, Options{FailFast: true})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	if c.window != 1 {
+		t.Errorf("want window 1 from ConnAck, actual %d", c.window)
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := c.Send([]byte("first"))
+		errCh <- err
+	}()
+	<-entered
+	// WindowUpdate 先于 handler 的通知写出，但响应 goroutine 可能还没处理完
+	deadline := time.Now().Add(time.Second)
+	for {
+		c.mu.Lock()
+		window := c.window
+		c.mu.Unlock()
+		if window == 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, err := c.Send([]byte("second"))
+		errCh <- err
+	}()
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err = <-errCh; err != nil {
+			t.Errorf("want nil, actual %v", err)
+		}
+	}
+}
+
+func TestClient_SendContext(t *testing.T) {
+	type call struct {
+		deadline bool
+		err      error
+	}
+	calls := make(chan call, 2)
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		_, ok := ctx.Deadline()
+		<-ctx.Done()
+		calls <- call{ok, ctx.Err()}
+		return packet.ResultCanceled
+	})
+	addr := startServer(t, srv)
+	c, err := Dial(addr, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+
+	// 截止时间随 Submit 发给服务端
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = c.SendContext(ctx, []byte("hello")); err != context.DeadlineExceeded {
+		t.Errorf("want context.DeadlineExceeded, actual %v", err)
+	}
+	if got := <-calls; !got.deadline || got.err == nil {
+		t.Errorf("want handler with deadline canceled, actual %v", got)
+	}
+
+	// 主动取消时服务端收到 Cancel
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel2)
+	if _, err = c.SendContext(ctx2, []byte("hello")); err != context.Canceled {
+		t.Errorf("want context.Canceled, actual %v", err)
+	}
+	select {
+	case got := <-calls:
+		if got.deadline || got.err != context.Canceled {
+			t.Errorf("want handler canceled without deadline, actual %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("want handler canceled by Cancel packet, actual still running")
+	}
+}
+
+func TestClient_Caps(t *testing.T) {
+	var received atomic.Int64
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		received.Add(int64(len(submit.Payload)))
+		return packet.ResultOK
+	})
+	srv.DisableCaps = packet.CapBatching
+	addr := startServer(t, srv)
+
+	c, err := Dial(addr, Options{Caps: packet.CapCompression | packet.CapChecksum, BatchSize: 4})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	if c.Version() != packet.ProtocolVersion2 {
+		t.Errorf("want %d, actual %d", packet.ProtocolVersion2, c.Version())
+	}
+	if want := uint32(packet.CapCompression | packet.CapChecksum); c.Caps() != want {
+		t.Errorf("want %d, actual %d", want, c.Caps())
+	}
+
+	// 服务端不同意批量发送，退回逐条发送；较长的负载会被压缩
+	payload := make([]byte, 64<<10)
+	for i := 0; i < 3; i++ {
+		submitAck, err := c.Send(payload)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if submitAck.Result != packet.ResultOK {
+			t.Errorf("want %d, actual %d", packet.ResultOK, submitAck.Result)
+		}
+	}
+	if received.Load() != 3*int64(len(payload)) {
+		t.Errorf("want %d, actual %d", 3*len(payload), received.Load())
+	}
+}
+
+func TestClient_FrameCodec(t *testing.T) {
+	newCodec, err := frame.ParseCodec("uvarint")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	srv := server.NewServer("", nil)
+	srv.FrameCodec = newCodec
+	addr := startServer(t, srv)
+
+	c, err := Dial(addr, Options{FrameCodec: newCodec, Caps: packet.CapChecksum})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	submitAck, err := c.Send([]byte("hello"))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if submitAck.Result != packet.ResultOK {
+		t.Errorf("want %d, actual %d", packet.ResultOK, submitAck.Result)
+	}
+}
+
+func TestClient_WebSocket(t *testing.T) {
+	srv := server.NewServer("", func(ctx context.Context, sess *server.Session, submit *packet.Submit) uint8 {
+		if string(submit.Payload) == "bad" {
+			return packet.ResultError
+		}
+		return packet.ResultOK
+	})
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	gateway := httptest.NewServer(srv.WebSocketHandler())
+	defer gateway.Close()
+	defer srv.Close()
+
+	conn, err := ws.Dial("ws"+strings.TrimPrefix(gateway.URL, "http"), nil, time.Second)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	c, err := New(conn, Options{Token: []byte("secret"), FrameCodec: ws.NewFrameCodec, Caps: packet.CapCompression})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	for payload, want := range map[string]uint8{"hello": packet.ResultOK, "bad": packet.ResultError} {
+		submitAck, err := c.Send([]byte(payload))
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if submitAck.Result != want {
+			t.Errorf("want %d, actual %d", want, submitAck.Result)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"37_tcp-server-demo1/packet"
+	"context"
+	"fmt"
+)
+
+// Message 是服务端推送的一条订阅消息
+type Message struct {
+	Topic   string
+	Payload []byte
+}
+
+// Subscribe 订阅 topic（可以包含 + 和 # 通配符），收到匹配的消息时调用 handler。
+// handler 在同一个分发 goroutine 中依次调用，不要在其中长时间阻塞；
+// 对同一个 topic 重复订阅会替换之前的 handler。服务端确认订阅之后才安装 handler，被拒绝时保持原来的订阅
+func (c *Client) Subscribe(topic string, handler func(*Message)) error {
+	if err := packet.ValidateTopicFilter(topic); err != nil {
+		return err
+	}
+	id := c.nextID()
+	ack, err := c.request(context.Background(), id, packet.NewSubscribe(id, topic), 0)
+	if err == nil && ackResult(ack) != packet.ResultOK {
+		err = fmt.Errorf("subscribe %s rejected, result = %d", topic, ackResult(ack))
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.handlers[topic] = handler
+	c.mu.Unlock()
+	return nil
+}
+
+// SubscribeChan 与 Subscribe 相同，只是把消息写入 ch。ch 写满时分发 goroutine 会阻塞
+func (c *Client) SubscribeChan(topic string, ch chan<- *Message) error {
+	return c.Subscribe(topic, func(m *Message) {
+		select {
+		case ch <- m:
+		case <-c.done:
+		}
+	})
+}
+
+// Unsubscribe 取消订阅，topic 必须与 Subscribe 时完全一致。服务端确认之后才移除 handler
+func (c *Client) Unsubscribe(topic string) error {
+	id := c.nextID()
+	ack, err := c.request(context.Background(), id, packet.NewUnsubscribe(id, topic), 0)
+	if err == nil && ackResult(ack) != packet.ResultOK {
+		err = fmt.Errorf("unsubscribe %s rejected, result = %d", topic, ackResult(ack))
+	}
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	delete(c.handlers, topic)
+	c.mu.Unlock()
+	return nil
+}
+
+// Publish 向 topic 发布一条消息，由服务端推送给所有订阅者
+func (c *Client) Publish(topic string, payload []byte) error {
+	if err := packet.ValidateTopic(topic); err != nil {
+		return err
+	}
+	id := c.nextID()
+	ack, err := c.request(context.Background(), id, packet.NewPublish(id, topic, payload), 0)
+	if err == nil && ackResult(ack) != packet.ResultOK {
+		err = fmt.Errorf("publish %s rejected, result = %d", topic, ackResult(ack))
+	}
+	return err
+}
+
+// dispatchLoop 是分发 goroutine，把服务端推送的消息交给所有匹配的 handler
+func (c *Client) dispatchLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case p := <-c.messages:
+			m := &Message{Topic: p.Topic, Payload: p.Payload}
+			c.mu.Lock()
+			var handlers []func(*Message)
+			for topic, handler := range c.handlers {
+				if packet.MatchTopic(topic, p.Topic) {
+					handlers = append(handlers, handler)
+				}
+			}
+			c.mu.Unlock()
+			for _, handler := range handlers {
+				handler(m)
+			}
+		}
+	}
+}
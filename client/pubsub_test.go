@@ -0,0 +1,128 @@
+package client
+
+import (
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/server"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClient_PubSub(t *testing.T) {
+	srv := server.NewServer("", nil)
+	addr := startServer(t, srv)
+
+	sub, err := Dial(addr, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer sub.Close()
+	pub, err := Dial(addr, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer pub.Close()
+
+	ch := make(chan *Message, 10)
+	if err = sub.SubscribeChan("sensor/+/temp", ch); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if err = sub.Subscribe("bad/#/topic", func(*Message) {}); err == nil {
+		t.Errorf("want invalid topic error, actual nil")
+	}
+
+	if err = pub.Publish("sensor/room1/temp", []byte("21")); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if err = pub.Publish("sensor/room1/humidity", []byte("40")); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	// 服务端自己也可以发布消息
+	if n, err := srv.Publish("sensor/room2/temp", []byte("22")); err != nil || n != 1 {
+		t.Errorf("want 1/nil, actual %d/%v", n, err)
+	}
+
+	for _, want := range []string{"sensor/room1/temp:21", "sensor/room2/temp:22"} {
+		select {
+		case m := <-ch:
+			if got := m.Topic + ":" + string(m.Payload); got != want {
+				t.Errorf("want %s, actual %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("want %s, actual timeout", want)
+		}
+	}
+
+	if err = sub.Unsubscribe("sensor/+/temp"); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if n, _ := srv.Publish("sensor/room1/temp", []byte("23")); n != 0 {
+		t.Errorf("want 0, actual %d", n)
+	}
+}
+
+// TestClient_SubscribeRejected 用一个拒绝所有订阅请求的服务端，检查本地的订阅状态只在服务端确认之后改变
+func TestClient_SubscribeRejected(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		codec := frame.NewMyFrameCodec()
+		for {
+			framePayload, err := codec.Decode(conn)
+			if err != nil {
+				return
+			}
+			var ack packet.Packet
+			switch p, _ := packet.Decode(framePayload); p := p.(type) {
+			case *packet.Conn:
+				ack = packet.NewConnAck(p.ID, packet.ResultOK)
+			case *packet.Subscribe:
+				ack = packet.NewSubscribeAck(p.ID, packet.ResultError)
+			case *packet.Unsubscribe:
+				ack = packet.NewUnsubscribeAck(p.ID, packet.ResultError)
+			default:
+				return
+			}
+			ackFramePayload, _ := packet.Encode(ack)
+			codec.Encode(conn, ackFramePayload)
+		}
+	}()
+
+	c, err := Dial(l.Addr().String(), Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	if err = c.Subscribe("a/b", func(*Message) {}); err == nil {
+		t.Errorf("want rejected, actual nil")
+	}
+	c.mu.Lock()
+	_, ok := c.handlers["a/b"]
+	c.mu.Unlock()
+	if ok {
+		t.Errorf("want no handler after rejected subscribe, actual installed")
+	}
+
+	// 被拒绝的取消订阅保留原来的 handler
+	c.mu.Lock()
+	c.handlers["a/b"] = func(*Message) {}
+	c.mu.Unlock()
+	if err = c.Unsubscribe("a/b"); err == nil {
+		t.Errorf("want rejected, actual nil")
+	}
+	c.mu.Lock()
+	_, ok = c.handlers["a/b"]
+	c.mu.Unlock()
+	if !ok {
+		t.Errorf("want handler kept after rejected unsubscribe, actual removed")
+	}
+}
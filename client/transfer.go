@@ -0,0 +1,125 @@
+package client
+
+import (
+	"37_tcp-server-demo1/packet"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+var ErrDigestMismatch = errors.New("transfer digest mismatch") // 服务端校验失败并丢弃了已收到的数据，需要从头重传
+
+// Upload 是一次分块传输，实现了 io.WriteCloser。
+//
+// 续传：断线后用同一个 TransferID 重新调用 Client.Upload，并把完整内容从头再写一遍即可。
+// 服务端已经确认的前 Offset() 个字节只参与本地摘要计算，不会再次发送
+type Upload struct {
+	c          *Client
+	transferID string
+	offset     uint64 // 服务端已经确认的字节数
+	written    uint64 // 调用方已经写入的字节数
+	hash       hash.Hash
+	buf        []byte // 尚未发送的数据
+	closed     bool
+}
+
+// Upload 开始（或续传）一次分块传输
+func (c *Client) Upload(transferID string) (*Upload, error) {
+	if err := packet.ValidateTransferID(transferID); err != nil {
+		return nil, err
+	}
+	id := c.nextID()
+	ack, err := c.request(context.Background(), id, &packet.TransferBegin{ID: id, TransferID: transferID}, c.opts.MaxRetries)
+	if err != nil {
+		return nil, err
+	}
+	beginAck := ack.(*packet.TransferBeginAck)
+	if beginAck.Result != packet.ResultOK {
+		return nil, fmt.Errorf("transfer %s rejected, result = %d", transferID, beginAck.Result)
+	}
+	return &Upload{
+		c:          c,
+		transferID: transferID,
+		offset:     beginAck.Offset,
+		hash:       sha256.New(),
+	}, nil
+}
+
+// Offset 返回服务端已经确认收到的字节数
+func (u *Upload) Offset() int64 {
+	return int64(u.offset)
+}
+
+func (u *Upload) Write(p []byte) (int, error) {
+	if u.closed {
+		return 0, ErrClosed
+	}
+	n := len(p)
+	u.hash.Write(p)
+	start := u.written
+	u.written += uint64(len(p))
+	if u.written <= u.offset {
+		return n, nil // 这部分服务端已经有了
+	}
+	if start < u.offset {
+		p = p[u.offset-start:]
+	}
+	u.buf = append(u.buf, p...)
+	for len(u.buf) >= u.c.opts.ChunkSize {
+		if err := u.flush(u.c.opts.ChunkSize); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// flush 发送 buf 中前 n 个字节，并等待服务端确认
+func (u *Upload) flush(n int) error {
+	id := u.c.nextID()
+	chunk := &packet.TransferChunk{ID: id, TransferID: u.transferID, Offset: u.offset, Data: u.buf[:n]}
+	ack, err := u.c.request(context.Background(), id, chunk, u.c.opts.MaxRetries)
+	if err != nil {
+		return err
+	}
+	chunkAck := ack.(*packet.TransferChunkAck)
+	if chunkAck.Result != packet.ResultOK {
+		return fmt.Errorf("transfer %s chunk at %d rejected, result = %d, server offset = %d",
+			u.transferID, u.offset, chunkAck.Result, chunkAck.Offset)
+	}
+	u.offset += uint64(n)
+	u.buf = u.buf[n:]
+	return nil
+}
+
+// Close 发送剩余数据，并请求服务端校验总长度和摘要
+func (u *Upload) Close() error {
+	if u.closed {
+		return nil
+	}
+	u.closed = true
+	if u.written < u.offset {
+		return fmt.Errorf("transfer %s: wrote %d bytes, less than server offset %d", u.transferID, u.written, u.offset)
+	}
+	if len(u.buf) > 0 {
+		if err := u.flush(len(u.buf)); err != nil {
+			return err
+		}
+	}
+	id := u.c.nextID()
+	end := &packet.TransferEnd{ID: id, TransferID: u.transferID, Size: u.written}
+	copy(end.Digest[:], u.hash.Sum(nil))
+	ack, err := u.c.request(context.Background(), id, end, 0)
+	if err != nil {
+		return err
+	}
+	switch result := ack.(*packet.TransferEndAck).Result; result {
+	case packet.ResultOK:
+		return nil
+	case packet.ResultDigestMismatch:
+		return ErrDigestMismatch
+	default:
+		return fmt.Errorf("transfer %s end rejected, result = %d", u.transferID, result)
+	}
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/server"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClient_UploadResume(t *testing.T) {
+	dir := t.TempDir()
+	srv := server.NewServer("", nil)
+	srv.TransferDir = dir
+	addr := startServer(t, srv)
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+
+	// 第一次只传了一部分，连接就断了
+	c, err := Dial(addr, Options{ChunkSize: 1024})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	u, err := c.Upload("file-1")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if _, err = u.Write(data[:3000]); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	c.Close()
+
+	// 重连后从服务端已确认的位置续传
+	c, err = Dial(addr, Options{ChunkSize: 1024})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+	u, err = c.Upload("file-1")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if u.Offset() != 2048 {
+		t.Errorf("want 2048, actual %d", u.Offset())
+	}
+	if _, err = io.Copy(u, bytes.NewReader(data)); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if err = u.Close(); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+
+	saved, err := os.ReadFile(filepath.Join(dir, "file-1"))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if !bytes.Equal(saved, data) {
+		t.Errorf("want %d bytes, actual %d", len(data), len(saved))
+	}
+}
+
+func TestClient_UploadHandler(t *testing.T) {
+	var received []byte
+	srv := server.NewServer("", nil)
+	srv.TransferDir = t.TempDir()
+	srv.TransferHandler = func(sess *server.Session, transferID string, r io.Reader, size int64) uint8 {
+		received, _ = io.ReadAll(r)
+		return packet.ResultOK
+	}
+	addr := startServer(t, srv)
+	c, err := Dial(addr, Options{ChunkSize: 4})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+
+	u, err := c.Upload("file-2")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	u.Write([]byte("hello world"))
+	if err = u.Close(); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if string(received) != "hello world" {
+		t.Errorf("want hello world, actual %s", received)
+	}
+}
+
+func TestClient_UploadDigestMismatch(t *testing.T) {
+	srv := server.NewServer("", nil)
+	srv.TransferDir = t.TempDir()
+	addr := startServer(t, srv)
+	c, err := Dial(addr, Options{ChunkSize: 4})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c.Close()
+
+	// 传了 8 个字节之后中断
+	u, _ := c.Upload("file-3")
+	u.Write([]byte("hello world"))
+
+	// 续传时写入的内容与之前不一致，摘要校验失败
+	u, err = c.Upload("file-3")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	u.Write([]byte("HELLO WORLD!"))
+	if err = u.Close(); !errors.Is(err, ErrDigestMismatch) {
+		t.Errorf("want ErrDigestMismatch, actual %v", err)
+	}
+
+	// 未开启分块传输的服务端直接拒绝
+	addr2 := startServer(t, server.NewServer("", nil))
+	c2, err := Dial(addr2, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer c2.Close()
+	if _, err = c2.Upload("file-4"); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
+
+func TestClient_UploadPrincipals(t *testing.T) {
+	dir := t.TempDir()
+	srv := server.NewServer("", nil)
+	srv.TransferDir = dir
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"a-token": "alice", "b-token": "bob"})
+	addr := startServer(t, srv)
+	alice, err := Dial(addr, Options{Token: []byte("a-token"), ChunkSize: 4})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer alice.Close()
+	bob, err := Dial(addr, Options{Token: []byte("b-token"), ChunkSize: 4})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer bob.Close()
+
+	// alice 传了一部分，bob 使用同一个 TransferID 时看不到 alice 的数据
+	u, _ := alice.Upload("shared")
+	u.Write([]byte("from alice"))
+	u2, err := bob.Upload("shared")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if u2.Offset() != 0 {
+		t.Errorf("want 0, actual %d", u2.Offset())
+	}
+	u2.Write([]byte("from bob"))
+	if err = u2.Close(); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if err = u.Close(); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	for name, want := range map[string]string{"@alice": "from alice", "@bob": "from bob"} {
+		saved, err := os.ReadFile(filepath.Join(dir, name, "shared"))
+		if err != nil || string(saved) != want {
+			t.Errorf("want %s, actual %q %v", want, saved, err)
+		}
+	}
+
+	// 已经完成的文件不会被覆盖
+	u2, _ = bob.Upload("shared")
+	u2.Write([]byte("overwrite"))
+	if err = u2.Close(); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	if saved, _ := os.ReadFile(filepath.Join(dir, "@bob", "shared")); string(saved) != "from bob" {
+		t.Errorf("want from bob, actual %q", saved)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"37_tcp-server-demo1/config"
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/transport"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+)
+
+// envPrefix 为客户端环境变量的前缀，例如 TCPCLIENT_ADDR、TCPCLIENT_TOKEN
+const envPrefix = "TCPCLIENT_"
+
+// Config 为客户端的全部配置，字段名即配置文件中的键（见 config.Parse）
+type Config struct {
+	Addr     string          `json:"addr"`
+	Frame    string          `json:"frame"`
+	Token    string          `json:"token"`
+	Timeout  config.Duration `json:"timeout"`
+	Clients  int             `json:"clients"`  // 并发的客户端 goroutine 数
+	Messages int             `json:"messages"` // 每个客户端发送的请求数
+	Interval config.Duration `json:"interval"` // 两次请求之间的间隔
+}
+
+// defaultConfig 与最初硬编码在 main 中的行为一致
+func defaultConfig() *Config {
+	return &Config{
+		Addr:     ":8080",
+		Frame:    "length",
+		Timeout:  config.Duration(5 * time.Second),
+		Clients:  5,
+		Messages: 10,
+		Interval: config.Duration(time.Second),
+	}
+}
+
+// loadConfig 按 默认值 < 配置文件 < 环境变量 < 命令行参数 合并出配置并校验。printConfig 为是否指定了 -print-config
+func loadConfig(args []string) (cfg *Config, printConfig bool, err error) {
+	cfg = defaultConfig()
+	fs := flag.NewFlagSet("client", flag.ContinueOnError)
+	fs.String("config", "", "config file (JSON), env "+config.EnvName(envPrefix, "config"))
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "server address: host:port, unix:///path/to.sock or unix://@abstract")
+	fs.StringVar(&cfg.Frame, "frame", cfg.Frame, "frame codec, must match the server")
+	fs.StringVar(&cfg.Token, "token", cfg.Token, "authentication token, prefer env "+config.EnvName(envPrefix, "token"))
+	fs.Var(&cfg.Timeout, "timeout", "dial and ack timeout")
+	fs.IntVar(&cfg.Clients, "clients", cfg.Clients, "number of concurrent clients")
+	fs.IntVar(&cfg.Messages, "messages", cfg.Messages, "submits sent by each client")
+	fs.Var(&cfg.Interval, "interval", "interval between two submits")
+	if err = config.Parse(fs, args, envPrefix, "config", cfg); err != nil {
+		return nil, false, err
+	}
+	return cfg, printConfig, cfg.Validate()
+}
+
+// Validate 检查配置，返回所有有问题的字段
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+	_, _, err := transport.ParseAddr(c.Addr)
+	check("addr", err)
+	_, err = frame.ParseBinaryCodec(c.Frame)
+	check("frame", err)
+	if c.Timeout <= 0 {
+		check("timeout", errors.New("must be positive"))
+	}
+	if c.Clients <= 0 {
+		check("clients", errors.New("must be positive"))
+	}
+	if c.Messages < 0 {
+		check("messages", errors.New("must not be negative"))
+	}
+	if c.Interval < 0 {
+		check("interval", errors.New("must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted 返回隐去令牌的副本，用于 -print-config
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if redacted.Token != "" {
+		redacted.Token = "REDACTED"
+	}
+	return &redacted
+}
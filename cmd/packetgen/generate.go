@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// PacketImport 为生成的代码不在 packet 包中时引用 packet 包的路径
+const PacketImport = "37_tcp-server-demo1/packet"
+
+type generator struct {
+	schema *Schema
+	source string // 协议描述文件名，写在生成文件的开头
+	buf    bytes.Buffer
+}
+
+func newGenerator(s *Schema, source string) *generator {
+	return &generator{schema: s, source: source}
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// qualify 在生成的代码不属于 packet 包时给 packet 包中的标识符加上包名
+func (g *generator) qualify(name string) string {
+	if g.schema.Package == "packet" {
+		return name
+	}
+	return "packet." + name
+}
+
+func (g *generator) header(imports ...string) {
+	g.printf("// Code generated by packetgen from %s. DO NOT EDIT.\n\n", g.source)
+	g.printf("package %s\n\n", g.schema.Package)
+	if g.schema.Package != "packet" {
+		imports = append([]string{PacketImport}, imports...)
+	}
+	if len(imports) > 0 {
+		g.printf("import (\n")
+		for _, path := range imports {
+			g.printf("%q\n", path)
+		}
+		g.printf(")\n\n")
+	}
+}
+
+func (g *generator) format() ([]byte, error) {
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// GenerateGo 生成包类型、构造函数、按 Options 编解码的 EncodeWith/DecodeWith（Encode/Decode 使用默认的 Options）以及命令字的登记。
+// 命令字常量 Command<Name> 不由生成器声明，需要与其他命令字一起手写，生成的测试会检查它与协议描述一致
+func GenerateGo(s *Schema, source string) ([]byte, error) {
+	g := newGenerator(s, source)
+	var imports []string
+	if g.usesBinary() {
+		imports = append(imports, "encoding/binary")
+	}
+	imports = append(imports, "errors")
+	if g.usesMax() {
+		imports = append(imports, "fmt")
+	}
+	g.header(imports...)
+	for i := range s.Packets {
+		g.packet(&s.Packets[i])
+	}
+	g.printf("func init() {\n")
+	for _, p := range s.Packets {
+		g.printf("%s(Command%s, func() %s { return &%s{} })\n", g.qualify("Register"), p.Name, g.qualify("Packet"), p.Name)
+	}
+	g.printf("}\n")
+	return g.format()
+}
+
+func (g *generator) usesBinary() bool {
+	for _, p := range g.schema.Packets {
+		for _, f := range p.Fields {
+			if f.Type == "uint16" || f.Type == "uint32" || f.Type == "uint64" || f.Len > 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *generator) usesMax() bool {
+	for _, p := range g.schema.Packets {
+		for _, f := range p.Fields {
+			if f.Max != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *generator) packet(p *PacketSpec) {
+	for _, line := range p.Doc {
+		g.printf("// %s\n", line)
+	}
+	g.printf("type %s struct {\n", p.Name)
+	for _, f := range p.Fields {
+		g.printf("%s %s", f.Name, f.goType())
+		if f.Doc != "" {
+			g.printf(" // %s", f.Doc)
+		}
+		g.printf("\n")
+	}
+	g.printf("}\n\n")
+
+	var params, assigns []string
+	for _, f := range p.Fields {
+		params = append(params, f.Name+" "+f.goType())
+		assigns = append(assigns, f.Name+": "+f.Name)
+	}
+	g.printf("func New%s(%s) *%s {\n", p.Name, strings.Join(params, ", "), p.Name)
+	g.printf("return &%s{%s}\n}\n\n", p.Name, strings.Join(assigns, ", "))
+
+	g.printf("// Command 返回 %s 的命令字\n", p.Name)
+	g.printf("func (p *%s) Command() uint8 {\nreturn Command%s\n}\n\n", p.Name, p.Name)
+
+	g.printf("// %s 的包体格式：%s\n", p.Name, bodyLayout(p))
+	g.printf("func (p *%s) Decode(packetBody []byte) error {\nreturn p.DecodeWith(packetBody, %s{})\n}\n\n", p.Name, g.qualify("Options"))
+	g.decode(p)
+	g.printf("func (p *%s) Encode() ([]byte, error) {\nreturn p.EncodeWith(%s{})\n}\n\n", p.Name, g.qualify("Options"))
+	g.encode(p)
+}
+
+// hasID 判断包是否有 id 类型的字段，有时 EncodeWith/DecodeWith 需要声明 err
+func (p *PacketSpec) hasID() bool {
+	for _, f := range p.Fields {
+		if f.Type == "id" {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyLayout 返回包体格式的说明，例如 ID(8字节) + Window(4字节，大端)
+func bodyLayout(p *PacketSpec) string {
+	if len(p.Fields) == 0 {
+		return "空"
+	}
+	var parts []string
+	for _, f := range p.Fields {
+		var part string
+		switch {
+		case f.Type == "uint8":
+			part = fmt.Sprintf("%s(1字节)", f.Name)
+		case f.Type == "id":
+			part = fmt.Sprintf("%s(8字节)", f.Name)
+		case f.isUint():
+			part = fmt.Sprintf("%s(%d字节，大端)", f.Name, f.size())
+		case f.Fixed > 0:
+			part = fmt.Sprintf("%s(%d字节)", f.Name, f.Fixed)
+		case f.Len == 1:
+			part = fmt.Sprintf("%s长度(1字节) + %s", f.Name, f.Name)
+		case f.Len > 0:
+			part = fmt.Sprintf("%s长度(%d字节，大端) + %s", f.Name, f.Len, f.Name)
+		default:
+			part = f.Name
+		}
+		if f.Optional {
+			part = "[" + part + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " + ")
+}
+
+func (g *generator) decode(p *PacketSpec) {
+	g.printf("// DecodeWith 按 opts 解码 %s 的包体\n", p.Name)
+	g.printf("func (p *%s) DecodeWith(packetBody []byte, opts %s) error {\n", p.Name, g.qualify("Options"))
+	g.printf("if packetBody == nil {\nreturn errors.New(\"packetBody is nil\")\n}\n")
+	if len(p.Fields) > 0 {
+		g.printf("body := packetBody\n")
+	}
+	if p.hasID() {
+		g.printf("var err error\n")
+	}
+	// 包体中没有携带的可选字段保持零值
+	for _, f := range p.Fields {
+		if f.Optional {
+			g.printf("p.%s = %s\n", f.Name, zeroValue(&f))
+		}
+	}
+	for i, f := range p.Fields {
+		last := i == len(p.Fields)-1
+		if f.Optional {
+			g.printf("if len(body) > 0 {\n")
+		}
+		g.decodeField(&f, last)
+	}
+	for _, f := range p.Fields {
+		if f.Optional {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return nil\n}\n\n")
+}
+
+func (g *generator) decodeField(f *FieldSpec, last bool) {
+	tooShort := "return errors.New(\"packetBody too short\")"
+	conv := func(b string) string {
+		if f.Type == "string" {
+			return "string(" + b + ")"
+		}
+		return b
+	}
+	advance := func(n string) {
+		if !last {
+			g.printf("body = body[%s:]\n", n)
+		}
+	}
+	switch {
+	case f.Type == "id":
+		g.printf("if p.%s, body, err = %s(body, opts); err != nil {\nreturn err\n}\n", f.Name, g.qualify("ReadID"))
+	case f.Rest:
+		if f.NonEmpty {
+			g.printf("if len(body) == 0 {\n%s\n}\n", tooShort)
+		}
+		if f.Type == "string" {
+			g.printf("p.%s = string(body)\n", f.Name)
+		} else {
+			g.printf("p.%s = nil\nif len(body) > 0 {\np.%s = body\n}\n", f.Name, f.Name)
+		}
+	case f.isUint():
+		n := f.size()
+		g.printf("if len(body) < %d {\n%s\n}\n", n, tooShort)
+		if n == 1 {
+			g.printf("p.%s = body[0]\n", f.Name)
+		} else {
+			g.printf("p.%s = binary.BigEndian.Uint%d(body)\n", f.Name, 8*n)
+		}
+		advance(fmt.Sprint(n))
+	case f.Type == "array":
+		g.printf("if len(body) < %d {\n%s\n}\n", f.Fixed, tooShort)
+		g.printf("copy(p.%s[:], body[:%d])\n", f.Name, f.Fixed)
+		advance(fmt.Sprint(f.Fixed))
+	case f.Fixed > 0:
+		g.printf("if len(body) < %d {\n%s\n}\n", f.Fixed, tooShort)
+		g.printf("p.%s = %s\n", f.Name, conv(fmt.Sprintf("body[:%d]", f.Fixed)))
+		advance(fmt.Sprint(f.Fixed))
+	default: // len
+		n := lowerFirst(f.Name) + "Len"
+		g.printf("if len(body) < %d {\n%s\n}\n", f.Len, tooShort)
+		if f.Len == 1 {
+			g.printf("%s := int(body[0])\n", n)
+		} else {
+			g.printf("%s := int(binary.BigEndian.Uint%d(body))\n", n, 8*f.Len)
+		}
+		g.printf("body = body[%d:]\n", f.Len)
+		if f.NonEmpty {
+			g.printf("if %s == 0 || len(body) < %s {\n%s\n}\n", n, n, tooShort)
+		} else {
+			g.printf("if len(body) < %s {\n%s\n}\n", n, tooShort)
+		}
+		g.printf("p.%s = %s\n", f.Name, conv(fmt.Sprintf("body[:%s]", n)))
+		advance(n)
+	}
+}
+
+func (g *generator) encode(p *PacketSpec) {
+	g.printf("// EncodeWith 按 opts 编码 %s 的包体\n", p.Name)
+	g.printf("func (p *%s) EncodeWith(opts %s) ([]byte, error) {\n", p.Name, g.qualify("Options"))
+	g.printf("body := make([]byte, 0, %d)\n", p.minBodyLen())
+	if p.hasID() {
+		g.printf("var err error\n")
+	}
+	wrapped := 0
+	for i, f := range p.Fields {
+		if trailingOptional(p.Fields[i:]) {
+			// 后面的可选字段有值时，这个字段即使是零值也要写入占位
+			var conds []string
+			for _, later := range p.Fields[i:] {
+				conds = append(conds, nonZero(&later))
+			}
+			g.printf("if %s {\n", strings.Join(conds, " || "))
+			wrapped++
+		}
+		g.encodeField(&f)
+	}
+	g.printf("%s", strings.Repeat("}\n", wrapped))
+	g.printf("return body, nil\n}\n\n")
+}
+
+func (g *generator) encodeField(f *FieldSpec) {
+	if f.Max != "" {
+		g.printf("if p.%s > %s {\nreturn nil, fmt.Errorf(\"unknown %s [%%d]\", p.%s)\n}\n", f.Name, f.Max, lowerFirst(f.Name), f.Name)
+	}
+	if f.NonEmpty {
+		g.printf("if len(p.%s) == 0 {\nreturn nil, errors.New(\"%s is empty\")\n}\n", f.Name, lowerFirst(f.Name))
+	}
+	if f.Validate != "" {
+		g.printf("if err := %s(p.%s); err != nil {\nreturn nil, err\n}\n", f.Validate, f.Name)
+	}
+	switch {
+	case f.Type == "id":
+		g.printf("if body, err = %s(body, p.%s, opts); err != nil {\nreturn nil, err\n}\n", g.qualify("AppendID"), f.Name)
+	case f.Rest:
+		g.printf("body = append(body, p.%s...)\n", f.Name)
+	case f.Type == "uint8":
+		g.printf("body = append(body, p.%s)\n", f.Name)
+	case f.isUint():
+		g.printf("body = binary.BigEndian.AppendUint%d(body, p.%s)\n", 8*f.size(), f.Name)
+	case f.Type == "array":
+		g.printf("body = append(body, p.%s[:]...)\n", f.Name)
+	case f.Fixed > 0:
+		check := fmt.Sprintf("if len(p.%s) != %d {\nreturn nil, errors.New(\"%s must be exactly %d bytes\")\n}\n", f.Name, f.Fixed, f.Name, f.Fixed)
+		if f.Type == "bytes" {
+			// nil 写入全 0
+			g.printf("if len(p.%s) == 0 {\nbody = append(body, make([]byte, %d)...)\n} else {\n%s", f.Name, f.Fixed, check)
+			g.printf("body = append(body, p.%s...)\n}\n", f.Name)
+		} else {
+			g.printf("%sbody = append(body, p.%s...)\n", check, f.Name)
+		}
+	default: // len
+		g.printf("if uint64(len(p.%s)) > 0x%X {\nreturn nil, errors.New(\"%s too long\")\n}\n", f.Name, uint64(1)<<(8*f.Len)-1, lowerFirst(f.Name))
+		if f.Len == 1 {
+			g.printf("body = append(body, uint8(len(p.%s)))\n", f.Name)
+		} else {
+			g.printf("body = binary.BigEndian.AppendUint%d(body, uint%d(len(p.%s)))\n", 8*f.Len, 8*f.Len, f.Name)
+		}
+		g.printf("body = append(body, p.%s...)\n", f.Name)
+	}
+}
+
+// trailingOptional 判断 fields 是否全部是可选字段。
+// 可选字段之后还有 rest 字段时，可选字段总是写入
+func trailingOptional(fields []FieldSpec) bool {
+	for _, f := range fields {
+		if !f.Optional {
+			return false
+		}
+	}
+	return true
+}
+
+func zeroValue(f *FieldSpec) string {
+	switch f.Type {
+	case "string", "id":
+		return `""`
+	case "bytes":
+		return "nil"
+	case "array":
+		return f.goType() + "{}"
+	}
+	return "0"
+}
+
+// lowerFirst 把字段名的首字母改为小写，用在错误信息和局部变量名中。ID 这样的缩写保持不变
+func lowerFirst(name string) string {
+	if len(name) > 1 && strings.ToUpper(name[:2]) == name[:2] {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// nonZero 返回判断可选字段不是零值的表达式，零值的可选字段在末尾时不写入
+func nonZero(f *FieldSpec) string {
+	return "p." + f.Name + " != " + zeroValue(f)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/* packetgen 根据协议描述文件生成包类型，使这些包的线路格式只有一个来源（packet 包中仍然手写的几种见 packet/registry.go）：
+	packetgen -schema protocol.json -go packets_gen.go -test packets_gen_test.go -doc PROTOCOL.md
+-go、-test、-doc 为空时不生成对应的文件。packet 包中的用法见 packet/registry.go 的 go:generate
+*/
+
+func main() {
+	schemaPath := flag.String("schema", "", "protocol schema file (JSON)")
+	goOut := flag.String("go", "", "output file for packet types")
+	testOut := flag.String("test", "", "output file for generated tests")
+	docOut := flag.String("doc", "", "output file for the protocol reference (Markdown)")
+	flag.Parse()
+
+	if err := run(*schemaPath, *goOut, *testOut, *docOut); err != nil {
+		fmt.Fprintf(os.Stderr, "packetgen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(schemaPath, goOut, testOut, docOut string) error {
+	if schemaPath == "" {
+		return errors.New("-schema is required")
+	}
+	s, err := LoadSchema(schemaPath)
+	if err != nil {
+		return err
+	}
+	source := filepath.Base(schemaPath)
+	if goOut != "" {
+		src, err := GenerateGo(s, source)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(goOut, src, 0644); err != nil {
+			return err
+		}
+	}
+	if testOut != "" {
+		src, err := GenerateTest(s, source)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(testOut, src, 0644); err != nil {
+			return err
+		}
+	}
+	if docOut != "" {
+		return os.WriteFile(docOut, GenerateDoc(s, source), 0644)
+	}
+	return nil
+}
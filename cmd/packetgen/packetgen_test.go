@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 提交到仓库中的生成文件必须与 protocol.json 一致
+func TestGenerate_UpToDate(t *testing.T) {
+	s, err := LoadSchema("../../packet/protocol.json")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	goSrc, err := GenerateGo(s, "protocol.json")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	testSrc, err := GenerateTest(s, "protocol.json")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	for name, want := range map[string][]byte{
+		"packets_gen.go":      goSrc,
+		"packets_gen_test.go": testSrc,
+		"PROTOCOL.md":         GenerateDoc(s, "protocol.json"),
+	} {
+		actual, err := os.ReadFile(filepath.Join("../../packet", name))
+		if err != nil {
+			t.Errorf("want nil, actual %s", err.Error())
+			continue
+		}
+		if !bytes.Equal(actual, want) {
+			t.Errorf("packet/%s is out of date, run go generate ./packet", name)
+		}
+	}
+}
+
+func TestParseSchema_Error(t *testing.T) {
+	tests := []struct {
+		schema string
+		want   string
+	}{
+		{`{"package": "p", "packets": []}`, "no packets"},
+		{`{"package": "p", "packets": [{"name": "ping", "command": 1}]}`, "invalid packet name"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1}, {"name": "B", "command": 1}]}`, "already used by A"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 256}]}`, "command must be"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "int"}]}]}`, "unknown type"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "string"}]}]}`, "exactly one of"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "bytes", "len": 3}]}]}`, "len prefix"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "bytes", "rest": true}, {"name": "Y", "type": "uint8"}]}]}`, "rest must be the last field"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "uint8", "optional": true}, {"name": "Y", "type": "uint8"}]}]}`, "required field after optional"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "array"}]}]}`, "positive fixed size"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "string", "len": 1, "max": "9"}]}]}`, "max requires"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "uint8", "nonempty": true}]}]}`, "nonempty requires"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "bytes", "rest": true, "validate": "f"}]}]}`, "validate requires"},
+		{`{"package": "p", "packets": [{"name": "A", "command": 1, "fields": [{"name": "X", "type": "id", "optional": true}]}]}`, "id takes no"},
+	}
+	for _, tt := range tests {
+		_, err := ParseSchema([]byte(tt.schema))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("want %s, actual %v", tt.want, err)
+		}
+	}
+}
+
+// 覆盖所有字段类型的协议描述，生成到 packet 包之外并运行生成的测试
+const sampleSchema = `{
+  "package": "sample",
+  "packets": [
+    {"name": "Sample", "command": 100, "doc": ["Sample 覆盖所有字段类型"], "fields": [
+      {"name": "ID", "type": "id"},
+      {"name": "Flag", "type": "uint8"},
+      {"name": "Port", "type": "uint16"},
+      {"name": "Size", "type": "uint32"},
+      {"name": "Offset", "type": "uint64"},
+      {"name": "Key", "type": "bytes", "fixed": 4},
+      {"name": "Name", "type": "string", "len": 1},
+      {"name": "Token", "type": "bytes", "len": 2},
+      {"name": "Data", "type": "bytes", "len": 4},
+      {"name": "Nonce", "type": "bytes", "fixed": 4, "optional": true},
+      {"name": "Window", "type": "uint32", "optional": true},
+      {"name": "Note", "type": "string", "len": 2, "optional": true}
+    ]},
+    {"name": "Raw", "command": 101, "fields": [
+      {"name": "Seq", "type": "uint16", "optional": true},
+      {"name": "Body", "type": "bytes", "rest": true}
+    ]},
+    {"name": "Empty", "command": 102},
+    {"name": "Checked", "command": 103, "fields": [
+      {"name": "Code", "type": "uint8", "max": "5"},
+      {"name": "Name", "type": "string", "len": 1, "nonempty": true, "validate": "validateName"},
+      {"name": "Sum", "type": "array", "fixed": 4},
+      {"name": "Tail", "type": "string", "rest": true, "nonempty": true}
+    ]}
+  ]
+}`
+
+const sampleCommands = `package sample
+
+import "errors"
+
+const (
+	CommandSample  = 100
+	CommandRaw     = 101
+	CommandEmpty   = 102
+	CommandChecked = 103
+)
+
+func validateName(name string) error {
+	if name == "bad" {
+		return errors.New("bad name")
+	}
+	return nil
+}
+`
+
+const sampleTest = `package sample
+
+import (
+	"37_tcp-server-demo1/packet"
+	"reflect"
+	"testing"
+)
+
+func TestOptional(t *testing.T) {
+	full := &Sample{ID: "12345678", Name: "n", Nonce: []byte{1, 2, 3, 4}, Window: 7, Note: "hi"}
+	tests := []struct {
+		sample *Sample
+		size   int
+	}{
+		{&Sample{ID: "12345678"}, 34},
+		{&Sample{ID: "12345678", Window: 7}, 42},
+		{full, 47},
+	}
+	for _, tt := range tests {
+		encode, err := packet.Encode(tt.sample)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if len(encode) != 1+tt.size {
+			t.Errorf("want %d, actual %d", 1+tt.size, len(encode))
+		}
+		decode, err := packet.Decode(encode)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		want := *tt.sample
+		want.Key, want.Token, want.Data = make([]byte, 4), []byte{}, []byte{}
+		if want.Nonce == nil && want.Window != 0 {
+			want.Nonce = make([]byte, 4)
+		}
+		if !reflect.DeepEqual(decode, &want) {
+			t.Errorf("want %+v, actual %+v", &want, decode)
+		}
+	}
+	if _, err := packet.Encode(&Sample{ID: "12345678", Key: []byte{1}}); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	if _, err := packet.Encode(&Raw{}); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+}
+
+func TestChecked(t *testing.T) {
+	for _, c := range []*Checked{
+		{Code: 6, Name: "n", Tail: "t"},
+		{Code: 1, Name: "", Tail: "t"},
+		{Code: 1, Name: "bad", Tail: "t"},
+		{Code: 1, Name: "n", Tail: ""},
+	} {
+		if _, err := packet.Encode(c); err == nil {
+			t.Errorf("%+v: want error, actual nil", c)
+		}
+	}
+	// 长度为 0 的 Name 按包体过短处理
+	if _, err := packet.Decode([]byte{CommandChecked, 1, 0, 0, 0, 0, 0, 't'}); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
+`
+
+func TestGenerate_Sample(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test on generated code")
+	}
+	s, err := ParseSchema([]byte(sampleSchema))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	// 生成到 testdata 下，使生成的代码属于本模块，可以引用 packet 包
+	if err = os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer os.Remove("testdata") // 目录非空时（例如还有其他测试数据）不会删除
+	dir, err := os.MkdirTemp("testdata", "sample")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	goSrc, err := GenerateGo(s, "sample.json")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	testSrc, err := GenerateTest(s, "sample.json")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	files := map[string]string{
+		"packets_gen.go":      string(goSrc),
+		"packets_gen_test.go": string(testSrc),
+		"commands.go":         sampleCommands,
+		"optional_test.go":    sampleTest,
+	}
+	for name, src := range files {
+		if err = os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+	}
+	out, err := exec.Command("go", "test", "./"+filepath.ToSlash(dir)).CombinedOutput()
+	if err != nil {
+		t.Errorf("want nil, actual %s\n%s\n%s", err.Error(), out, goSrc)
+	}
+}
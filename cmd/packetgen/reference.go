@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GenerateTest 生成表驱动的测试：命令字与协议描述一致、Encode/Decode 往返、
+// 截断的包体返回错误、开启 LongIDs 时 EncodeWith/DecodeWith 往返
+func GenerateTest(s *Schema, source string) ([]byte, error) {
+	g := newGenerator(s, source)
+	g.header("reflect", "testing")
+	g.printf("func TestGeneratedPackets(t *testing.T) {\n")
+	g.printf("tests := []struct {\nname string\ncommand uint8\nwant uint8\npacket %s\nminLen int\n}{\n", g.qualify("Packet"))
+	for _, p := range s.Packets {
+		var values []string
+		for i, f := range p.Fields {
+			values = append(values, f.Name+": "+sampleValue(&f, i))
+		}
+		g.printf("{%q, Command%s, 0x%02X, &%s{%s}, %d},\n", p.Name, p.Name, p.Command, p.Name, strings.Join(values, ", "), p.minBodyLen())
+	}
+	g.printf("}\n")
+	g.printf(`for _, tt := range tests {
+	if tt.command != tt.want {
+		t.Errorf("%%s: want command 0x%%02X, actual 0x%%02X", tt.name, tt.want, tt.command)
+	}
+	encode, err := %[1]s(tt.packet)
+	if err != nil {
+		t.Errorf("%%s: want nil, actual %%s", tt.name, err.Error())
+		continue
+	}
+	if encode[0] != tt.want {
+		t.Errorf("%%s: want 0x%%02X, actual 0x%%02X", tt.name, tt.want, encode[0])
+	}
+	decode, err := %[2]s(encode)
+	if err != nil {
+		t.Errorf("%%s: want nil, actual %%s", tt.name, err.Error())
+		continue
+	}
+	if !reflect.DeepEqual(decode, tt.packet) {
+		t.Errorf("%%s: want %%v, actual %%v", tt.name, tt.packet, decode)
+	}
+	for i := 0; i < tt.minLen; i++ {
+		if _, err := %[2]s(encode[:1+i]); err == nil {
+			t.Errorf("%%s: %%d bytes: want packetBody too short, actual nil", tt.name, i)
+		}
+	}
+	opts := %[3]s{LongIDs: true}
+	if encode, err = %[4]s(tt.packet, opts); err != nil {
+		t.Errorf("%%s: long IDs: want nil, actual %%s", tt.name, err.Error())
+		continue
+	}
+	if decode, err = %[5]s(encode, opts); err != nil || !reflect.DeepEqual(decode, tt.packet) {
+		t.Errorf("%%s: long IDs: want %%v, actual %%v (%%v)", tt.name, tt.packet, decode, err)
+	}
+}
+}
+`, g.qualify("Encode"), g.qualify("Decode"), g.qualify("Options"), g.qualify("EncodeWith"), g.qualify("DecodeWith"))
+	return g.format()
+}
+
+// sampleValue 返回测试中使用的非零字段值，定长字段的长度与协议描述一致
+func sampleValue(f *FieldSpec, i int) string {
+	var s string
+	switch {
+	case f.isUint():
+		return fmt.Sprint(i + 1)
+	case f.Type == "array":
+		return f.goType() + "{1, 2, 3}"
+	case f.Type == "id":
+		return `"12345678"`
+	case f.Fixed > 0:
+		s = strings.Repeat("12345678", f.Fixed/8+1)[:f.Fixed]
+	default:
+		s = "hello"
+	}
+	if f.Type == "bytes" {
+		return fmt.Sprintf("[]byte(%q)", s)
+	}
+	return fmt.Sprintf("%q", s)
+}
+
+// GenerateDoc 生成 Markdown 格式的协议参考
+func GenerateDoc(s *Schema, source string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!-- Code generated by packetgen from %s. DO NOT EDIT. -->\n\n", source)
+	b.WriteString("# 协议参考\n\n")
+	b.WriteString("每个包由 1 字节的命令字和包体组成，多字节整数均为大端。可选字段只出现在包体末尾，")
+	b.WriteString("旧版本的包可以不带；携带后面的可选字段时，前面的可选字段用零值占位。\n\n")
+	if s.usesID() {
+		b.WriteString("类型为 id 的字段占 8 字节；连接协商了 CapLongIDs 之后改为 ID长度(1字节) + ID（1~255 字节），")
+		b.WriteString("表中之后字段的偏移相应后移。\n\n")
+	}
+	if len(s.Doc) > 0 {
+		b.WriteString(strings.Join(s.Doc, "\n") + "\n\n")
+	}
+	b.WriteString("| 命令字 | 包 |\n|---|---|\n")
+	for _, p := range s.Packets {
+		fmt.Fprintf(&b, "| 0x%02X | [%s](#%s) |\n", p.Command, p.Name, strings.ToLower(p.Name))
+	}
+	for _, p := range s.Packets {
+		fmt.Fprintf(&b, "\n## %s\n\n命令字：0x%02X\n\n", p.Name, p.Command)
+		if len(p.Doc) > 0 {
+			b.WriteString(strings.Join(p.Doc, "\n") + "\n\n")
+		}
+		if len(p.Fields) == 0 {
+			b.WriteString("包体为空。\n")
+			continue
+		}
+		b.WriteString("| 偏移 | 字段 | 类型 | 长度（字节） | 说明 |\n|---|---|---|---|---|\n")
+		offset := 0
+		for _, f := range p.Fields {
+			at := "-"
+			if offset >= 0 {
+				at = fmt.Sprint(offset)
+			}
+			var size string
+			switch {
+			case f.Rest:
+				size = "剩余全部"
+			case f.Len > 0:
+				size = fmt.Sprintf("%d + 内容长度", f.Len)
+			default:
+				size = fmt.Sprint(f.size())
+			}
+			doc := f.Doc
+			if f.Optional {
+				doc = "可选，" + doc
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s | %s |\n", at, f.Name, f.Type, size, doc)
+			if f.isUint() || f.Fixed > 0 || f.Type == "id" {
+				if offset >= 0 {
+					offset += f.size()
+				}
+			} else {
+				offset = -1 // 变长字段之后的偏移量不固定
+			}
+		}
+	}
+	return []byte(b.String())
+}
+
+func (s *Schema) usesID() bool {
+	for _, p := range s.Packets {
+		if p.hasID() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"os"
+)
+
+// Schema 是协议描述文件的内容，一个文件描述同一个 Go 包中的若干种包
+type Schema struct {
+	Package string       `json:"package"`
+	Doc     []string     `json:"doc"` // 写在协议参考开头的说明
+	Packets []PacketSpec `json:"packets"`
+}
+
+// PacketSpec 描述一种包：命令字、类型名以及按包体中顺序排列的字段
+type PacketSpec struct {
+	Name    string      `json:"name"`
+	Command int         `json:"command"`
+	Doc     []string    `json:"doc"`
+	Fields  []FieldSpec `json:"fields"`
+}
+
+/*
+	 FieldSpec 描述一个字段：
+		type      uint8、uint16、uint32、uint64、string、bytes、array（Go 类型为 [fixed]byte）或 id（Go 类型为 string，
+		          8 字节，Options.LongIDs 时为 ID长度(1字节) + ID，见 packet.AppendID；不能带 fixed、len、rest、optional）
+		fixed     string/bytes/array 的定长字节数
+		len       string/bytes 的长度前缀字节数（1、2 或 4）
+		rest      string/bytes 占用包体剩余的所有字节，只能是最后一个字段
+		optional  可选字段，只能出现在末尾
+	 以下几项只影响生成的 Encode/Decode 的校验，不影响线路格式：
+		max       整数字段允许的最大值（Go 常量表达式，例如 resultMax），Encode 时超出返回错误
+		nonempty  string/bytes 不能为空，Encode 返回错误，Decode 按包体过短处理
+		validate  string 字段的校验函数（func(string) error），Encode 时调用
+*/
+type FieldSpec struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Fixed    int    `json:"fixed,omitempty"`
+	Len      int    `json:"len,omitempty"`
+	Rest     bool   `json:"rest,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+	Max      string `json:"max,omitempty"`
+	NonEmpty bool   `json:"nonempty,omitempty"`
+	Validate string `json:"validate,omitempty"`
+	Doc      string `json:"doc,omitempty"`
+}
+
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSchema(data)
+}
+
+func ParseSchema(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse schema: %w", err)
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schema) Validate() error {
+	if !token.IsIdentifier(s.Package) {
+		return fmt.Errorf("invalid package name %q", s.Package)
+	}
+	if len(s.Packets) == 0 {
+		return errors.New("schema has no packets")
+	}
+	names := make(map[string]bool)
+	commands := make(map[int]string)
+	for i := range s.Packets {
+		p := &s.Packets[i]
+		if !token.IsExported(p.Name) || !token.IsIdentifier(p.Name) {
+			return fmt.Errorf("invalid packet name %q", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate packet %s", p.Name)
+		}
+		names[p.Name] = true
+		if p.Command <= 0 || p.Command > 0xFF {
+			return fmt.Errorf("%s: command must be in [1, 255]", p.Name)
+		}
+		if other, ok := commands[p.Command]; ok {
+			return fmt.Errorf("%s: command 0x%02X already used by %s", p.Name, p.Command, other)
+		}
+		commands[p.Command] = p.Name
+		if err := p.validateFields(); err != nil {
+			return fmt.Errorf("%s.%w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *PacketSpec) validateFields() error {
+	names := make(map[string]bool)
+	for i, f := range p.Fields {
+		if !token.IsExported(f.Name) || !token.IsIdentifier(f.Name) {
+			return fmt.Errorf("%s: invalid field name", f.Name)
+		}
+		if names[f.Name] {
+			return fmt.Errorf("%s: duplicate field", f.Name)
+		}
+		names[f.Name] = true
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if f.Rest && i != len(p.Fields)-1 {
+			return fmt.Errorf("%s: rest must be the last field", f.Name)
+		}
+		if i > 0 && p.Fields[i-1].Optional && !f.Optional && !f.Rest {
+			return fmt.Errorf("%s: required field after optional field", f.Name)
+		}
+	}
+	return nil
+}
+
+func (f *FieldSpec) validate() error {
+	if f.Max != "" && !f.isUint() {
+		return errors.New("max requires an unsigned integer")
+	}
+	if f.NonEmpty && (f.isUint() || f.Type == "array" || f.Type == "id") {
+		return errors.New("nonempty requires string or bytes")
+	}
+	if f.Validate != "" && f.Type != "string" {
+		return errors.New("validate requires string")
+	}
+	switch f.Type {
+	case "uint8", "uint16", "uint32", "uint64":
+		if f.Fixed != 0 || f.Len != 0 || f.Rest {
+			return errors.New("fixed, len and rest require string or bytes")
+		}
+		return nil
+	case "array":
+		if f.Fixed <= 0 || f.Len != 0 || f.Rest {
+			return errors.New("array requires a positive fixed size")
+		}
+		return nil
+	case "id":
+		if f.Fixed != 0 || f.Len != 0 || f.Rest || f.Optional {
+			return errors.New("id takes no fixed, len, rest or optional")
+		}
+		return nil
+	case "string", "bytes":
+	default:
+		return fmt.Errorf("unknown type %q", f.Type)
+	}
+	n := 0
+	if f.Fixed != 0 {
+		n++
+	}
+	if f.Len != 0 {
+		n++
+	}
+	if f.Rest {
+		n++
+	}
+	if n != 1 {
+		return errors.New("string and bytes require exactly one of fixed, len or rest")
+	}
+	if f.Fixed < 0 {
+		return errors.New("fixed must be positive")
+	}
+	if f.Len != 0 && f.Len != 1 && f.Len != 2 && f.Len != 4 {
+		return errors.New("len prefix must be 1, 2 or 4 bytes")
+	}
+	return nil
+}
+
+// size 返回整数或定长字段的字节数（id 字段按默认的 8 字节），变长字段返回长度前缀的字节数
+func (f *FieldSpec) size() int {
+	switch f.Type {
+	case "uint8":
+		return 1
+	case "uint16":
+		return 2
+	case "uint32":
+		return 4
+	case "uint64", "id":
+		return 8
+	}
+	if f.Fixed > 0 {
+		return f.Fixed
+	}
+	return f.Len
+}
+
+func (f *FieldSpec) goType() string {
+	switch f.Type {
+	case "bytes":
+		return "[]byte"
+	case "array":
+		return fmt.Sprintf("[%d]byte", f.Fixed)
+	case "id":
+		return "string"
+	}
+	return f.Type
+}
+
+func (f *FieldSpec) isUint() bool {
+	return f.Type != "string" && f.Type != "bytes" && f.Type != "array" && f.Type != "id"
+}
+
+// minBodyLen 返回按默认 Options 解码时包体至少需要的字节数（所有必填字段的定长部分，不能为空的字段至少 1 字节内容）
+func (p *PacketSpec) minBodyLen() int {
+	n := 0
+	for _, f := range p.Fields {
+		if f.Optional {
+			continue
+		}
+		if !f.Rest {
+			n += f.size()
+		}
+		if f.NonEmpty {
+			n++
+		}
+	}
+	return n
+}
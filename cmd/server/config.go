@@ -0,0 +1,237 @@
+package main
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/config"
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/server"
+	"37_tcp-server-demo1/transport"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envPrefix 为服务端环境变量的前缀，例如 TCPSERVER_ADDR、TCPSERVER_ADMIN_TOKEN
+const envPrefix = "TCPSERVER_"
+
+// Config 为服务端的全部配置，字段名即配置文件中的键（见 config.Parse）
+type Config struct {
+	Addr        string          `json:"addr"`
+	SocketMode  config.FileMode `json:"socket_mode"`
+	SocketGroup string          `json:"socket_group"`
+	Frame       string          `json:"frame"`
+	Window      uint32          `json:"window"`
+
+	WS         string `json:"ws"`
+	HTTP       string `json:"http"`
+	Admin      string `json:"admin"`
+	AdminToken string `json:"admin_token"`
+	LogLevel   string `json:"log_level"`
+
+	TrustedProxies     config.Prefixes `json:"trusted_proxies"`
+	ProxyHeaderTimeout config.Duration `json:"proxy_header_timeout"`
+	Allow              config.Prefixes `json:"allow"`
+	Deny               config.Prefixes `json:"deny"`
+	// Principals 把客户端身份限制在指定的来源网络，只能在配置文件中设置
+	Principals map[string]config.Prefixes `json:"principals"`
+
+	// Tokens 为静态令牌 -> 客户端身份，非空时要求客户端认证，只能在配置文件中设置。
+	// SigningKey 非空时要求 Submit 携带签名，ReplayWindow 为签名时间戳允许的偏差
+	Tokens       map[string]string `json:"tokens"`
+	SigningKey   string            `json:"signing_key"`
+	ReplayWindow config.Duration   `json:"replay_window"`
+
+	DedupSize   int             `json:"dedup_size"`
+	DedupWindow config.Duration `json:"dedup_window"`
+	TransferDir string          `json:"transfer_dir"`
+
+	// ShutdownTimeout 为退出或升级时等待已有连接排空的时间，超时后直接断开
+	ShutdownTimeout config.Duration `json:"shutdown_timeout"`
+}
+
+// defaultConfig 与最初硬编码在 main 中的行为一致
+func defaultConfig() *Config {
+	return &Config{
+		Addr:               ":8080",
+		Frame:              "length",
+		Window:             64, // 每个连接最多 64 个等待应答的 Submit，客户端超出时阻塞等待
+		LogLevel:           "info",
+		ProxyHeaderTimeout: config.Duration(5 * time.Second),
+		ReplayWindow:       config.Duration(30 * time.Second),
+		DedupSize:          1024,
+		DedupWindow:        config.Duration(time.Minute),
+		ShutdownTimeout:    config.Duration(30 * time.Second),
+	}
+}
+
+// loadConfig 按 默认值 < 配置文件 < 环境变量 < 命令行参数 合并出配置并校验。printConfig 为是否指定了 -print-config
+func loadConfig(args []string) (cfg *Config, printConfig bool, err error) {
+	cfg = defaultConfig()
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.String("config", "", "config file (JSON), env "+config.EnvName(envPrefix, "config"))
+	fs.BoolVar(&printConfig, "print-config", false, "print the effective configuration and exit")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "listen address: host:port, unix:///path/to.sock or unix://@abstract")
+	fs.Var(&cfg.SocketMode, "socket-mode", "permissions of the unix socket file, e.g. 0660 (umask if 0)")
+	fs.StringVar(&cfg.SocketGroup, "socket-group", cfg.SocketGroup, "group of the unix socket file (unchanged if empty)")
+	fs.StringVar(&cfg.Frame, "frame", cfg.Frame, "frame codec: length, uvarint, lengthfield:size=N,..., or line / delimiter:delim=S (base64 payloads)")
+	fs.Func("window", "max in-flight submits per connection, 0 for unlimited (default 64)", func(s string) error {
+		v, err := strconv.ParseUint(s, 10, 32)
+		cfg.Window = uint32(v)
+		return err
+	})
+	fs.StringVar(&cfg.WS, "ws", cfg.WS, "listen address of the websocket gateway, e.g. :8081 (disabled if empty)")
+	fs.StringVar(&cfg.HTTP, "http", cfg.HTTP, "listen address of the HTTP/JSON gateway, e.g. :8082 (disabled if empty)")
+	fs.StringVar(&cfg.Admin, "admin", cfg.Admin, "listen address of the admin API, e.g. 127.0.0.1:8083 (disabled if empty)")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token of the admin API, prefer env "+config.EnvName(envPrefix, "admin-token"))
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn or error")
+	fs.Var(&cfg.TrustedProxies, "trusted-proxies", "comma separated CIDRs of load balancers sending PROXY protocol headers, e.g. 10.0.0.0/8")
+	fs.Var(&cfg.ProxyHeaderTimeout, "proxy-header-timeout", "time to wait for the PROXY protocol header")
+	fs.Var(&cfg.Allow, "allow", "comma separated CIDRs allowed to connect (all if empty)")
+	fs.Var(&cfg.Deny, "deny", "comma separated CIDRs denied to connect, takes precedence over -allow")
+	fs.StringVar(&cfg.SigningKey, "signing-key", cfg.SigningKey, "pre-shared key for signed submits, prefer env "+config.EnvName(envPrefix, "signing-key"))
+	fs.Var(&cfg.ReplayWindow, "replay-window", "max clock skew of signed submits")
+	fs.IntVar(&cfg.DedupSize, "dedup-size", cfg.DedupSize, "entries of the per-connection dedup cache, negative to disable")
+	fs.Var(&cfg.DedupWindow, "dedup-window", "how long a submit id is remembered for dedup")
+	fs.StringVar(&cfg.TransferDir, "transfer-dir", cfg.TransferDir, "directory for chunked transfers (disabled if empty)")
+	fs.Var(&cfg.ShutdownTimeout, "shutdown-timeout", "time to drain connections on shutdown or upgrade before closing them")
+	if err = config.Parse(fs, args, envPrefix, "config", cfg); err != nil {
+		return nil, false, err
+	}
+	return cfg, printConfig, cfg.Validate()
+}
+
+// Validate 检查配置，返回所有有问题的字段
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", field, err))
+		}
+	}
+	network, _, err := transport.ParseAddr(c.Addr)
+	check("addr", err)
+	if err == nil && network != "unix" && (c.SocketMode != 0 || c.SocketGroup != "") {
+		check("socket_mode", errors.New("socket_mode and socket_group require a unix:// addr"))
+	}
+	_, err = frame.ParseBinaryCodec(c.Frame)
+	check("frame", err)
+	_, err = server.ParseLogLevel(c.LogLevel)
+	check("log_level", err)
+
+	listeners := map[string]string{}
+	for _, l := range []struct{ field, addr string }{{"ws", c.WS}, {"http", c.HTTP}, {"admin", c.Admin}} {
+		if l.addr == "" {
+			continue
+		}
+		if _, _, err = net.SplitHostPort(l.addr); err != nil {
+			check(l.field, err)
+			continue
+		}
+		if other, ok := listeners[l.addr]; ok {
+			check(l.field, fmt.Errorf("address %s is already used by %s", l.addr, other))
+		}
+		listeners[l.addr] = l.field
+	}
+	if c.Admin != "" && c.AdminToken == "" {
+		host, _, _ := net.SplitHostPort(c.Admin)
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			check("admin_token", fmt.Errorf("required when the admin API listens on a non-loopback address %s", c.Admin))
+		}
+	}
+	if c.ProxyHeaderTimeout < 0 {
+		check("proxy_header_timeout", errors.New("must not be negative"))
+	}
+	if c.ReplayWindow < 0 {
+		check("replay_window", errors.New("must not be negative"))
+	}
+	for token, name := range c.Tokens {
+		if token == "" || name == "" {
+			check("tokens", errors.New("token and principal name must not be empty"))
+			break
+		}
+	}
+	if c.DedupWindow < 0 {
+		check("dedup_window", errors.New("must not be negative"))
+	}
+	if c.ShutdownTimeout < 0 {
+		check("shutdown_timeout", errors.New("must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// Redacted 返回隐去密钥的副本，用于 -print-config
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if redacted.AdminToken != "" {
+		redacted.AdminToken = "REDACTED"
+	}
+	if redacted.SigningKey != "" {
+		redacted.SigningKey = "REDACTED"
+	}
+	if len(redacted.Tokens) > 0 {
+		// 令牌是 map 的 key，只保留身份
+		redacted.Tokens = make(map[string]string, len(c.Tokens))
+		i := 0
+		for _, name := range c.Tokens {
+			i++
+			redacted.Tokens[fmt.Sprintf("REDACTED-%d", i)] = name
+		}
+	}
+	return &redacted
+}
+
+// ACL 返回配置中的访问控制规则
+func (c *Config) ACL() *server.ACL {
+	acl := &server.ACL{Allow: c.Allow, Deny: c.Deny}
+	if len(c.Principals) > 0 {
+		acl.Principals = make(map[string][]netip.Prefix, len(c.Principals))
+		for name, prefixes := range c.Principals {
+			acl.Principals[name] = prefixes
+		}
+	}
+	return acl
+}
+
+// Settings 返回配置中运行时可以替换的部分（见 server.Reload）
+func (c *Config) Settings() *server.Settings {
+	st := &server.Settings{
+		Window:       c.Window,
+		ReplayWindow: time.Duration(c.ReplayWindow),
+		ACL:          c.ACL(),
+	}
+	st.LogLevel, _ = server.ParseLogLevel(c.LogLevel) // Validate 已经检查过
+	if len(c.Tokens) > 0 {
+		st.Authenticator = auth.NewStaticTokenAuthenticator(c.Tokens)
+	}
+	if c.SigningKey != "" {
+		st.SigningKey = []byte(c.SigningKey)
+	}
+	return st
+}
+
+// newServer 按配置创建服务端
+func newServer(cfg *Config) *server.Server {
+	// 处理连接、解码以及 Submit 应答的逻辑都在 server 包中，这里只负责按配置组装
+	srv := server.NewServer(cfg.Addr, server.DefaultHandler)
+	srv.Socket = transport.SocketOptions{Mode: os.FileMode(cfg.SocketMode), Group: cfg.SocketGroup}
+	srv.FrameCodec, _ = frame.ParseBinaryCodec(cfg.Frame) // Validate 已经检查过
+	st := cfg.Settings()
+	srv.Window = st.Window
+	srv.Authenticator = st.Authenticator
+	srv.SigningKey = st.SigningKey
+	srv.ReplayWindow = st.ReplayWindow
+	srv.LogLevel.Set(st.LogLevel)
+	srv.SetACL(st.ACL) // 运行中可以通过管理接口 PUT /acl 或 SIGHUP 替换
+	srv.AdminToken = cfg.AdminToken
+	srv.TrustedProxies = cfg.TrustedProxies
+	srv.ProxyHeaderTimeout = time.Duration(cfg.ProxyHeaderTimeout)
+	srv.DedupSize = cfg.DedupSize
+	srv.DedupWindow = time.Duration(cfg.DedupWindow)
+	srv.TransferDir = cfg.TransferDir
+	return srv
+}
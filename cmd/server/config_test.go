@@ -0,0 +1,143 @@
+package main
+
+import (
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	content := `{"addr": "unix:///tmp/server.sock", "socket_mode": "0660", "window": 8, "deny": ["10.0.1.0/24"], "principals": {"alice": ["10.2.0.0/16"]}}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	t.Setenv("TCPSERVER_ADMIN_TOKEN", "secret")
+	cfg, printConfig, err := loadConfig([]string{"-config", path, "-print-config", "-admin", ":8083"})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if !printConfig || cfg.Window != 8 || cfg.AdminToken != "secret" || cfg.Frame != "length" {
+		t.Errorf("want merged config, actual %+v", cfg)
+	}
+	if cfg.Redacted().AdminToken != "REDACTED" || cfg.AdminToken != "secret" {
+		t.Errorf("want redacted copy, actual %s", cfg.Redacted().AdminToken)
+	}
+	acl := cfg.ACL()
+	if acl.AllowAddr(netip.MustParseAddr("10.0.1.5")) || acl.AllowPrincipal("alice", netip.MustParseAddr("10.3.0.1")) {
+		t.Errorf("want deny and principal rules, actual %+v", acl)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	t.Setenv("TCPSERVER_ADMIN_TOKEN", "")
+	_, _, err := loadConfig([]string{"-socket-mode", "0600", "-frame", "zip", "-log-level", "loud", "-ws", ":8081", "-http", ":8081", "-admin", "0.0.0.0:8083"})
+	if err == nil {
+		t.Fatalf("want error, actual nil")
+	}
+	// 所有有问题的字段一次报告
+	for _, field := range []string{"socket_mode:", "frame:", "log_level:", "http: address :8081 is already used by ws", "admin_token:"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("want %s in error, actual %s", field, err)
+		}
+	}
+}
+
+func TestConfig_Settings(t *testing.T) {
+	old := defaultConfig()
+	next := defaultConfig()
+	next.Window = 16
+	next.Tokens = map[string]string{"secret": "alice"}
+	next.SigningKey = "key"
+	next.Addr = ":9090"
+	next.AdminToken = "token"
+
+	st := next.Settings()
+	if st.Window != 16 || st.Authenticator == nil || string(st.SigningKey) != "key" {
+		t.Errorf("want reloadable settings, actual %+v", st)
+	}
+	if redacted := next.Redacted(); redacted.SigningKey != "REDACTED" || redacted.Tokens["secret"] != "" {
+		t.Errorf("want redacted copy, actual %+v", redacted)
+	}
+	// 需要重启才能生效的字段
+	if fields := strings.Join(restartFields(old, next), ","); fields != "addr,admin_token" {
+		t.Errorf("want addr,admin_token, actual %s", fields)
+	}
+}
+
+func TestConfig_Frame(t *testing.T) {
+	// 命令字 0x0A 即 '\n'，ID 和 Payload 中同样可以出现任意字节
+	batch := packet.NewSubmitBatch("0000\n\x00\r\n", []*packet.Submit{packet.NewSubmit("00000001", []byte("a\nb\x00c"))})
+	framePayload, err := packet.Encode(batch)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	for _, spec := range []string{"length", "uvarint", "uvarint:max=1024", "lengthfield:size=2", "lengthfield:size=4,order=little", "line", `delimiter:delim=\x00`} {
+		cfg := defaultConfig()
+		cfg.Frame = spec
+		if err = cfg.Validate(); err != nil {
+			t.Errorf("%s: want nil, actual %s", spec, err.Error())
+			continue
+		}
+		newCodec, _ := frame.ParseBinaryCodec(spec)
+		codec := newCodec()
+		var buf bytes.Buffer
+		if err = codec.Encode(&buf, framePayload); err != nil {
+			t.Errorf("%s: want nil, actual %s", spec, err.Error())
+			continue
+		}
+		decoded, err := codec.Decode(&buf)
+		if err != nil {
+			t.Errorf("%s: want nil, actual %s", spec, err.Error())
+			continue
+		}
+		if p, err := packet.Decode(decoded); err != nil || p.(*packet.SubmitBatch).ID != batch.ID {
+			t.Errorf("%s: want batch %q, actual %v %v", spec, batch.ID, p, err)
+		}
+	}
+
+	// 按分隔符切分时负载经过 base64 编码，分隔符只由 base64 字母表中的字节组成时无法区分
+	for _, spec := range []string{"delimiter:delim=AB", "delimiter:delim=="} {
+		cfg := defaultConfig()
+		cfg.Frame = spec
+		if err = cfg.Validate(); err == nil || !strings.Contains(err.Error(), "frame:") {
+			t.Errorf("%s: want frame error, actual %v", spec, err)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.json")
+	if err := os.WriteFile(path, []byte(`{"addr": ":9000", "window": 8}`), 0600); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	args := []string{"-config", path}
+	startup, _, err := loadConfig(args)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+
+	// 每次 reload 都与启动时的配置比较，没有生效的 addr 改动一直会被提示
+	if err = os.WriteFile(path, []byte(`{"addr": ":9001", "window": 16}`), 0600); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		st, fields, err := reloadConfig(startup, args)
+		if err != nil || st.Window != 16 || strings.Join(fields, ",") != "addr" {
+			t.Errorf("reload %d: want window 16 and addr, actual %+v %v %v", i+1, st, fields, err)
+		}
+	}
+
+	// 校验失败时返回错误
+	if err = os.WriteFile(path, []byte(`{"frame": "zip"}`), 0600); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if _, _, err = reloadConfig(startup, args); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
@@ -1,78 +1,196 @@
 package main
 
 import (
-	"37_tcp-server-demo1/frame"
-	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/config"
+	"37_tcp-server-demo1/server"
+	"37_tcp-server-demo1/systemd"
+	"37_tcp-server-demo1/transport"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
-// handlePacket 服务端处理客户端发来的 Submit请求，并返回响应
-func handlePacket(framePayload []byte) (ackFramePayload []byte, err error) {
-	var p packet.Packet // Packet的实现类有 Submit 和 SubmitAck，p的类型一定是二者之一
-	p, err = packet.Decode(framePayload)
+// upgradeReadyTimeout 为升级时等待新进程开始接受连接的时间
+const upgradeReadyTimeout = 30 * time.Second
+
+func main() {
+	// 配置来自默认值、-config 指定的 JSON 文件、TCPSERVER_ 开头的环境变量和命令行参数，见 config.go
+	cfg, printConfig, err := loadConfig(os.Args[1:])
 	if err != nil {
-		fmt.Printf("error decoding packet: %v\n", err)
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if !errors.Is(err, config.ErrUsage) { // 命令行参数的错误 flag 包已经输出过了
+			fmt.Fprintf(os.Stderr, "Error loading config:\n%s\n", err)
+		}
+		os.Exit(2)
+	}
+	if printConfig {
+		config.Print(os.Stdout, cfg.Redacted())
 		return
 	}
 
-	switch p.(type) { // 类型 switch，根据p的类型进行操作
-	case *packet.Submit: // 如果类型为 *Submit，也就是接收到了客户端的请求，只对请求进行处理。
-		submit := p.(*packet.Submit) // 类型断言，类似强制类型转换
-		fmt.Printf("receive submit: id = %s, payload = %s\n", submit.ID, string(submit.Payload))
-		submitAck := &packet.SubmitAck{
-			ID:     submit.ID, // 同一次应答保证为同一个ID
-			Result: 0,
+	// 由旧进程升级启动时（见 upgradeSignal），直接使用它传过来的监听 socket
+	inherited, err := transport.Inherit()
+	if err != nil {
+		fmt.Printf("Error inheriting listeners: %s\n", err)
+		os.Exit(1)
+	}
+	// 由 systemd 的 socket unit 启动时使用它传入的监听 socket，FileDescriptorName= 为 server、ws、http 或 admin
+	activated, err := systemd.Listeners()
+	if err != nil {
+		fmt.Printf("Error using systemd sockets: %s\n", err)
+		os.Exit(1)
+	}
+	srv := newServer(cfg)
+	reloadOnSIGHUP(srv, cfg, os.Args[1:]) // kill -HUP <pid> 重新加载配置
+	listeners := make(map[string]net.Listener)
+	listen := func(name, addr string, listenFunc func() (net.Listener, error)) net.Listener {
+		l := inherited.Listener(name)
+		if l == nil {
+			l = activatedListener(activated, name)
 		}
-		ackFramePayload, err = packet.Encode(submitAck)
-		if err != nil {
-			fmt.Printf("error encoding ack packet: %v\n", err)
-			return
+		if l == nil {
+			if l, err = listenFunc(); err != nil {
+				fmt.Printf("Error listening on %s: %s\n", addr, err)
+				os.Exit(1)
+			}
 		}
-		return ackFramePayload, nil
-	default:
-		return nil, fmt.Errorf("unknwon packet type")
+		listeners[name] = l
+		return l
 	}
-}
 
-// handleConn 处理TCP连接 服务端只需要接受客户端发来的请求，并给予响应
-func handleConn(c net.Conn) { // net.Conn接口包含 Read 和 Write函数，实现了io.Reader 和 io.Writer
-	defer c.Close()
-	frameCodec := frame.NewMyFrameCodec()
-	for {
-		// 从输入流中读出 framePayLoad 数据（[]byte）
-		framePayload, err := frameCodec.Decode(c)
-		if err != nil {
-			fmt.Printf("error decoding frame: %v\n", err)
-			return
+	l := listen("server", cfg.Addr, func() (net.Listener, error) { return transport.Listen(cfg.Addr, srv.Socket) })
+	var httpServers []*http.Server
+	for _, gateway := range []struct {
+		name, addr string
+		handler    http.Handler
+	}{
+		// 浏览器通过 ws://host:port/ 连接，与 TCP 客户端共享同一个 Server
+		{"ws", cfg.WS, srv.WebSocketHandler()},
+		// curl -d '{"payload":"hello"}' http://host:port/submit
+		{"http", cfg.HTTP, srv.HTTPHandler()},
+		// curl http://127.0.0.1:8083/sessions
+		{"admin", cfg.Admin, srv.AdminHandler()},
+	} {
+		if gateway.addr == "" {
+			continue
 		}
-		// 解析framePayLoad数据，并得到响应的 ackFramePayload 数据
-		ackFramePayload, err := handlePacket(framePayload)
-		if err != nil {
-			fmt.Printf("error handling packet: %v\n", err)
+		hl := listen(gateway.name, gateway.addr, func() (net.Listener, error) { return net.Listen("tcp", gateway.addr) })
+		hs := &http.Server{Handler: gateway.handler}
+		httpServers = append(httpServers, hs)
+		go func() {
+			if err := hs.Serve(hl); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Printf("Error serving %s: %s\n", gateway.name, err)
+			}
+		}()
+	}
+	inherited.Close() // 新的配置不再使用的监听
+	for name, l := range activated {
+		fmt.Printf("Ignoring systemd socket %s not used by the config\n", name)
+		l.Close()
+	}
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(l) }()
+	if err = inherited.Ready(); err != nil {
+		fmt.Printf("Error notifying the old process: %s\n", err)
+	}
+	// 升级后的新进程同样通知 systemd 自己成为主进程（需要 NotifyAccess=all）
+	if _, err = systemd.Notify(fmt.Sprintf("MAINPID=%d\n%s", os.Getpid(), systemd.Ready)); err != nil {
+		fmt.Printf("Error notifying systemd: %s\n", err)
+	}
+	startWatchdog()
+
+	signals := []os.Signal{os.Interrupt, syscall.SIGTERM}
+	if upgradeSignal != nil {
+		signals = append(signals, upgradeSignal) // kill -USR2 <pid> 升级到磁盘上的新版本
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, signals...)
+	for {
+		select {
+		case err = <-serveErr:
+			fmt.Printf("Error serving: %s\n", err)
+			os.Exit(1)
+		case s := <-sig:
+			if s == upgradeSignal {
+				os.Unsetenv("WATCHDOG_PID") // 新进程成为主进程后由它发送 WATCHDOG=1
+				child, err := transport.Upgrade(listeners, upgradeReadyTimeout)
+				if err != nil {
+					fmt.Printf("Error upgrading, keep serving: %s\n", err)
+					continue
+				}
+				fmt.Printf("Upgraded to pid %d, draining connections\n", child.Pid)
+			} else {
+				systemd.Notify(systemd.Stopping)
+			}
+			shutdown(srv, httpServers, time.Duration(cfg.ShutdownTimeout))
 			return
 		}
-		// 响应结果传入连接中，并关闭连接(defer c.Close())
-		err = frameCodec.Encode(c, ackFramePayload)
-		if err != nil {
-			fmt.Printf("error encoding ack packet: %v\n", err)
-			return
+	}
+}
+
+// activatedListener 从 systemd 传入的监听 socket 中取出名为 name 的一个。
+// 只有一个 socket 且没有按用途命名（默认为 socket unit 的名字）时，把它作为 TCP 服务的监听
+func activatedListener(activated map[string]net.Listener, name string) net.Listener {
+	if l, ok := activated[name]; ok {
+		delete(activated, name)
+		return l
+	}
+	if name != "server" || len(activated) != 1 {
+		return nil
+	}
+	for other, l := range activated {
+		switch other {
+		case "ws", "http", "admin":
+			return nil
 		}
+		delete(activated, other)
+		return l
 	}
+	return nil
 }
 
-func main() {
-	l, err := net.Listen("tcp", ":8080") // 服务端监听8080端口
+// startWatchdog 在 systemd 开启了 WatchdogSec= 时，每隔一半的间隔发送一次 WATCHDOG=1
+func startWatchdog() {
+	interval, err := systemd.WatchdogInterval()
 	if err != nil {
-		fmt.Printf("Error listening: %s\n", err)
+		fmt.Printf("Error reading systemd watchdog: %s\n", err)
 		return
 	}
-	for {
-		conn, err := l.Accept() // 建立 net.Conn 连接
-		if err != nil {
-			fmt.Printf("Error accepting: %s\n", err)
-			break
+	if interval == 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval / 2) {
+			systemd.Notify(systemd.Watchdog)
 		}
-		go handleConn(conn)
+	}()
+}
+
+// shutdown 停止接受连接，并在 timeout 内排空已有连接（见 server.Server.Shutdown）
+func shutdown(srv *server.Server, httpServers []*http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, hs := range httpServers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hs.Shutdown(ctx)
+		}()
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("Error shutting down: %s\n", err)
 	}
+	wg.Wait()
 }
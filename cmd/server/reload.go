@@ -0,0 +1,70 @@
+package main
+
+import (
+	"37_tcp-server-demo1/server"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+)
+
+// reloadOnSIGHUP 在收到 SIGHUP 时按启动时的命令行参数重新加载配置（配置文件和环境变量重新读取），
+// 替换运行中可以调整的设置（见 Config.Settings），已有的连接不会断开。新配置校验失败时保留原来的配置
+func reloadOnSIGHUP(srv *server.Server, startup *Config, args []string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			srv.Reload(func() (*server.Settings, error) {
+				st, fields, err := reloadConfig(startup, args)
+				if len(fields) > 0 {
+					// 这些设置在启动时使用，继续按启动时的值运行
+					fmt.Printf("Changes to %s take effect after a restart\n", strings.Join(fields, ", "))
+				}
+				return st, err
+			})
+		}
+	}()
+}
+
+// reloadConfig 重新加载配置，返回可以替换的设置，以及与启动时的配置（即正在运行的值）不同的、需要重启才能生效的字段。
+// 每次都与启动时的配置比较，多次 reload 之后仍然能提示没有生效的改动
+func reloadConfig(startup *Config, args []string) (*server.Settings, []string, error) {
+	next, _, err := loadConfig(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return next.Settings(), restartFields(startup, next), nil
+}
+
+// restartFields 返回两份配置中不同的、需要重启才能生效的字段
+func restartFields(old, next *Config) []string {
+	fields := []struct {
+		name      string
+		old, next any
+	}{
+		{"addr", old.Addr, next.Addr},
+		{"socket_mode", old.SocketMode, next.SocketMode},
+		{"socket_group", old.SocketGroup, next.SocketGroup},
+		{"frame", old.Frame, next.Frame},
+		{"ws", old.WS, next.WS},
+		{"http", old.HTTP, next.HTTP},
+		{"admin", old.Admin, next.Admin},
+		{"admin_token", old.AdminToken, next.AdminToken},
+		{"trusted_proxies", old.TrustedProxies, next.TrustedProxies},
+		{"proxy_header_timeout", old.ProxyHeaderTimeout, next.ProxyHeaderTimeout},
+		{"dedup_size", old.DedupSize, next.DedupSize},
+		{"dedup_window", old.DedupWindow, next.DedupWindow},
+		{"transfer_dir", old.TransferDir, next.TransferDir},
+		{"shutdown_timeout", old.ShutdownTimeout, next.ShutdownTimeout},
+	}
+	var changed []string
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.old, f.next) {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
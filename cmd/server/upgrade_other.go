@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// upgradeSignal 在不支持继承文件描述符的平台上为 nil，不提供零停机升级
+var upgradeSignal os.Signal
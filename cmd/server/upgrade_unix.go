@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignal 触发零停机升级：启动磁盘上的新版本并把监听 socket 交给它，就绪后排空已有连接并退出
+var upgradeSignal os.Signal = syscall.SIGUSR2
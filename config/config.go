@@ -0,0 +1,130 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/* 命令行程序的配置按以下优先级合并，后面的覆盖前面的：
+	代码中的默认值 < 配置文件（JSON）< 环境变量 < 命令行参数
+每个命令行参数都可以用环境变量设置，变量名为前缀加上大写的参数名，"-" 换成 "_"，
+例如前缀为 TCPSERVER_ 时 -log-level 对应 TCPSERVER_LOG_LEVEL。配置文件的路径同样可以用环境变量指定
+*/
+
+// ErrUsage 表示命令行参数有误，flag 包已经输出了错误和用法说明
+var ErrUsage = errors.New("invalid command line")
+
+// Load 读取 JSON 配置文件到 v。文件中出现 v 没有的字段视为错误，错误信息带上文件名和行号
+func Load(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", path, describe(data, dec, err))
+	}
+	if dec.More() {
+		return fmt.Errorf("%s: unexpected data after the top-level object", path)
+	}
+	return nil
+}
+
+// describe 为 JSON 的解析错误加上行号和列号
+func describe(data []byte, dec *json.Decoder, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		if typeErr.Field != "" {
+			err = fmt.Errorf("%s: cannot use %s as %s", typeErr.Field, typeErr.Value, typeErr.Type)
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		return errors.New("unexpected end of file")
+	default:
+		// 未知字段的错误没有位置信息，按字段名找到它在文件中第一次出现的位置
+		offset = dec.InputOffset()
+		if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			if i := bytes.Index(data, []byte(name)); i >= 0 {
+				offset = int64(i) + 1
+			}
+		}
+	}
+	// offset 为出错时已经读取的字节数，出错的位置是其中最后一个字节
+	line, col := 1, 1
+	for _, b := range data[:max(min(int(offset), len(data))-1, 0)] {
+		if b == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Errorf("line %d, column %d: %w", line, col, err)
+}
+
+// Parse 解析命令行参数并合并配置，fs 中的参数应当绑定到 v 的字段上（见 flag.FlagSet.Var 等）。
+// configFlag 为指定配置文件路径的参数名，为空时不读取配置文件
+func Parse(fs *flag.FlagSet, args []string, envPrefix, configFlag string, v any) error {
+	// 第一次解析只是为了取得配置文件的路径和命令行上出现过的参数
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", ErrUsage, err)
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if configFlag != "" {
+		path := fs.Lookup(configFlag).Value.String()
+		if env, ok := os.LookupEnv(EnvName(envPrefix, configFlag)); ok && !explicit[configFlag] {
+			path = env
+		}
+		if path != "" {
+			if err := Load(path, v); err != nil {
+				return err
+			}
+		}
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || f.Name == configFlag {
+			return
+		}
+		name := EnvName(envPrefix, f.Name)
+		if env, ok := os.LookupEnv(name); ok {
+			if err := fs.Set(f.Name, env); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			}
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	// 再解析一次，命令行参数覆盖配置文件和环境变量。第一次解析已经成功，这里不会出错
+	return fs.Parse(args)
+}
+
+// EnvName 返回参数对应的环境变量名
+func EnvName(prefix, flagName string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Print 把合并后的配置以缩进的 JSON 输出，输出的内容可以直接作为配置文件使用
+func Print(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Addr    string   `json:"addr"`
+	Level   string   `json:"level"`
+	Window  int      `json:"window"`
+	Timeout Duration `json:"timeout"`
+	Mode    FileMode `json:"mode"`
+	Allow   Prefixes `json:"allow"`
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	return path
+}
+
+func newFlagSet(cfg *testConfig) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "")
+	fs.StringVar(&cfg.Level, "level", cfg.Level, "")
+	fs.IntVar(&cfg.Window, "window", cfg.Window, "")
+	fs.Var(&cfg.Timeout, "timeout", "")
+	fs.Var(&cfg.Mode, "mode", "")
+	fs.Var(&cfg.Allow, "allow", "")
+	return fs
+}
+
+func TestParse(t *testing.T) {
+	path := writeFile(t, `{"addr": ":9000", "level": "warn", "window": 8, "timeout": "2s", "mode": "0660", "allow": ["10.0.0.0/8", "192.168.1.1"]}`)
+	t.Setenv("TEST_LEVEL", "debug")
+	t.Setenv("TEST_WINDOW", "16")
+
+	cfg := &testConfig{Addr: ":8080", Level: "info", Window: 64}
+	// 优先级：默认值 < 配置文件 < 环境变量 < 命令行参数
+	if err := Parse(newFlagSet(cfg), []string{"-config", path, "-window", "32"}, "TEST_", "config", cfg); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if cfg.Addr != ":9000" || cfg.Level != "debug" || cfg.Window != 32 {
+		t.Errorf("want :9000/debug/32, actual %s/%s/%d", cfg.Addr, cfg.Level, cfg.Window)
+	}
+	if time.Duration(cfg.Timeout) != 2*time.Second || cfg.Mode != 0660 || cfg.Allow.String() != "10.0.0.0/8,192.168.1.1/32" {
+		t.Errorf("want 2s/0660/10.0.0.0/8,192.168.1.1/32, actual %s/%s/%s", cfg.Timeout, cfg.Mode, cfg.Allow)
+	}
+
+	// 配置文件的路径也可以来自环境变量
+	t.Setenv("TEST_CONFIG", path)
+	cfg = &testConfig{}
+	if err := Parse(newFlagSet(cfg), nil, "TEST_", "config", cfg); err != nil || cfg.Addr != ":9000" {
+		t.Errorf("want :9000, actual %s %v", cfg.Addr, err)
+	}
+
+	t.Setenv("TEST_TIMEOUT", "soon")
+	cfg = &testConfig{}
+	if err := Parse(newFlagSet(cfg), nil, "TEST_", "config", cfg); err == nil || !strings.Contains(err.Error(), "TEST_TIMEOUT") {
+		t.Errorf("want TEST_TIMEOUT error, actual %v", err)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	cases := map[string]string{
+		"{\n  \"addr\": \":9000\",\n  \"port\": 1\n}": `line 3, column 3: json: unknown field "port"`,
+		"{\n  \"window\": \"8\"\n}":                   "line 2, column 15: window: cannot use string as int",
+		"{\n  \"addr\": \":9000\"\n  \"level\": 1}":   "line 3, column 3: invalid character",
+		`{"timeout": "soon"}`:                         `invalid duration "soon"`,
+		`{"mode": "999"}`:                             "invalid file mode",
+		`{"allow": "10.0.0.0/8"}`:                     "want an array of CIDRs",
+		`{"addr": ":9000"`:                            "unexpected end of file",
+	}
+	for content, want := range cases {
+		err := Load(writeFile(t, content), &testConfig{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: want %q, actual %v", content, want, err)
+		}
+	}
+}
+
+func TestPrint(t *testing.T) {
+	cfg := &testConfig{Timeout: Duration(time.Minute), Mode: 0600, Allow: Prefixes{}}
+	var sb strings.Builder
+	if err := Print(&sb, cfg); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	// 输出的内容可以再作为配置文件读回来
+	loaded := &testConfig{}
+	if err := Load(writeFile(t, sb.String()), loaded); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if loaded.Timeout != cfg.Timeout || loaded.Mode != cfg.Mode {
+		t.Errorf("want %+v, actual %+v", cfg, loaded)
+	}
+}
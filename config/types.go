@@ -0,0 +1,99 @@
+package config
+
+import (
+	"37_tcp-server-demo1/transport"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 以下类型同时实现 flag.Value 和 JSON 编解码，配置文件与命令行参数使用同样的写法
+
+// Duration 在配置文件和命令行中写作 "500ms"、"5s"、"1m" 等
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	return d.Set(string(text))
+}
+
+// FileMode 为八进制的文件权限，写作 "0660"，0 表示不设置
+type FileMode os.FileMode
+
+func (m FileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(m))
+}
+
+func (m *FileMode) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0777 {
+		return fmt.Errorf("invalid file mode %q, want octal such as 0660", s)
+	}
+	*m = FileMode(v)
+	return nil
+}
+
+func (m FileMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *FileMode) UnmarshalText(text []byte) error {
+	return m.Set(string(text))
+}
+
+// Prefixes 为 CIDR 列表，配置文件中写作字符串数组，命令行中写作逗号分隔的字符串，单个 IP 视为 /32 或 /128
+type Prefixes []netip.Prefix
+
+func (p Prefixes) String() string {
+	items := make([]string, len(p))
+	for i, prefix := range p {
+		items[i] = prefix.String()
+	}
+	return strings.Join(items, ",")
+}
+
+// Set 用逗号分隔的列表替换原有的内容
+func (p *Prefixes) Set(s string) error {
+	prefixes, err := transport.ParsePrefixes(s)
+	if err != nil {
+		return err
+	}
+	*p = prefixes
+	return nil
+}
+
+func (p Prefixes) MarshalJSON() ([]byte, error) {
+	items := make([]string, len(p))
+	for i, prefix := range p {
+		items[i] = prefix.String()
+	}
+	return json.Marshal(items)
+}
+
+func (p *Prefixes) UnmarshalJSON(data []byte) error {
+	var items []string
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("want an array of CIDRs: %w", err)
+	}
+	return p.Set(strings.Join(items, ","))
+}
@@ -0,0 +1,46 @@
+package frame
+
+import (
+	"encoding/base64"
+	"io"
+	"strings"
+)
+
+/* base64Codec 让按分隔符切分的编解码器可以承载二进制的负载：
+	帧 = base64(负载) + 分隔符
+标准 base64 编码的结果只包含 A-Z、a-z、0-9、+、/ 和 =，分隔符中只要有一个字节不在其中，就不会出现在编码后的负载里。
+被包装的编解码器的长度上限作用于编码后的负载，约为原负载的 4/3
+*/
+
+const base64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/="
+
+type base64Codec struct {
+	inner StreamFrameCodec
+}
+
+// NewBase64Codec 用 base64 包装 inner，使负载中不会出现 inner 的分隔符
+func NewBase64Codec(inner StreamFrameCodec) StreamFrameCodec {
+	return &base64Codec{inner: inner}
+}
+
+func (c *base64Codec) Encode(w io.Writer, framePayload FramePayload) error {
+	return c.inner.Encode(w, base64.StdEncoding.AppendEncode(nil, framePayload))
+}
+
+func (c *base64Codec) Decode(r io.Reader) (FramePayload, error) {
+	data, err := c.inner.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.AppendDecode(nil, data)
+}
+
+// base64Safe 判断 delim 是否一定不会出现在 base64 编码的结果中
+func base64Safe(delim []byte) bool {
+	for _, b := range delim {
+		if strings.IndexByte(base64Alphabet, b) < 0 {
+			return true
+		}
+	}
+	return false
+}
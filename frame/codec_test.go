@@ -0,0 +1,147 @@
+package frame
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// roundTrip 把 payloads 依次编码到同一个流中，再依次解码
+func roundTrip(t *testing.T, name string, codec StreamFrameCodec, payloads ...string) {
+	var buf bytes.Buffer
+	for _, p := range payloads {
+		if err := codec.Encode(&buf, []byte(p)); err != nil {
+			t.Errorf("%s: want nil, actual %s", name, err.Error())
+			return
+		}
+	}
+	for _, p := range payloads {
+		decode, err := codec.Decode(&buf)
+		if err != nil {
+			t.Errorf("%s: want nil, actual %s", name, err.Error())
+			return
+		}
+		if string(decode) != p {
+			t.Errorf("%s: want %q, actual %q", name, p, decode)
+		}
+	}
+	if _, err := codec.Decode(&buf); err != io.EOF {
+		t.Errorf("%s: want EOF, actual %v", name, err)
+	}
+}
+
+func TestUvarintFrameCodec(t *testing.T) {
+	codec := NewUvarintFrameCodec(0)
+	roundTrip(t, "uvarint", codec, "hello", "", string(make([]byte, 300)))
+
+	var buf bytes.Buffer
+	codec.Encode(&buf, make([]byte, 300))
+	if !bytes.Equal(buf.Bytes()[:2], []byte{0xAC, 0x02}) {
+		t.Errorf("want ac02, actual %x", buf.Bytes()[:2])
+	}
+	if _, err := NewUvarintFrameCodec(10).Decode(&buf); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("want %v, actual %v", ErrFrameTooLarge, err)
+	}
+}
+
+func TestLengthFieldFrameCodec(t *testing.T) {
+	configs := []LengthFieldConfig{
+		{Size: 4, Adjustment: -4},
+		{Size: 2},
+		{Size: 3, Offset: 2},
+		{Size: 8, LittleEndian: true},
+		{Size: 1, Adjustment: 1, LittleEndian: true},
+	}
+	for _, cfg := range configs {
+		codec, err := NewLengthFieldFrameCodec(cfg)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		roundTrip(t, "lengthfield", codec, "hello", "x", "hello world")
+	}
+
+	// {Size: 4, Adjustment: -4} 与 NewMyFrameCodec 的格式相同
+	codec, _ := NewLengthFieldFrameCodec(LengthFieldConfig{Size: 4, Adjustment: -4})
+	var buf bytes.Buffer
+	NewMyFrameCodec().Encode(&buf, []byte("hello world"))
+	if decode, err := codec.Decode(&buf); err != nil || string(decode) != "hello world" {
+		t.Errorf("want hello world, actual %q %v", decode, err)
+	}
+
+	if _, err := NewLengthFieldFrameCodec(LengthFieldConfig{Size: 5}); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	codec, _ = NewLengthFieldFrameCodec(LengthFieldConfig{Size: 1})
+	if err := codec.Encode(io.Discard, make([]byte, 256)); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	codec, _ = NewLengthFieldFrameCodec(LengthFieldConfig{Size: 4, MaxFrameSize: 10})
+	if _, err := codec.Decode(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF})); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("want %v, actual %v", ErrFrameTooLarge, err)
+	}
+}
+
+func TestDelimiterFrameCodec(t *testing.T) {
+	roundTrip(t, "line", NewLineFrameCodec(0), "hello", "", "world")
+	codec, err := NewDelimiterFrameCodec([]byte("\r\n\r\n"), 0)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	roundTrip(t, "delimiter", codec, "a\r\nb", "c")
+
+	// 行尾的 \r 被去掉，一次读取中的多行被依次返回
+	line := NewLineFrameCodec(0)
+	r := bytes.NewReader([]byte("hello\r\nworld\n"))
+	for _, want := range []string{"hello", "world"} {
+		if decode, err := line.Decode(r); err != nil || string(decode) != want {
+			t.Errorf("want %s, actual %q %v", want, decode, err)
+		}
+	}
+	if err = line.Encode(io.Discard, []byte("a\nb")); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	if _, err = NewLineFrameCodec(4).Decode(bytes.NewReader(bytes.Repeat([]byte("x"), 8192))); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("want %v, actual %v", ErrFrameTooLarge, err)
+	}
+}
+
+func TestParseBinaryCodec(t *testing.T) {
+	binary := "a\n\x00b\r\n"
+	for _, spec := range []string{"length", "line", `delimiter:delim=\x00`, `delimiter:delim=\r\n`} {
+		newCodec, err := ParseBinaryCodec(spec)
+		if err != nil {
+			t.Errorf("%s: want nil, actual %s", spec, err.Error())
+			continue
+		}
+		roundTrip(t, spec, newCodec(), binary, "")
+	}
+	// 按行切分时负载经过 base64 编码，线路上是一行文本
+	newCodec, _ := ParseBinaryCodec("line")
+	var buf bytes.Buffer
+	if err := newCodec().Encode(&buf, []byte(binary)); err != nil || buf.String() != "YQoAYg0K\n" {
+		t.Errorf("want YQoAYg0K\\n, actual %q %v", buf.String(), err)
+	}
+	for _, spec := range []string{"delimiter:delim=AB", "delimiter:delim==", "unknown"} {
+		if _, err := ParseBinaryCodec(spec); err == nil {
+			t.Errorf("%s: want error, actual nil", spec)
+		}
+	}
+}
+
+func TestParseCodec(t *testing.T) {
+	for _, spec := range []string{"", "length", "uvarint", "uvarint:max=1024", "line", `delimiter:delim=\x00`,
+		"lengthfield:size=2", "lengthfield:size=4,offset=1,adjustment=-4,order=little,max=100"} {
+		newCodec, err := ParseCodec(spec)
+		if err != nil {
+			t.Errorf("%s: want nil, actual %s", spec, err.Error())
+			continue
+		}
+		roundTrip(t, spec, newCodec(), "hello", "world")
+	}
+	for _, spec := range []string{"unknown", "uvarint:max=x", "uvarint:size=2", "delimiter", "lengthfield", "lengthfield:size=2,order=middle", "length:max=1"} {
+		if _, err := ParseCodec(spec); err == nil {
+			t.Errorf("%s: want error, actual nil", spec)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package frame
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+/* delimiterFrameCodec 用分隔符切分帧，用于换行分隔的文本协议：
+	帧 = 负载 + 分隔符
+负载中不能出现分隔符，Encode 时返回错误。按行切分时行尾的 \r 会被去掉。
+Decode 需要预读，编解码器内部持有一个 bufio.Reader，因此每个连接必须使用单独的实例
+*/
+
+type delimiterFrameCodec struct {
+	delim   []byte
+	trimCR  bool
+	maxSize int
+
+	src io.Reader
+	br  *bufio.Reader
+}
+
+// NewDelimiterFrameCodec 创建以 delim 结尾的帧编解码器，maxSize 不大于 0 时使用 DefaultMaxFrameSize
+func NewDelimiterFrameCodec(delim []byte, maxSize int) (StreamFrameCodec, error) {
+	if len(delim) == 0 {
+		return nil, errors.New("empty delimiter")
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &delimiterFrameCodec{delim: bytes.Clone(delim), maxSize: maxSize}, nil
+}
+
+// NewLineFrameCodec 创建按行（\n 或 \r\n）切分的编解码器，编码时使用 \n
+func NewLineFrameCodec(maxSize int) StreamFrameCodec {
+	c, _ := NewDelimiterFrameCodec([]byte("\n"), maxSize)
+	c.(*delimiterFrameCodec).trimCR = true
+	return c
+}
+
+func (c *delimiterFrameCodec) Encode(w io.Writer, framePayload FramePayload) error {
+	if len(framePayload) > c.maxSize {
+		return ErrFrameTooLarge
+	}
+	if bytes.Contains(framePayload, c.delim) {
+		return errors.New("frame payload contains delimiter")
+	}
+	buf := append(append(make([]byte, 0, len(framePayload)+len(c.delim)), framePayload...), c.delim...)
+	n, err := w.Write(buf)
+	if err != nil {
+		return err
+	}
+	if n != len(buf) {
+		return ErrShortWrite
+	}
+	return nil
+}
+
+func (c *delimiterFrameCodec) Decode(r io.Reader) (FramePayload, error) {
+	if c.br == nil || c.src != r {
+		c.src, c.br = r, bufio.NewReader(r)
+	}
+	last := c.delim[len(c.delim)-1]
+	var buf []byte
+	for {
+		chunk, err := c.br.ReadSlice(last)
+		if len(buf)+len(chunk) > c.maxSize+len(c.delim) {
+			return nil, ErrFrameTooLarge
+		}
+		buf = append(buf, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(buf, c.delim) {
+			break
+		}
+	}
+	buf = buf[:len(buf)-len(c.delim)]
+	if c.trimCR {
+		buf = bytes.TrimSuffix(buf, []byte("\r"))
+	}
+	return FramePayload(buf), nil
+}
@@ -124,3 +124,57 @@ func TestDecodeWithReadFail(t *testing.T) {
 		t.Errorf("want non-nil, actual nil")
 	}
 }
+
+func TestTransformCodec(t *testing.T) {
+	large := bytes.Repeat([]byte("hello world "), 100)
+	for _, opts := range []Options{{Compress: true}, {Checksum: true}, {Compress: true, Checksum: true}} {
+		codec := NewTransformCodec(NewMyFrameCodec(), opts)
+		for _, payload := range [][]byte{[]byte("hello"), large} {
+			var buf bytes.Buffer
+			if err := codec.Encode(&buf, payload); err != nil {
+				t.Errorf("%+v: want nil, actual %s", opts, err.Error())
+				continue
+			}
+			if opts.Compress && len(payload) == len(large) && buf.Len() >= len(large) {
+				t.Errorf("%+v: want compressed frame, actual %d bytes", opts, buf.Len())
+			}
+			decode, err := codec.Decode(&buf)
+			if err != nil {
+				t.Errorf("%+v: want nil, actual %s", opts, err.Error())
+				continue
+			}
+			if !bytes.Equal(decode, payload) {
+				t.Errorf("%+v: want %d bytes, actual %d bytes", opts, len(payload), len(decode))
+			}
+		}
+	}
+	if codec := NewMyFrameCodec(); NewTransformCodec(codec, Options{}) != codec {
+		t.Errorf("want inner codec for zero options")
+	}
+}
+
+func TestTransformCodec_Checksum(t *testing.T) {
+	codec := NewTransformCodec(NewMyFrameCodec(), Options{Checksum: true})
+	var buf bytes.Buffer
+	if err := codec.Encode(&buf, []byte("hello world")); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	data := buf.Bytes()
+	data[6] ^= 0xFF // 篡改负载中的一个字节
+	if _, err := codec.Decode(bytes.NewReader(data)); !errors.Is(err, ErrChecksum) {
+		t.Errorf("want %v, actual %v", ErrChecksum, err)
+	}
+}
+
+func TestTransformCodec_TooLarge(t *testing.T) {
+	compressed, err := deflate(make([]byte, MaxDecompressedSize+1))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	var buf bytes.Buffer
+	NewMyFrameCodec().Encode(&buf, append([]byte{FlagDeflate}, compressed...))
+	codec := NewTransformCodec(NewMyFrameCodec(), Options{Compress: true})
+	if _, err = codec.Decode(&buf); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("want %v, actual %v", ErrTooLarge, err)
+	}
+}
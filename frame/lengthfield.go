@@ -0,0 +1,100 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+/* lengthFieldFrameCodec 对应 Netty 的 LengthFieldBasedFrameDecoder/LengthFieldPrepender：
+	帧 = 头部(Offset字节) + 长度字段(Size字节) + 负载
+	负载长度 = 长度字段的值 + Adjustment
+头部和长度字段都不属于负载：解码时丢弃，编码时头部写 0。
+例如 NewMyFrameCodec 的格式（长度包含自身的 4 字节）等价于 {Size: 4, Adjustment: -4}
+*/
+
+type LengthFieldConfig struct {
+	Offset       int  // 长度字段之前的字节数
+	Size         int  // 长度字段的字节数：1、2、3、4 或 8
+	Adjustment   int  // 负载长度 = 长度字段的值 + Adjustment
+	LittleEndian bool // 长度字段默认为大端
+	MaxFrameSize int  // 负载的最大长度，不大于 0 时使用 DefaultMaxFrameSize
+}
+
+type lengthFieldFrameCodec struct {
+	cfg LengthFieldConfig
+}
+
+func NewLengthFieldFrameCodec(cfg LengthFieldConfig) (StreamFrameCodec, error) {
+	switch cfg.Size {
+	case 1, 2, 3, 4, 8:
+	default:
+		return nil, errors.New("length field size must be 1, 2, 3, 4 or 8")
+	}
+	if cfg.Offset < 0 {
+		return nil, errors.New("length field offset must not be negative")
+	}
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = DefaultMaxFrameSize
+	}
+	return &lengthFieldFrameCodec{cfg: cfg}, nil
+}
+
+func (c *lengthFieldFrameCodec) Encode(w io.Writer, framePayload FramePayload) error {
+	if len(framePayload) > c.cfg.MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	value := int64(len(framePayload)) - int64(c.cfg.Adjustment)
+	if value < 0 || c.cfg.Size < 8 && value >= 1<<(8*c.cfg.Size) {
+		return errors.New("frame length does not fit in length field")
+	}
+	header := make([]byte, c.cfg.Offset+8)
+	if c.cfg.LittleEndian {
+		binary.LittleEndian.PutUint64(header[c.cfg.Offset:], uint64(value))
+	} else {
+		binary.BigEndian.PutUint64(header[c.cfg.Offset:], uint64(value)<<(8*(8-c.cfg.Size)))
+	}
+	buf := append(header[:c.cfg.Offset+c.cfg.Size], framePayload...)
+	n, err := w.Write(buf)
+	if err != nil {
+		return err
+	}
+	if n != len(buf) {
+		return ErrShortWrite
+	}
+	return nil
+}
+
+func (c *lengthFieldFrameCodec) Decode(r io.Reader) (FramePayload, error) {
+	header := make([]byte, c.cfg.Offset+c.cfg.Size)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	field := make([]byte, 8)
+	if c.cfg.LittleEndian {
+		copy(field, header[c.cfg.Offset:])
+	} else {
+		copy(field[8-c.cfg.Size:], header[c.cfg.Offset:])
+	}
+	var value uint64
+	if c.cfg.LittleEndian {
+		value = binary.LittleEndian.Uint64(field)
+	} else {
+		value = binary.BigEndian.Uint64(field)
+	}
+	if value > uint64(c.cfg.MaxFrameSize)+uint64(max(-c.cfg.Adjustment, 0)) {
+		return nil, ErrFrameTooLarge
+	}
+	size := int64(value) + int64(c.cfg.Adjustment)
+	if size < 0 {
+		return nil, errors.New("negative frame length")
+	}
+	if size > int64(c.cfg.MaxFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return FramePayload(buf), nil
+}
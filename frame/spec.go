@@ -0,0 +1,123 @@
+package frame
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseCodec 解析配置中的帧格式，格式为 名称[:参数=值,参数=值]：
+//
+//	length                     默认格式，4 字节大端长度（包含自身）
+//	uvarint[:max=N]            varint 长度前缀
+//	lengthfield:size=N[,offset=N][,adjustment=N][,order=little][,max=N]
+//	line[:max=N]               按行切分
+//	delimiter:delim=S[,max=N]  按分隔符切分，S 支持 Go 字符串转义，例如 \x00
+//
+// 返回的函数每次调用创建一个新的编解码器，每个连接使用一个
+func ParseCodec(spec string) (func() StreamFrameCodec, error) {
+	name, args, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	params := make(map[string]string)
+	if args != "" {
+		for _, kv := range strings.Split(args, ",") {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				return nil, fmt.Errorf("frame codec %q: invalid parameter %q", spec, kv)
+			}
+			params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	intParam := func(key string, def int) (int, error) {
+		value, ok := params[key]
+		delete(params, key)
+		if !ok {
+			return def, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("frame codec %q: invalid %s", spec, key)
+		}
+		return n, nil
+	}
+	maxSize, err := intParam("max", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var newCodec func() StreamFrameCodec
+	switch name {
+	case "", "length":
+		if maxSize != 0 {
+			return nil, errors.New("frame codec length does not support max")
+		}
+		newCodec = NewMyFrameCodec
+	case "uvarint":
+		newCodec = func() StreamFrameCodec { return NewUvarintFrameCodec(maxSize) }
+	case "line":
+		newCodec = func() StreamFrameCodec { return NewLineFrameCodec(maxSize) }
+	case "delimiter":
+		delim, err := strconv.Unquote(`"` + params["delim"] + `"`)
+		delete(params, "delim")
+		if err != nil {
+			return nil, fmt.Errorf("frame codec %q: invalid delim", spec)
+		}
+		if _, err = NewDelimiterFrameCodec([]byte(delim), maxSize); err != nil {
+			return nil, err
+		}
+		newCodec = func() StreamFrameCodec {
+			c, _ := NewDelimiterFrameCodec([]byte(delim), maxSize)
+			return c
+		}
+	case "lengthfield":
+		cfg := LengthFieldConfig{MaxFrameSize: maxSize}
+		if cfg.Size, err = intParam("size", 0); err != nil {
+			return nil, err
+		}
+		if cfg.Offset, err = intParam("offset", 0); err != nil {
+			return nil, err
+		}
+		if cfg.Adjustment, err = intParam("adjustment", 0); err != nil {
+			return nil, err
+		}
+		switch params["order"] {
+		case "", "big":
+		case "little":
+			cfg.LittleEndian = true
+		default:
+			return nil, fmt.Errorf("frame codec %q: order must be big or little", spec)
+		}
+		delete(params, "order")
+		if _, err = NewLengthFieldFrameCodec(cfg); err != nil {
+			return nil, err
+		}
+		newCodec = func() StreamFrameCodec {
+			c, _ := NewLengthFieldFrameCodec(cfg)
+			return c
+		}
+	default:
+		return nil, fmt.Errorf("unknown frame codec %q", name)
+	}
+	for key := range params {
+		return nil, fmt.Errorf("frame codec %q: unknown parameter %q", spec, key)
+	}
+	return newCodec, nil
+}
+
+// ParseBinaryCodec 与 ParseCodec 相同，用于承载 packet 协议的连接：二进制的协议包（命令字、ID、签名 trailer、
+// 压缩或者带校验的内容）中随时可能出现分隔符，line 和 delimiter 的负载先经过 base64 编码（见 NewBase64Codec），
+// 适合只能传输文本的链路，分隔符中必须有 base64 字母表以外的字节。其他格式直接承载二进制的负载
+func ParseBinaryCodec(spec string) (func() StreamFrameCodec, error) {
+	newCodec, err := ParseCodec(spec)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := newCodec().(*delimiterFrameCodec)
+	if !ok {
+		return newCodec, nil
+	}
+	if !base64Safe(c.delim) {
+		return nil, fmt.Errorf("frame codec %q: delim must contain a byte outside the base64 alphabet", spec)
+	}
+	return func() StreamFrameCodec { return NewBase64Codec(newCodec()) }, nil
+}
@@ -0,0 +1,131 @@
+package frame
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+/* transformCodec 在握手协商出压缩或校验和之后包装原有的帧编解码器，只改变帧负载的内容：
+	负载 = [Flag(1字节)] + Data + [CRC-32C(4字节，大端)]
+开启压缩时才有 Flag，FlagDeflate 表示 Data 是 DEFLATE 压缩后的数据；开启校验和时才有 CRC，
+覆盖它前面的所有字节。帧长度的编码方式由被包装的编解码器决定
+*/
+
+const (
+	FlagRaw     = 0x00
+	FlagDeflate = 0x01
+)
+
+const (
+	// MinCompressSize 较短的负载压缩收益很小，不压缩
+	MinCompressSize = 256
+	// MaxDecompressedSize 解压后负载的最大长度，防止很小的帧解压出大量数据
+	MaxDecompressedSize = 16 << 20
+)
+
+var (
+	ErrChecksum = errors.New("frame checksum mismatch")
+	ErrTooLarge = errors.New("decompressed frame too large")
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+type Options struct {
+	Compress bool // 开启 DEFLATE 压缩
+	Checksum bool // 开启 CRC-32C 校验和
+}
+
+type transformCodec struct {
+	inner StreamFrameCodec
+	opts  Options
+}
+
+// NewTransformCodec 按 opts 包装 inner，opts 为零值时直接返回 inner
+func NewTransformCodec(inner StreamFrameCodec, opts Options) StreamFrameCodec {
+	if !opts.Compress && !opts.Checksum {
+		return inner
+	}
+	return &transformCodec{inner: inner, opts: opts}
+}
+
+func (c *transformCodec) Encode(w io.Writer, framePayload FramePayload) error {
+	data := []byte(framePayload)
+	if c.opts.Compress {
+		flag := byte(FlagRaw)
+		if len(data) >= MinCompressSize {
+			if compressed, err := deflate(data); err == nil && len(compressed) < len(data) {
+				flag, data = FlagDeflate, compressed
+			}
+		}
+		data = append([]byte{flag}, data...)
+	}
+	if c.opts.Checksum {
+		data = binary.BigEndian.AppendUint32(data, crc32.Checksum(data, castagnoli))
+	}
+	return c.inner.Encode(w, data)
+}
+
+func (c *transformCodec) Decode(r io.Reader) (FramePayload, error) {
+	data, err := c.inner.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.Checksum {
+		if len(data) < 4 {
+			return nil, ErrShortRead
+		}
+		n := len(data) - 4
+		if crc32.Checksum(data[:n], castagnoli) != binary.BigEndian.Uint32(data[n:]) {
+			return nil, ErrChecksum
+		}
+		data = data[:n]
+	}
+	if c.opts.Compress {
+		if len(data) < 1 {
+			return nil, ErrShortRead
+		}
+		switch data[0] {
+		case FlagRaw:
+			data = data[1:]
+		case FlagDeflate:
+			if data, err = inflate(data[1:]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("unknown frame flag")
+		}
+	}
+	return data, nil
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, MaxDecompressedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return out, nil
+}
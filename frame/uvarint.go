@@ -0,0 +1,70 @@
+package frame
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// DefaultMaxFrameSize 为以下编解码器默认允许的最大帧负载长度，防止错误的长度字段导致大量分配
+const DefaultMaxFrameSize = 16 << 20
+
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// uvarintFrameCodec 与 protobuf 的 length-delimited 格式相同：
+// 负载长度（无符号 varint，不包含自身）+ 负载
+type uvarintFrameCodec struct {
+	maxSize int
+}
+
+// NewUvarintFrameCodec 创建 varint 长度前缀的编解码器，maxSize 不大于 0 时使用 DefaultMaxFrameSize
+func NewUvarintFrameCodec(maxSize int) StreamFrameCodec {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+	return &uvarintFrameCodec{maxSize: maxSize}
+}
+
+func (c *uvarintFrameCodec) Encode(w io.Writer, framePayload FramePayload) error {
+	if len(framePayload) > c.maxSize {
+		return ErrFrameTooLarge
+	}
+	buf := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(framePayload)), uint64(len(framePayload)))
+	buf = append(buf, framePayload...)
+	n, err := w.Write(buf)
+	if err != nil {
+		return err
+	}
+	if n != len(buf) {
+		return ErrShortWrite
+	}
+	return nil
+}
+
+func (c *uvarintFrameCodec) Decode(r io.Reader) (FramePayload, error) {
+	// 逐字节读取长度，不预读后面的数据，连接上的后续字节仍然留给下一次 Decode
+	size, err := binary.ReadUvarint(byteReader{r})
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(c.maxSize) {
+		return nil, ErrFrameTooLarge
+	}
+	buf := make([]byte, size)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return FramePayload(buf), nil
+}
+
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	if _, err := io.ReadFull(b.r, buf[:]); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
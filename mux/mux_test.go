@@ -0,0 +1,143 @@
+package mux
+
+import (
+	"37_tcp-server-demo1/client"
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/server"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newPair 返回通过内存管道相连的两端 Session
+func newPair(t *testing.T, cfg Config) (*Session, *Session) {
+	c1, c2 := net.Pipe()
+	cs := Client(c1, frame.NewMyFrameCodec(), cfg)
+	ss := Server(c2, frame.NewMyFrameCodec(), cfg)
+	t.Cleanup(func() {
+		cs.Close()
+		ss.Close()
+	})
+	return cs, ss
+}
+
+func TestStream_ReadWrite(t *testing.T) {
+	cs, ss := newPair(t, Config{})
+
+	// 两端都可以打开流
+	for _, pair := range [][2]*Session{{cs, ss}, {ss, cs}} {
+		st, err := pair[0].Open()
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		go func() {
+			st.Write([]byte("hello world"))
+			st.Close()
+		}()
+		accepted, err := pair[1].AcceptStream()
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if accepted.ID() != st.ID() {
+			t.Errorf("want %d, actual %d", st.ID(), accepted.ID())
+		}
+		data, err := io.ReadAll(accepted)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if string(data) != "hello world" {
+			t.Errorf("want hello world, actual %s", data)
+		}
+		// 两端都关闭后，流被释放
+		accepted.Close()
+		if n := pair[1].NumStreams(); n != 0 {
+			t.Errorf("want 0, actual %d", n)
+		}
+	}
+}
+
+func TestStream_FlowControl(t *testing.T) {
+	cs, ss := newPair(t, Config{Window: 1024, MaxFrameSize: 256})
+	st, err := cs.Open()
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	accepted, err := ss.AcceptStream()
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+
+	// 对端不读，写满一个窗口后 Write 阻塞直到超时
+	data := bytes.Repeat([]byte("x"), 4096)
+	st.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := st.Write(data)
+	if n != 1024 {
+		t.Errorf("want 1024, actual %d", n)
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("want timeout, actual %v", err)
+	}
+
+	// 对端开始读之后，窗口被归还，剩余数据可以继续写完
+	st.SetWriteDeadline(time.Time{})
+	go func() {
+		st.Write(data[n:])
+		st.Close()
+	}()
+	received, err := io.ReadAll(accepted)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if !bytes.Equal(received, data) {
+		t.Errorf("want %d bytes, actual %d", len(data), len(received))
+	}
+}
+
+func TestStream_ResetAndSessionClose(t *testing.T) {
+	cs, ss := newPair(t, Config{})
+	st, _ := cs.Open()
+	accepted, _ := ss.AcceptStream()
+
+	st.Reset()
+	accepted.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := accepted.Read(make([]byte, 1)); err != ErrStreamReset {
+		t.Errorf("want ErrStreamReset, actual %v", err)
+	}
+
+	st2, _ := cs.Open()
+	ss.AcceptStream()
+	cs.Close()
+	if _, err := st2.Write([]byte("x")); err == nil {
+		t.Errorf("want error after session closed, actual nil")
+	}
+	if _, err := ss.AcceptStream(); err == nil {
+		t.Errorf("want error after session closed, actual nil")
+	}
+}
+
+// 一条 TCP 连接上的多个流，分别作为独立的客户端连接交给 server.Server 处理
+func TestSession_ServeMultipleClients(t *testing.T) {
+	cs, ss := newPair(t, Config{})
+	srv := server.NewServer("", nil)
+	go srv.Serve(ss)
+	defer srv.Close()
+
+	for i := 0; i < 3; i++ {
+		st, err := cs.Open()
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		c, err := client.New(st, client.Options{})
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		defer c.Close()
+		submitAck, err := c.Send([]byte("hello"))
+		if err != nil || submitAck.Result != packet.ResultOK {
+			t.Errorf("want ok, actual %v %v", submitAck, err)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package mux
+
+import (
+	"37_tcp-server-demo1/frame"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+/* 多路复用层：在 frame.StreamFrameCodec 之上给每个帧打上流 ID，
+让一条 TCP 连接可以同时承载多个相互独立的逻辑流（Stream）。
+每个帧的 payload 格式：
+	Type(1字节) + StreamID(4字节，大端) + Body
+Type 为 typeData 时 Body 为数据；为 typeWindowUpdate 时 Body 为 4 字节的窗口增量；其余类型没有 Body。
+发起连接的一方（Client）使用奇数流 ID，另一方（Server）使用偶数流 ID，两边都可以打开新流。
+*/
+
+const (
+	typeOpen         = iota + 1 // 打开一个新流
+	typeData                    // 流上的数据
+	typeWindowUpdate            // 接收方处理完数据，增加发送方的发送窗口
+	typeClose                   // 发送方不会再写数据（半关闭）
+	typeReset                   // 异常终止一个流
+)
+
+const headerLen = 1 + 4
+
+var (
+	ErrSessionClosed = errors.New("mux session closed")
+	ErrStreamClosed  = errors.New("mux stream closed")
+	ErrStreamReset   = errors.New("mux stream reset by peer")
+	ErrTimeout       = timeoutError{}
+)
+
+// timeoutError 实现 net.Error，与 net.Conn 的读写超时行为保持一致
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type Config struct {
+	Window        uint32 // 每个流的接收窗口（字节），默认 256KB
+	MaxFrameSize  int    // 每个数据帧携带的最大字节数，默认 16KB
+	AcceptBacklog int    // 对端打开、还没被 Accept 的流的最大数量，默认 256
+}
+
+// Session 是一条被多路复用的连接。Session 同时实现了 net.Listener，
+// 可以直接交给 server.Server.Serve，让每个流作为一个独立的连接被处理
+type Session struct {
+	conn       net.Conn
+	frameCodec frame.StreamFrameCodec
+	cfg        Config
+
+	wmu sync.Mutex // 保证同一时刻只有一个 goroutine 往连接里写帧
+
+	mu       sync.Mutex
+	streams  map[uint32]*Stream
+	nextID   uint32
+	acceptCh chan *Stream
+	done     chan struct{}
+	err      error
+}
+
+// Client 在 conn 上创建发起方的 Session（使用奇数流 ID）
+func Client(conn net.Conn, frameCodec frame.StreamFrameCodec, cfg Config) *Session {
+	return newSession(conn, frameCodec, cfg, 1)
+}
+
+// Server 在 conn 上创建接收方的 Session（使用偶数流 ID）
+func Server(conn net.Conn, frameCodec frame.StreamFrameCodec, cfg Config) *Session {
+	return newSession(conn, frameCodec, cfg, 2)
+}
+
+func newSession(conn net.Conn, frameCodec frame.StreamFrameCodec, cfg Config, firstID uint32) *Session {
+	if cfg.Window == 0 {
+		cfg.Window = 256 << 10
+	}
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = 16 << 10
+	}
+	if cfg.AcceptBacklog <= 0 {
+		cfg.AcceptBacklog = 256
+	}
+	s := &Session{
+		conn:       conn,
+		frameCodec: frameCodec,
+		cfg:        cfg,
+		streams:    make(map[uint32]*Stream),
+		nextID:     firstID,
+		acceptCh:   make(chan *Stream, cfg.AcceptBacklog),
+		done:       make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// Open 打开一个新流
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	id := s.nextID
+	s.nextID += 2
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	if err := s.writeFrame(typeOpen, id, nil); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream 等待对端打开的下一个流
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.acceptCh:
+		return st, nil
+	case <-s.done:
+		return nil, s.Err()
+	}
+}
+
+// Accept 实现 net.Listener
+func (s *Session) Accept() (net.Conn, error) {
+	return s.AcceptStream()
+}
+
+// Addr 实现 net.Listener，返回底层连接的本地地址
+func (s *Session) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Close 关闭 Session 以及其上所有的流
+func (s *Session) Close() error {
+	s.fail(ErrSessionClosed)
+	return nil
+}
+
+// Err 返回 Session 不可用的原因，正常时为 nil
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// NumStreams 返回当前打开着的流的数量
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+func (s *Session) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	close(s.done)
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.mu.Unlock()
+
+	s.conn.Close()
+	for _, st := range streams {
+		st.notify()
+	}
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+func (s *Session) writeFrame(typ uint8, id uint32, body []byte) error {
+	buf := make([]byte, headerLen+len(body))
+	buf[0] = typ
+	binary.BigEndian.PutUint32(buf[1:5], id)
+	copy(buf[headerLen:], body)
+
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	select {
+	case <-s.done:
+		return s.Err()
+	default:
+	}
+	if err := s.frameCodec.Encode(s.conn, buf); err != nil {
+		s.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Session) writeWindowUpdate(id uint32, delta uint32) error {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, delta)
+	return s.writeFrame(typeWindowUpdate, id, body)
+}
+
+// recvLoop 读取对端发来的帧，并按流 ID 分发
+func (s *Session) recvLoop() {
+	for {
+		framePayload, err := s.frameCodec.Decode(s.conn)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		if len(framePayload) < headerLen {
+			s.fail(errors.New("mux frame too short"))
+			return
+		}
+		typ, id, body := framePayload[0], binary.BigEndian.Uint32(framePayload[1:5]), framePayload[headerLen:]
+		if err = s.handleFrame(typ, id, body); err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(typ uint8, id uint32, body []byte) error {
+	if typ == typeOpen {
+		return s.handleOpen(id)
+	}
+	s.mu.Lock()
+	st, ok := s.streams[id]
+	s.mu.Unlock()
+	if !ok {
+		return nil // 流已经被关闭或重置，丢弃迟到的帧
+	}
+	switch typ {
+	case typeData:
+		if err := st.receive(body); err != nil {
+			st.abort(ErrStreamReset)
+			return s.writeFrame(typeReset, id, nil)
+		}
+	case typeWindowUpdate:
+		if len(body) != 4 {
+			return errors.New("bad window update frame")
+		}
+		st.grow(binary.BigEndian.Uint32(body))
+	case typeClose:
+		st.remoteClose()
+	case typeReset:
+		st.abort(ErrStreamReset)
+	default:
+		return fmt.Errorf("unknown mux frame type [%d]", typ)
+	}
+	return nil
+}
+
+func (s *Session) handleOpen(id uint32) error {
+	s.mu.Lock()
+	if _, ok := s.streams[id]; ok || id%2 == s.nextID%2 {
+		s.mu.Unlock()
+		return fmt.Errorf("bad stream id [%d]", id)
+	}
+	st := newStream(s, id)
+	s.streams[id] = st
+	s.mu.Unlock()
+
+	select {
+	case s.acceptCh <- st:
+		return nil
+	default:
+		// 积压的流太多，直接拒绝
+		s.removeStream(id)
+		return s.writeFrame(typeReset, id, nil)
+	}
+}
@@ -0,0 +1,263 @@
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream 是 Session 上的一个逻辑流，实现了 net.Conn。
+// 每个流有独立的接收窗口：对端最多只能发送窗口大小的未读数据，
+// 本端读走数据后通过 WindowUpdate 归还窗口
+type Stream struct {
+	id   uint32
+	sess *Session
+
+	mu            sync.Mutex
+	buf           bytes.Buffer // 已收到、尚未被 Read 读走的数据
+	recvWindow    uint32       // 对端还能发送的字节数
+	consumed      uint32       // 自上次归还窗口以来被读走的字节数
+	sendWindow    uint32       // 本端还能发送的字节数
+	localClosed   bool
+	remoteClosed  bool
+	err           error // 流被重置的原因
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readCh  chan struct{} // 有新数据、对端关闭或流出错时通知 Read
+	writeCh chan struct{} // 窗口增大或流出错时通知 Write
+}
+
+func newStream(sess *Session, id uint32) *Stream {
+	return &Stream{
+		id:         id,
+		sess:       sess,
+		recvWindow: sess.cfg.Window,
+		sendWindow: sess.cfg.Window,
+		readCh:     make(chan struct{}, 1),
+		writeCh:    make(chan struct{}, 1),
+	}
+}
+
+// ID 返回流 ID
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+func (st *Stream) Read(p []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.buf.Len() > 0 {
+			n, _ := st.buf.Read(p)
+			st.consumed += uint32(n)
+			var delta uint32
+			// 读走的数据超过半个窗口时再归还，避免每次 Read 都发一个 WindowUpdate
+			if st.consumed >= st.sess.cfg.Window/2 {
+				delta, st.consumed = st.consumed, 0
+				st.recvWindow += delta
+			}
+			st.mu.Unlock()
+			if delta > 0 {
+				st.sess.writeWindowUpdate(st.id, delta)
+			}
+			return n, nil
+		}
+		if st.remoteClosed {
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		if err := st.errLocked(); err != nil {
+			st.mu.Unlock()
+			return 0, err
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+
+		if err := st.wait(st.readCh, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (st *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		st.mu.Lock()
+		if st.localClosed {
+			st.mu.Unlock()
+			return written, ErrStreamClosed
+		}
+		if err := st.errLocked(); err != nil {
+			st.mu.Unlock()
+			return written, err
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := st.wait(st.writeCh, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := len(p) - written
+		if n > st.sess.cfg.MaxFrameSize {
+			n = st.sess.cfg.MaxFrameSize
+		}
+		if uint32(n) > st.sendWindow {
+			n = int(st.sendWindow)
+		}
+		st.sendWindow -= uint32(n)
+		st.mu.Unlock()
+
+		if err := st.sess.writeFrame(typeData, st.id, p[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close 半关闭：通知对端本端不会再写数据，对端读完剩余数据后 Read 返回 io.EOF。
+// 两端都关闭后流被释放
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.localClosed || st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.localClosed = true
+	both := st.remoteClosed
+	st.mu.Unlock()
+	st.notify()
+
+	err := st.sess.writeFrame(typeClose, st.id, nil)
+	if both {
+		st.sess.removeStream(st.id)
+	}
+	return err
+}
+
+// Reset 异常终止流，两端未读的数据都会被丢弃
+func (st *Stream) Reset() error {
+	st.abort(ErrStreamClosed)
+	return st.sess.writeFrame(typeReset, st.id, nil)
+}
+
+func (st *Stream) LocalAddr() net.Addr {
+	return st.sess.conn.LocalAddr()
+}
+
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.sess.conn.RemoteAddr()
+}
+
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	st.notify() // 让阻塞中的 Read 按新的超时时间重新等待
+	return nil
+}
+
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	st.notify()
+	return nil
+}
+
+// errLocked 返回流不可用的原因，调用方需持有 st.mu
+func (st *Stream) errLocked() error {
+	if st.err != nil {
+		return st.err
+	}
+	select {
+	case <-st.sess.done:
+		return st.sess.Err()
+	default:
+		return nil
+	}
+}
+
+// wait 等待 ch 上的通知，直到超时
+func (st *Stream) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return ErrTimeout
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-st.sess.done:
+		return nil // 由调用方通过 errLocked 返回具体的错误
+	case <-timeout:
+		return ErrTimeout
+	}
+}
+
+func (st *Stream) notify() {
+	for _, ch := range []chan struct{}{st.readCh, st.writeCh} {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// receive 收到对端的数据，超出接收窗口视为对端违反流控
+func (st *Stream) receive(data []byte) error {
+	st.mu.Lock()
+	if uint32(len(data)) > st.recvWindow {
+		st.mu.Unlock()
+		return errors.New("flow control window exceeded")
+	}
+	st.recvWindow -= uint32(len(data))
+	st.buf.Write(data)
+	st.mu.Unlock()
+	st.notify()
+	return nil
+}
+
+func (st *Stream) grow(delta uint32) {
+	st.mu.Lock()
+	st.sendWindow += delta
+	st.mu.Unlock()
+	st.notify()
+}
+
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	both := st.localClosed
+	st.mu.Unlock()
+	st.notify()
+	if both {
+		st.sess.removeStream(st.id)
+	}
+}
+
+func (st *Stream) abort(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.buf.Reset()
+	st.mu.Unlock()
+	st.notify()
+	st.sess.removeStream(st.id)
+}
@@ -0,0 +1,166 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MaxBatchEntries 为一个 SubmitBatch 最多携带的消息数量
+const MaxBatchEntries = 0xFFFF
+
+// SubmitBatch 在一个帧中携带多条 Submit，服务端按顺序逐条交给 Submit 的 handler 处理。
+// 每条消息有自己的 ID，去重、签名和窗口都与单独发送的 Submit 一致
+type SubmitBatch struct {
+	ID      string    // 批次流水号（请求和响应的ID保持一致）
+	Entries []*Submit // 批次中的消息
+}
+
+// SubmitBatchAck 按 Entries 的顺序给出每条消息的处理结果。
+// Result 不为 ResultOK 时整个批次都没有被处理（例如未认证），Results 为空
+type SubmitBatchAck struct {
+	ID      string
+	Result  uint8
+	Results []uint8
+}
+
+func NewSubmitBatch(ID string, Entries []*Submit) *SubmitBatch {
+	return &SubmitBatch{ID: ID, Entries: Entries}
+}
+
+func NewSubmitBatchAck(ID string, Result uint8, Results []uint8) *SubmitBatchAck {
+	return &SubmitBatchAck{ID: ID, Result: Result, Results: Results}
+}
+
+// SubmitBatch 的包体格式：ID(8字节) + Count(2字节，大端) + Count 个 [ID(8字节) + Payload长度(4字节，大端) + Payload]
+func (p *SubmitBatch) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 SubmitBatch 的包体，批次 ID 和每条消息的 ID 都按 opts 编码
+func (p *SubmitBatch) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	id, rest, err := ReadID(packetBody, opts)
+	if err != nil {
+		return err
+	}
+	if len(rest) < 2 {
+		return errors.New("packetBody too short")
+	}
+	p.ID = id
+	count := int(binary.BigEndian.Uint16(rest[:2]))
+	rest = rest[2:]
+	p.Entries = make([]*Submit, 0, count)
+	for i := 0; i < count; i++ {
+		var entryID string
+		if entryID, rest, err = ReadID(rest, opts); err != nil {
+			return err
+		}
+		if len(rest) < 4 {
+			return errors.New("packetBody too short")
+		}
+		n := int(binary.BigEndian.Uint32(rest[:4]))
+		if len(rest)-4 < n {
+			return errors.New("packetBody too short")
+		}
+		p.Entries = append(p.Entries, NewSubmit(entryID, rest[4:4+n]))
+		rest = rest[4+n:]
+	}
+	return nil
+}
+
+func (p *SubmitBatch) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 SubmitBatch 的包体
+func (p *SubmitBatch) EncodeWith(opts Options) ([]byte, error) {
+	body, err := AppendID(nil, p.ID, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(p.Entries) > MaxBatchEntries {
+		return nil, fmt.Errorf("too many entries, max %d", MaxBatchEntries)
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(p.Entries)))
+	for _, e := range p.Entries {
+		if body, err = AppendID(body, e.ID, opts); err != nil {
+			return nil, fmt.Errorf("entry %w", err)
+		}
+		body = binary.BigEndian.AppendUint32(body, uint32(len(e.Payload)))
+		body = append(body, e.Payload...)
+	}
+	return body, nil
+}
+
+// SubmitBatchAck 的包体格式：ID(8字节) + Result(1字节) + Count(2字节，大端) + Count 个 Result(1字节)
+func (p *SubmitBatchAck) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 SubmitBatchAck 的包体
+func (p *SubmitBatchAck) DecodeWith(packetBody []byte, opts Options) error {
+	id, result, rest, err := decodeAckBody(packetBody, opts)
+	if err != nil {
+		return err
+	}
+	if len(rest) < 2 {
+		return errors.New("packetBody too short")
+	}
+	count := int(binary.BigEndian.Uint16(rest[:2]))
+	if len(rest) < 2+count {
+		return errors.New("packetBody too short")
+	}
+	p.ID, p.Result = id, result
+	p.Results = rest[2 : 2+count]
+	return nil
+}
+
+func (p *SubmitBatchAck) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 SubmitBatchAck 的包体
+func (p *SubmitBatchAck) EncodeWith(opts Options) ([]byte, error) {
+	if len(p.Results) > MaxBatchEntries {
+		return nil, fmt.Errorf("too many results, max %d", MaxBatchEntries)
+	}
+	for _, r := range p.Results {
+		if r > resultMax {
+			return nil, fmt.Errorf("unknown result [%d]", r)
+		}
+	}
+	body, err := encodeAckBody(p.ID, p.Result, opts)
+	if err != nil {
+		return nil, err
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(p.Results)))
+	return append(body, p.Results...), nil
+}
+
+// SubmitBatchAck 开头的 ID(8字节) + Result(1字节)，与 SubmitAck 相同，rest 为 Result 之后的部分
+func decodeAckBody(packetBody []byte, opts Options) (id string, result uint8, rest []byte, err error) {
+	if packetBody == nil {
+		return "", 0, nil, errors.New("packetBody is nil")
+	}
+	if id, rest, err = ReadID(packetBody, opts); err != nil {
+		return "", 0, nil, err
+	}
+	if len(rest) < 1 {
+		return "", 0, nil, errors.New("packetBody too short")
+	}
+	return id, rest[0], rest[1:], nil
+}
+
+func encodeAckBody(id string, result uint8, opts Options) ([]byte, error) {
+	body, err := AppendID(nil, id, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result > resultMax {
+		return nil, fmt.Errorf("unknown result [%d]", result)
+	}
+	return append(body, result), nil
+}
@@ -0,0 +1,64 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSubmitBatch_EncodeDecode(t *testing.T) {
+	batch := NewSubmitBatch("12345678", []*Submit{
+		NewSubmit("00000001", []byte("hello")),
+		NewSubmit("00000002", nil),
+		NewSubmit("00000003", []byte("world")),
+	})
+	decode, err := Decode(mustEncode(t, batch))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	d, ok := decode.(*SubmitBatch)
+	if !ok {
+		t.Errorf("want *SubmitBatch, actual %T", decode)
+		return
+	}
+	if d.ID != batch.ID || len(d.Entries) != 3 {
+		t.Errorf("want %v, actual %v", batch, d)
+		return
+	}
+	for i, e := range batch.Entries {
+		if d.Entries[i].ID != e.ID || !bytes.Equal(d.Entries[i].Payload, e.Payload) {
+			t.Errorf("entry %d: want %v, actual %v", i, e, d.Entries[i])
+		}
+	}
+
+	// 条目被截断
+	encode := mustEncode(t, batch)
+	if _, err = Decode(encode[:len(encode)-1]); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+	batch.Entries[1].ID = "short"
+	if _, err = Encode(batch); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
+
+func TestSubmitBatchAck_EncodeDecode(t *testing.T) {
+	ack := NewSubmitBatchAck("12345678", ResultOK, []uint8{ResultOK, ResultError, ResultReplay})
+	decode, err := Decode(mustEncode(t, ack))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	d := decode.(*SubmitBatchAck)
+	if d.ID != ack.ID || d.Result != ResultOK || !bytes.Equal(d.Results, ack.Results) {
+		t.Errorf("want %v, actual %v", ack, d)
+	}
+
+	ack.Results = []uint8{resultMax + 1}
+	if _, err = Encode(ack); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	if _, err = Decode([]byte{CommandSubmitBatchAck, '1', '2', '3', '4', '5', '6', '7', '8', 0, 0, 2, 0}); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
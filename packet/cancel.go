@@ -0,0 +1,41 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+/* 截止时间和取消。
+带截止时间的 Submit 使用单独的 CommandSubmitDeadline，包体格式：
+ID(8字节) + Deadline(8字节，大端，Unix 纳秒) + Payload
+不带截止时间的 Submit 仍然使用 CommandSubmit，旧版本的服务端和客户端不受影响。
+Cancel 由客户端发送，包类型由 protocol.json 生成（见 packets_gen.go）
+*/
+
+// encodeDeadline 编码带截止时间的 Submit 包体
+func (p *Submit) encodeDeadline(opts Options) ([]byte, error) {
+	body, err := AppendID(nil, p.ID, opts)
+	if err != nil {
+		return nil, err
+	}
+	body = binary.BigEndian.AppendUint64(body, uint64(p.Deadline.UnixNano()))
+	return append(body, p.Payload...), nil
+}
+
+func (p *Submit) decodeDeadline(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	id, rest, err := ReadID(packetBody, opts)
+	if err != nil {
+		return err
+	}
+	if len(rest) < 8 {
+		return errors.New("packetBody too short")
+	}
+	p.ID = id
+	p.Deadline = time.Unix(0, int64(binary.BigEndian.Uint64(rest[:8])))
+	p.Payload = rest[8:]
+	return nil
+}
@@ -0,0 +1,73 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCancel(t *testing.T) {
+	decode, err := Decode(mustEncode(t, NewCancel("12345678")))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if c, ok := decode.(*Cancel); !ok || c.ID != "12345678" {
+		t.Errorf("want cancel[12345678], actual %v", decode)
+	}
+	if _, err = Encode(NewCancel("short")); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
+
+func TestSubmit_Deadline(t *testing.T) {
+	// 没有截止时间时编码结果与原来完全一致
+	encode := mustEncode(t, NewSubmit("12345678", []byte("hello")))
+	if encode[0] != CommandSubmit {
+		t.Errorf("want %d, actual %d", CommandSubmit, encode[0])
+	}
+
+	deadline := time.Unix(0, time.Now().Add(time.Second).UnixNano())
+	submit := &Submit{ID: "12345678", Payload: []byte("hello"), Deadline: deadline}
+	encode = mustEncode(t, submit)
+	if encode[0] != CommandSubmitDeadline {
+		t.Errorf("want %d, actual %d", CommandSubmitDeadline, encode[0])
+	}
+	decode, err := Decode(encode)
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	d := decode.(*Submit)
+	if d.ID != submit.ID || !bytes.Equal(d.Payload, submit.Payload) || !d.Deadline.Equal(deadline) {
+		t.Errorf("want %v, actual %v", submit, d)
+	}
+	if _, err = Decode(encode[:10]); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
+
+func TestSignSubmit_Deadline(t *testing.T) {
+	key := []byte("key")
+	deadline := time.Unix(0, time.Now().Add(time.Second).UnixNano())
+	signed := SignSubmit(key, &Submit{ID: "12345678", Payload: []byte("hello"), Deadline: deadline}, 1, time.Now())
+	decode, err := Decode(mustEncode(t, signed))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	verified, _, _, err := VerifySubmit(key, decode.(*Submit))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if !verified.Deadline.Equal(deadline) || string(verified.Payload) != "hello" {
+		t.Errorf("want deadline %v and payload hello, actual %v", deadline, verified)
+	}
+
+	// 截止时间被篡改
+	decode.(*Submit).Deadline = deadline.Add(time.Hour)
+	if _, _, _, err = VerifySubmit(key, decode.(*Submit)); err != ErrBadSignature {
+		t.Errorf("want ErrBadSignature, actual %v", err)
+	}
+}
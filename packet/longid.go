@@ -0,0 +1,58 @@
+package packet
+
+import (
+	"errors"
+	"fmt"
+)
+
+/* 变长 ID（CapLongIDs）。
+协商了 CapLongIDs 的连接在 ConnAck 之后，所有包的 ID 都编码为 ID长度(1字节) + ID（1~MaxIDLen 字节），
+SubmitBatch 中每条消息的 ID 也是如此，其余字段与定长 ID 的格式完全相同。
+各个包的 EncodeWith/DecodeWith 通过 AppendID/ReadID 按 Options 编解码 ID，生成的包由 cmd/packetgen 按字段类型 id 生成。
+Conn/ConnAck 在协商之前发送，ID 始终为 8 字节
+*/
+
+// MaxIDLen 为开启 CapLongIDs 后 ID 的最大长度
+const MaxIDLen = 0xFF
+
+// Options 为连接协商出的包编码方式，零值与 Encode/Decode 相同
+type Options struct {
+	LongIDs bool // 变长 ID，见 CapLongIDs
+}
+
+// OptionsFromCaps 返回协商出的能力对应的包编码方式
+func OptionsFromCaps(caps uint32) Options {
+	return Options{LongIDs: caps&CapLongIDs != 0}
+}
+
+// AppendID 按 opts 把 ID 追加到 b 之后：默认为定长 8 字节，开启 LongIDs 时为 ID长度(1字节) + ID
+func AppendID(b []byte, id string, opts Options) ([]byte, error) {
+	if !opts.LongIDs {
+		if len(id) != 8 {
+			return nil, errors.New("ID must be exactly 8 bytes")
+		}
+		return append(b, id...), nil
+	}
+	if len(id) == 0 || len(id) > MaxIDLen {
+		return nil, fmt.Errorf("ID must be 1 to %d bytes", MaxIDLen)
+	}
+	return append(append(b, byte(len(id))), id...), nil
+}
+
+// ReadID 按 opts 从 b 的开头读出 ID，返回 ID 之后的部分
+func ReadID(b []byte, opts Options) (id string, rest []byte, err error) {
+	if !opts.LongIDs {
+		if len(b) < 8 {
+			return "", nil, errors.New("packetBody too short")
+		}
+		return string(b[:8]), b[8:], nil
+	}
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, errors.New("packetBody too short")
+	}
+	n := int(b[0])
+	if n == 0 {
+		return "", nil, errors.New("empty ID")
+	}
+	return string(b[1 : 1+n]), b[1+n:], nil
+}
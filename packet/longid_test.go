@@ -0,0 +1,64 @@
+package packet
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeWith_LongIDs(t *testing.T) {
+	opts := Options{LongIDs: true}
+	long := strings.Repeat("x", MaxIDLen)
+	packets := []Packet{
+		NewSubmit("a", []byte("hello")),
+		&Submit{ID: "submit-with-deadline", Payload: []byte("hello"), Deadline: time.Unix(0, 1234)},
+		&SubmitAck{ID: long, Result: ResultOK, Payload: []byte("reply")},
+		NewPublish("publish-1", "a/b", []byte("hello")),
+		NewCancel("cancel-1"),
+		NewSubmitBatch("batch-1", []*Submit{
+			NewSubmit("e1", []byte("hello")),
+			NewSubmit("entry-0000000002", []byte("world")),
+		}),
+		NewWindowUpdate(10), // 没有 ID，格式不变
+	}
+	for _, p := range packets {
+		data, err := EncodeWith(p, opts)
+		if err != nil {
+			t.Errorf("%T: want nil, actual %s", p, err.Error())
+			continue
+		}
+		decode, err := DecodeWith(data, opts)
+		if err != nil {
+			t.Errorf("%T: want nil, actual %s", p, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(decode, p) {
+			t.Errorf("want %+v, actual %+v", p, decode)
+		}
+	}
+
+	// ID 的长度前缀紧跟在命令字之后
+	data, err := EncodeWith(NewSubmit("abc", []byte("hello")), opts)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if want := []byte("\x02\x03abchello"); !bytes.Equal(data, want) {
+		t.Errorf("want %q, actual %q", want, data)
+	}
+
+	// 没有协商时与 Encode 相同，仍然要求 8 字节
+	if _, err = EncodeWith(NewSubmit("abc", nil), Options{}); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	if _, err = EncodeWith(NewSubmit("", nil), opts); err == nil {
+		t.Errorf("want error for empty ID, actual nil")
+	}
+	if _, err = EncodeWith(NewSubmit(long+"x", nil), opts); err == nil {
+		t.Errorf("want error for too long ID, actual nil")
+	}
+	if _, err = DecodeWith(data[:4], opts); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
@@ -2,20 +2,56 @@ package packet
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"time"
 )
 
 const (
-	CommandConn   = iota + 0x01 // 0x01  连接请求包
-	CommandSubmit               // 0x02 消息请求包
+	CommandConn           = iota + 0x01 // 0x01  连接请求包
+	CommandSubmit                       // 0x02 消息请求包
+	CommandSubscribe                    // 0x03 订阅请求包
+	CommandUnsubscribe                  // 0x04 取消订阅请求包
+	CommandPublish                      // 0x05 发布包（服务端推送给订阅者时也使用该包）
+	CommandTransferBegin                // 0x06 分块传输开始包
+	CommandTransferChunk                // 0x07 分块传输数据包
+	CommandTransferEnd                  // 0x08 分块传输结束包
+	CommandWindowUpdate                 // 0x09 窗口更新包（服务端推送，客户端无需应答）
+	CommandSubmitBatch                  // 0x0A 批量消息请求包
+	CommandSubmitDeadline               // 0x0B 带截止时间的消息请求包（解码后同样是 *Submit）
+	CommandCancel                       // 0x0C 取消请求包（客户端发送，服务端无需应答）
 )
 
 const (
-	CommandConnAck   = iota + 0x80 // 0x81 连接响应包
-	CommandSubmitAck               //0x82 消息响应包
+	CommandConnAck          = iota + 0x80 // 0x80 连接响应包
+	CommandSubmitAck                      // 0x81 消息响应包
+	CommandSubscribeAck                   // 0x82 订阅响应包
+	CommandUnsubscribeAck                 // 0x83 取消订阅响应包
+	CommandPublishAck                     // 0x84 发布响应包
+	CommandTransferBeginAck               // 0x85 分块传输开始响应包
+	CommandTransferChunkAck               // 0x86 分块传输数据响应包
+	CommandTransferEndAck                 // 0x87 分块传输结束响应包
+	CommandSubmitBatchAck                 // 0x88 批量消息响应包
 )
 
+// 响应状态码，所有 Ack 包共用
+const (
+	ResultOK             = iota // 0 正常
+	ResultError                 // 1 错误
+	ResultAuthFailed            // 2 认证失败（客户端不应重试）
+	ResultBadSignature          // 3 签名校验失败，或服务端要求签名而客户端未协商
+	ResultReplay                // 4 重放的 Submit（Seq 未递增或时间戳超出窗口）
+	ResultBadOffset             // 5 分块传输的偏移量与服务端期望的不一致
+	ResultDigestMismatch        // 6 分块传输结束时摘要或长度校验失败
+	ResultNoCredit              // 7 客户端同时等待应答的 Submit 超过了服务端通告的窗口
+	ResultCanceled              // 8 请求在处理前已被取消或超过了截止时间
+	ResultBadVersion            // 9 客户端支持的协议版本与服务端没有交集
+)
+
+// resultMax 为当前已定义的最大响应状态码，Encode 时用来校验 Result 是否合法
+const resultMax = ResultBadVersion
+
 type Packet interface {
 	Decode([]byte) error     // []byte -> struct
 	Encode() ([]byte, error) // struct -> []byte
@@ -24,13 +60,55 @@ type Packet interface {
 	*/
 }
 
+type Conn struct {
+	ID    string // 连接流水号（请求和响应的ID保持一致）
+	Token []byte // 认证令牌，服务端未开启认证时可为空
+	Nonce []byte // 可选，客户端随机数（NonceLen 字节），携带时表示请求对 Submit 签名
+
+	Versions []uint8 // 可选，客户端支持的协议版本，不携带表示 ProtocolVersion1
+	Caps     uint32  // 可选，客户端希望开启的能力（CapXXX），携带时必须同时携带 Versions
+}
+type ConnAck struct {
+	ID     string // 连接流水号（请求和响应的ID保持一致）
+	Result uint8  // 响应状态（见 ResultXXX 常量）
+	Nonce  []byte // 可选，服务端随机数（NonceLen 字节），携带时表示同意对 Submit 签名
+	Window uint32 // 可选，允许客户端同时等待应答的 Submit 数量，0 表示不限制
+
+	Version uint8  // 可选，服务端选中的协议版本，Conn 未携带 Versions 时不回复
+	Caps    uint32 // 可选，最终开启的能力，携带时必须同时携带 Version
+}
+
 type Submit struct {
-	ID      string // 消息流水号（请求和响应的ID保持一致）
-	Payload []byte // 消息的有效载荷
+	ID       string    // 消息流水号（请求和响应的ID保持一致）
+	Payload  []byte    // 消息的有效载荷
+	Deadline time.Time // 可选，客户端等待应答的截止时间，零值表示没有截止时间
 }
 type SubmitAck struct { // SubmitAck 是 Submit Acknowledgement 的缩写，表示提交应答
-	ID     string // 消息流水号（请求和响应的ID保持一致）
-	Result uint8  // 响应状态（0：正常，1：错误）
+	ID      string // 消息流水号（请求和响应的ID保持一致）
+	Result  uint8  // 响应状态（0：正常，1：错误，其余见 ResultXXX 常量）
+	Payload []byte // 可选，应答携带的数据（例如 RPC 的返回值），旧版本的客户端会忽略
+}
+
+func NewConnWithoutParam() *Conn {
+	return &Conn{}
+}
+
+func NewConn(ID string, Token []byte) *Conn {
+	return &Conn{
+		ID:    ID,
+		Token: Token,
+	}
+}
+
+func NewConnAckWithoutParam() *ConnAck {
+	return &ConnAck{}
+}
+
+func NewConnAck(ID string, Result uint8) *ConnAck {
+	return &ConnAck{
+		ID:     ID,
+		Result: Result,
+	}
 }
 
 func NewSubmitWithoutParam() *Submit {
@@ -55,59 +133,255 @@ func NewSubmitAck(ID string, Result uint8) *SubmitAck {
 	}
 }
 
-/* 先声明出 Submit 以及 SubmitAck 两种类型的 Encode 和 Decode 方法，
-再声明出通用的 Encode 和 Decode函数，根据CommandID字段选择对应的方法
+/* Conn/ConnAck 末尾的可选字段按加入协议的先后依次排列，旧版本的包可以不带。
+携带后面的字段时，前面的可选字段必须出现：未使用的 Nonce 用全 0 占位，
+解码时后面还有其他字段的全 0 Nonce 还原为 nil
 */
 
-func (p *Submit) Decode(packetBody []byte) error {
+// decodeNonce 解析可选的随机数字段，返回剩余的包体。body 为空表示未携带
+func decodeNonce(body []byte) (nonce []byte, rest []byte, err error) {
+	switch {
+	case len(body) == 0:
+		return nil, nil, nil
+	case len(body) < NonceLen:
+		return nil, nil, errors.New("packetBody too short")
+	case len(body) > NonceLen && bytes.Equal(body[:NonceLen], make([]byte, NonceLen)):
+		return nil, body[NonceLen:], nil
+	default:
+		return body[:NonceLen], body[NonceLen:], nil
+	}
+}
+
+// encodeNonce 编码随机数字段，后面还有其他字段时用全 0 占位
+func encodeNonce(nonce []byte, more bool) []byte {
+	if nonce == nil && more {
+		return make([]byte, NonceLen)
+	}
+	return nonce
+}
+
+func checkNonce(nonce []byte) error {
+	if nonce != nil && len(nonce) != NonceLen {
+		return fmt.Errorf("nonce must be exactly %d bytes", NonceLen)
+	}
+	return nil
+}
+
+// Conn 的包体格式：ID(8字节) + Token长度(2字节，大端) + Token + [Nonce(16字节)]
+// + [Versions个数(1字节) + Versions(每个1字节)] + [Caps(4字节，大端)]
+// Token 带长度前缀，是为了能在 Token 之后追加新字段
+func (p *Conn) Decode(packetBody []byte) error {
 	if packetBody == nil {
 		return errors.New("packetBody is nil")
 	}
-	// 各具体类型分别负责检查各自的传参长度(最低情况为8个字节，也就是无Payload）
-	if len(packetBody) < 8 {
+	// 最低情况为 8 字节 ID + 2 字节 Token 长度
+	if len(packetBody) < 10 {
 		return errors.New("packetBody too short")
 	}
 	p.ID = string(packetBody[:8])
-	p.Payload = packetBody[8:]
+	tokenLen := int(binary.BigEndian.Uint16(packetBody[8:10]))
+	if len(packetBody) < 10+tokenLen {
+		return errors.New("packetBody too short")
+	}
+	p.Token = packetBody[10 : 10+tokenLen]
+	nonce, rest, err := decodeNonce(packetBody[10+tokenLen:])
+	if err != nil {
+		return err
+	}
+	p.Nonce = nonce
+	p.Versions, p.Caps = nil, 0
+	if len(rest) > 0 {
+		n := int(rest[0])
+		if len(rest) < 1+n {
+			return errors.New("packetBody too short")
+		}
+		p.Versions = rest[1 : 1+n]
+		if caps := rest[1+n:]; len(caps) > 0 {
+			if len(caps) < 4 {
+				return errors.New("packetBody too short")
+			}
+			p.Caps = binary.BigEndian.Uint32(caps)
+		}
+	}
 	return nil
 }
-func (p *Submit) Encode() ([]byte, error) {
+
+func (p *Conn) Encode() ([]byte, error) {
 	if len(p.ID) != 8 {
 		return nil, errors.New("ID must be exactly 8 bytes")
 	}
-	return bytes.Join([][]byte{[]byte(p.ID[:8]), p.Payload}, nil), nil
-	/*
-		func Join(s [][]byte, sep []byte) []byte
-		s数组为需要连接的多个切片， sep为分隔符
-	*/
+	if len(p.Token) > 0xFFFF {
+		return nil, errors.New("token too long")
+	}
+	if err := checkNonce(p.Nonce); err != nil {
+		return nil, err
+	}
+	if len(p.Versions) > 0xFF {
+		return nil, errors.New("too many versions")
+	}
+	if p.Caps != 0 && len(p.Versions) == 0 {
+		return nil, errors.New("caps require versions")
+	}
+	var versions, caps []byte
+	if len(p.Versions) > 0 {
+		versions = append([]byte{uint8(len(p.Versions))}, p.Versions...)
+	}
+	if p.Caps != 0 {
+		caps = binary.BigEndian.AppendUint32(nil, p.Caps)
+	}
+	tokenLen := make([]byte, 2)
+	binary.BigEndian.PutUint16(tokenLen, uint16(len(p.Token)))
+	return bytes.Join([][]byte{[]byte(p.ID), tokenLen, p.Token, encodeNonce(p.Nonce, versions != nil), versions, caps}, nil), nil
 }
 
-func (p *SubmitAck) Decode(packetBody []byte) error {
+func (p *ConnAck) Decode(packetBody []byte) error {
 	if packetBody == nil {
 		return errors.New("packetBody is nil")
 	}
-	// 必须要有Result这一位，也就是说，最低的情况为9个字节
+	// 与 SubmitAck 相同，8 字节 ID + 1 字节 Result
 	if len(packetBody) < 9 {
 		return errors.New("packetBody too short")
 	}
 	p.ID = string(packetBody[:8])
 	p.Result = packetBody[8]
+	nonce, rest, err := decodeNonce(packetBody[9:])
+	if err != nil {
+		return err
+	}
+	p.Nonce = nonce
+	p.Window, p.Version, p.Caps = 0, 0, 0
+	if len(rest) > 0 {
+		if len(rest) < 4 {
+			return errors.New("packetBody too short")
+		}
+		p.Window = binary.BigEndian.Uint32(rest[:4])
+		rest = rest[4:]
+	}
+	if len(rest) > 0 {
+		p.Version = rest[0]
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		if len(rest) < 4 {
+			return errors.New("packetBody too short")
+		}
+		p.Caps = binary.BigEndian.Uint32(rest[:4])
+	}
 	return nil
 }
 
-func (p *SubmitAck) Encode() ([]byte, error) {
+// ConnAck 的包体格式：ID(8字节) + Result(1字节) + [Nonce(16字节)] + [Window(4字节，大端)]
+// + [Version(1字节)] + [Caps(4字节，大端)]，后面的字段存在时 Window 用 0（不限制）占位
+func (p *ConnAck) Encode() ([]byte, error) {
+	if len(p.ID) != 8 {
+		return nil, errors.New("ID must be exactly 8 bytes")
+	}
+	if p.Result > resultMax {
+		return nil, fmt.Errorf("unknown result [%d]", p.Result)
+	}
+	if err := checkNonce(p.Nonce); err != nil {
+		return nil, err
+	}
+	if p.Caps != 0 && p.Version == 0 {
+		return nil, errors.New("caps require version")
+	}
+	var window, version, caps []byte
+	if p.Caps != 0 {
+		caps = binary.BigEndian.AppendUint32(nil, p.Caps)
+	}
+	if p.Version != 0 {
+		version = []byte{p.Version}
+	}
+	if p.Window > 0 || version != nil {
+		window = binary.BigEndian.AppendUint32(nil, p.Window)
+	}
+	return bytes.Join([][]byte{[]byte(p.ID), []byte{p.Result}, encodeNonce(p.Nonce, window != nil), window, version, caps}, nil), nil
+}
 
-	if len(p.ID) < 8 {
-		return nil, errors.New("ID too short")
+/* 先声明出 Submit 以及 SubmitAck 两种类型的 Encode 和 Decode 方法，
+再声明出通用的 Encode 和 Decode函数，根据CommandID字段选择对应的方法
+*/
+
+func (p *Submit) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 Submit 的包体
+func (p *Submit) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	// 各具体类型分别负责检查各自的传参长度(最低情况为ID，也就是无Payload）
+	id, rest, err := ReadID(packetBody, opts)
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	p.Payload = rest
+	return nil
+}
+
+// Encode 只编码 ID 和 Payload；带 Deadline 的 Submit 由 Encode 函数选择 CommandSubmitDeadline 编码
+func (p *Submit) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 Submit 的包体，与 Encode 一样不编码 Deadline
+func (p *Submit) EncodeWith(opts Options) ([]byte, error) {
+	body, err := AppendID(nil, p.ID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return append(body, p.Payload...), nil
+}
+
+func (p *SubmitAck) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 SubmitAck 的包体
+func (p *SubmitAck) DecodeWith(packetBody []byte, opts Options) error {
+	// 必须要有Result这一位
+	id, result, rest, err := decodeAckBody(packetBody, opts)
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	p.Result = result
+	p.Payload = nil
+	if len(rest) > 0 {
+		p.Payload = rest
 	}
-	if p.Result != 0 && p.Result != 1 {
-		return nil, errors.New("result must be 0 or 1")
+	return nil
+}
+
+// SubmitAck 的包体格式：ID(8字节) + Result(1字节) + [Payload]
+func (p *SubmitAck) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 SubmitAck 的包体
+func (p *SubmitAck) EncodeWith(opts Options) ([]byte, error) {
+	body, err := encodeAckBody(p.ID, p.Result, opts)
+	if err != nil {
+		return nil, err
 	}
+	return append(body, p.Payload...), nil
+}
 
-	return bytes.Join([][]byte{[]byte(p.ID[:8]), []byte{p.Result}}, nil), nil
+// optionsPacket 是编码格式取决于 Options 的登记包，cmd/packetgen 生成的包都实现了它
+type optionsPacket interface {
+	CommandPacket
+	EncodeWith(opts Options) ([]byte, error)
+	DecodeWith(packetBody []byte, opts Options) error
 }
 
 func Encode(p Packet) ([]byte, error) {
+	return EncodeWith(p, Options{})
+}
+
+// EncodeWith 按连接协商出的 opts 编码包，opts 为零值时与 Encode 相同
+func EncodeWith(p Packet, opts Options) ([]byte, error) {
 	if p == nil {
 		return nil, errors.New("packet is nil")
 	}
@@ -117,14 +391,55 @@ func Encode(p Packet) ([]byte, error) {
 		err        error
 	)
 	switch t := p.(type) {
+	case *Conn:
+		commandID = CommandConn
+		packetBody, err = t.Encode()
+		if err != nil {
+			return nil, err
+		}
+	case *ConnAck:
+		commandID = CommandConnAck
+		packetBody, err = t.Encode()
+		if err != nil {
+			return nil, err
+		}
 	case *Submit:
 		commandID = CommandSubmit
-		packetBody, err = t.Encode()
+		if t.Deadline.IsZero() {
+			packetBody, err = t.EncodeWith(opts)
+		} else {
+			commandID = CommandSubmitDeadline
+			packetBody, err = t.encodeDeadline(opts)
+		}
 		if err != nil {
 			return nil, err
 		}
 	case *SubmitAck:
 		commandID = CommandSubmitAck
+		packetBody, err = t.EncodeWith(opts)
+		if err != nil {
+			return nil, err
+		}
+	case *SubmitBatch:
+		commandID = CommandSubmitBatch
+		packetBody, err = t.EncodeWith(opts)
+		if err != nil {
+			return nil, err
+		}
+	case *SubmitBatchAck:
+		commandID = CommandSubmitBatchAck
+		packetBody, err = t.EncodeWith(opts)
+		if err != nil {
+			return nil, err
+		}
+	case optionsPacket:
+		commandID = t.Command()
+		packetBody, err = t.EncodeWith(opts)
+		if err != nil {
+			return nil, err
+		}
+	case CommandPacket:
+		commandID = t.Command()
 		packetBody, err = t.Encode()
 		if err != nil {
 			return nil, err
@@ -136,6 +451,11 @@ func Encode(p Packet) ([]byte, error) {
 }
 
 func Decode(packet []byte) (Packet, error) {
+	return DecodeWith(packet, Options{})
+}
+
+// DecodeWith 按连接协商出的 opts 解码包，opts 为零值时与 Decode 相同
+func DecodeWith(packet []byte, opts Options) (Packet, error) {
 	if packet == nil || len(packet) == 0 {
 		return nil, errors.New("packet is nil")
 	}
@@ -144,24 +464,66 @@ func Decode(packet []byte) (Packet, error) {
 	packetBody := packet[1:]
 	switch commandId {
 	case CommandConn:
-		return nil, nil
+		c := Conn{}
+		err := c.Decode(packetBody)
+		if err != nil {
+			return nil, err
+		}
+		return &c, nil
 	case CommandConnAck:
-		return nil, nil
+		c := ConnAck{}
+		err := c.Decode(packetBody)
+		if err != nil {
+			return nil, err
+		}
+		return &c, nil
 	case CommandSubmit:
 		s := Submit{}
-		err := s.Decode(packetBody) // 注意，Decode时修改了s的内容
+		err := s.DecodeWith(packetBody, opts) // 注意，Decode时修改了s的内容
 		if err != nil {
 			return nil, err
 		}
 		return &s, nil
+	case CommandSubmitDeadline:
+		s := Submit{}
+		if err := s.decodeDeadline(packetBody, opts); err != nil {
+			return nil, err
+		}
+		return &s, nil
 	case CommandSubmitAck:
 		s := SubmitAck{}
-		err := s.Decode(packetBody) // 注意，Decode时修改了s的内容
+		err := s.DecodeWith(packetBody, opts) // 注意，Decode时修改了s的内容
 		if err != nil {
 			return nil, err
 		}
 		return &s, nil
+	case CommandSubmitBatch:
+		b := SubmitBatch{}
+		if err := b.DecodeWith(packetBody, opts); err != nil {
+			return nil, err
+		}
+		return &b, nil
+	case CommandSubmitBatchAck:
+		b := SubmitBatchAck{}
+		if err := b.DecodeWith(packetBody, opts); err != nil {
+			return nil, err
+		}
+		return &b, nil
 	default:
-		return nil, fmt.Errorf("unknown commandID [%d]", commandId)
+		newPacket, ok := registry[commandId]
+		if !ok {
+			return nil, fmt.Errorf("unknown commandID [%d]", commandId)
+		}
+		p := newPacket()
+		if op, ok := p.(optionsPacket); ok {
+			if err := op.DecodeWith(packetBody, opts); err != nil {
+				return nil, err
+			}
+			return p, nil
+		}
+		if err := p.Decode(packetBody); err != nil {
+			return nil, err
+		}
+		return p, nil
 	}
 }
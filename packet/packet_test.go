@@ -98,6 +98,26 @@ func TestSubmitAck_Decode(t *testing.T) {
 		t.Errorf("want %x, actual %x", 0, emptySubmitAck.Result)
 		return
 	}
+	if emptySubmitAck.Payload != nil {
+		t.Errorf("want nil payload, actual %x", emptySubmitAck.Payload)
+	}
+}
+
+func TestSubmitAck_Payload(t *testing.T) {
+	submitAck := &SubmitAck{ID: "12345678", Result: ResultOK, Payload: []byte("reply")}
+	encode, err := submitAck.Encode()
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	decoded := NewSubmitAckWithoutParam()
+	if err = decoded.Decode(encode); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if string(decoded.Payload) != "reply" {
+		t.Errorf("want reply, actual %s", decoded.Payload)
+	}
 }
 
 func TestSubmitAck_Decode_Error(t *testing.T) {
@@ -224,3 +244,190 @@ func TestDecode_Error(t *testing.T) {
 		t.Errorf("want SubmitAck body too short error, got %v", err)
 	}
 }
+
+func TestConn_EncodeDecode(t *testing.T) {
+	conn := NewConn("12345678", []byte("token"))
+	encode, err := conn.Encode()
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	expected := append([]byte("12345678"), 0x0, 0x5, 't', 'o', 'k', 'e', 'n')
+	if !bytes.Equal(encode, expected) {
+		t.Errorf("want %x, actual %x", expected, encode)
+		return
+	}
+
+	emptyConn := NewConnWithoutParam()
+	err = emptyConn.Decode(encode)
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if emptyConn.ID != "12345678" {
+		t.Errorf("want %s, actual %s", "12345678", emptyConn.ID)
+	}
+	if string(emptyConn.Token) != "token" {
+		t.Errorf("want %s, actual %s", "token", emptyConn.Token)
+	}
+}
+
+func TestConn_Decode_Error(t *testing.T) {
+	emptyConn := NewConnWithoutParam()
+	// Token 长度为 5，实际只有 2 个字节
+	err := emptyConn.Decode(append([]byte("12345678"), 0x0, 0x5, 't', 'o'))
+	if err == nil {
+		t.Errorf("want packetBody too short, got nil")
+	}
+}
+
+func TestConnAck_EncodeDecode(t *testing.T) {
+	packet1, err := Encode(NewConnAck("12345678", ResultAuthFailed))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	expected := append([]byte{CommandConnAck}, append([]byte("12345678"), ResultAuthFailed)...)
+	if !bytes.Equal(packet1, expected) {
+		t.Errorf("want %x, actual %x", expected, packet1)
+		return
+	}
+	decode, err := Decode(packet1)
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	connAck, ok := decode.(*ConnAck)
+	if !ok {
+		t.Errorf("want *ConnAck, actual %x", decode)
+		return
+	}
+	if connAck.ID != "12345678" || connAck.Result != ResultAuthFailed {
+		t.Errorf("want %s/%d, actual %s/%d", "12345678", ResultAuthFailed, connAck.ID, connAck.Result)
+	}
+
+	_, err = Encode(NewConnAck("12345678", 0xFF))
+	if err == nil {
+		t.Errorf("want unknown result error, got nil")
+	}
+}
+
+func TestConnAck_Nonce(t *testing.T) {
+	nonce := bytes.Repeat([]byte{0xAB}, NonceLen)
+	encode, err := NewConnAck("12345678", ResultOK).Encode()
+	if err != nil || len(encode) != 9 {
+		t.Errorf("want 9 bytes without nonce, actual %x %v", encode, err)
+	}
+
+	connAck := &ConnAck{ID: "12345678", Result: ResultOK, Nonce: nonce}
+	encode, err = connAck.Encode()
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	decoded := NewConnAckWithoutParam()
+	if err = decoded.Decode(encode); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if !bytes.Equal(decoded.Nonce, nonce) {
+		t.Errorf("want %x, actual %x", nonce, decoded.Nonce)
+	}
+
+	// nonce 长度不对
+	connAck.Nonce = []byte{1, 2, 3}
+	if _, err = connAck.Encode(); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	if err = decoded.Decode(append([]byte("12345678"), 0, 1, 2, 3)); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
+
+func TestConnAck_Window(t *testing.T) {
+	// 只带窗口时，Nonce 用全 0 占位，解码后还原为 nil
+	connAck := &ConnAck{ID: "12345678", Result: ResultOK, Window: 16}
+	encode, err := connAck.Encode()
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if len(encode) != 9+NonceLen+4 {
+		t.Errorf("want %d bytes, actual %d", 9+NonceLen+4, len(encode))
+	}
+	decoded := NewConnAckWithoutParam()
+	if err = decoded.Decode(encode); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if decoded.Window != 16 || decoded.Nonce != nil {
+		t.Errorf("want window 16 and nil nonce, actual %d %x", decoded.Window, decoded.Nonce)
+	}
+
+	nonce := bytes.Repeat([]byte{0xAB}, NonceLen)
+	connAck.Nonce = nonce
+	encode, _ = connAck.Encode()
+	if err = decoded.Decode(encode); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if decoded.Window != 16 || !bytes.Equal(decoded.Nonce, nonce) {
+		t.Errorf("want window 16 and nonce %x, actual %d %x", nonce, decoded.Window, decoded.Nonce)
+	}
+
+	// 窗口字段被截断
+	if err = decoded.Decode(encode[:len(encode)-1]); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
+
+func TestConn_Versions(t *testing.T) {
+	// 不带 Nonce 时用全 0 占位
+	conn := &Conn{ID: "12345678", Token: []byte("t"), Versions: []uint8{ProtocolVersion1, ProtocolVersion2}, Caps: CapChecksum}
+	encode, err := conn.Encode()
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if len(encode) != 11+NonceLen+3+4 {
+		t.Errorf("want %d bytes, actual %d", 11+NonceLen+3+4, len(encode))
+	}
+	decoded := NewConnWithoutParam()
+	if err = decoded.Decode(encode); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if decoded.Nonce != nil || !bytes.Equal(decoded.Versions, conn.Versions) || decoded.Caps != CapChecksum {
+		t.Errorf("want %v, actual %v", conn, decoded)
+	}
+	if err = decoded.Decode(encode[:len(encode)-5]); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+	if _, err = (&Conn{ID: "12345678", Caps: CapChecksum}).Encode(); err == nil {
+		t.Errorf("want caps require versions, actual nil")
+	}
+}
+
+func TestConnAck_Version(t *testing.T) {
+	// 只带版本时，Nonce 和 Window 都用 0 占位
+	connAck := &ConnAck{ID: "12345678", Result: ResultOK, Version: ProtocolVersion2, Caps: CapCompression | CapBatching}
+	encode, err := connAck.Encode()
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if len(encode) != 9+NonceLen+4+1+4 {
+		t.Errorf("want %d bytes, actual %d", 9+NonceLen+4+1+4, len(encode))
+	}
+	decoded := NewConnAckWithoutParam()
+	if err = decoded.Decode(encode); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if decoded.Nonce != nil || decoded.Window != 0 || decoded.Version != ProtocolVersion2 || decoded.Caps != connAck.Caps {
+		t.Errorf("want %v, actual %v", connAck, decoded)
+	}
+	if _, err = (&ConnAck{ID: "12345678", Caps: CapChecksum}).Encode(); err == nil {
+		t.Errorf("want caps require version, actual nil")
+	}
+}
@@ -0,0 +1,856 @@
+// Code generated by packetgen from protocol.json. DO NOT EDIT.
+
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Subscribe 订阅一个主题，之后服务端把匹配的 Publish 推送给这个连接（客户端无需应答）
+type Subscribe struct {
+	ID    string // 消息流水号（请求和响应的ID保持一致）
+	Topic string // 订阅的主题，可以包含通配符（见 MatchTopic）
+}
+
+func NewSubscribe(ID string, Topic string) *Subscribe {
+	return &Subscribe{ID: ID, Topic: Topic}
+}
+
+// Command 返回 Subscribe 的命令字
+func (p *Subscribe) Command() uint8 {
+	return CommandSubscribe
+}
+
+// Subscribe 的包体格式：ID(8字节) + Topic
+func (p *Subscribe) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 Subscribe 的包体
+func (p *Subscribe) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return errors.New("packetBody too short")
+	}
+	p.Topic = string(body)
+	return nil
+}
+
+func (p *Subscribe) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 Subscribe 的包体
+func (p *Subscribe) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 9)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if len(p.Topic) == 0 {
+		return nil, errors.New("topic is empty")
+	}
+	body = append(body, p.Topic...)
+	return body, nil
+}
+
+type SubscribeAck struct {
+	ID     string
+	Result uint8 // 响应状态（见 ResultXXX 常量）
+}
+
+func NewSubscribeAck(ID string, Result uint8) *SubscribeAck {
+	return &SubscribeAck{ID: ID, Result: Result}
+}
+
+// Command 返回 SubscribeAck 的命令字
+func (p *SubscribeAck) Command() uint8 {
+	return CommandSubscribeAck
+}
+
+// SubscribeAck 的包体格式：ID(8字节) + Result(1字节)
+func (p *SubscribeAck) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 SubscribeAck 的包体
+func (p *SubscribeAck) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	p.Result = body[0]
+	return nil
+}
+
+func (p *SubscribeAck) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 SubscribeAck 的包体
+func (p *SubscribeAck) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 9)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if p.Result > resultMax {
+		return nil, fmt.Errorf("unknown result [%d]", p.Result)
+	}
+	body = append(body, p.Result)
+	return body, nil
+}
+
+// Unsubscribe 取消订阅
+type Unsubscribe struct {
+	ID    string
+	Topic string // 与 Subscribe 时的主题完全一致
+}
+
+func NewUnsubscribe(ID string, Topic string) *Unsubscribe {
+	return &Unsubscribe{ID: ID, Topic: Topic}
+}
+
+// Command 返回 Unsubscribe 的命令字
+func (p *Unsubscribe) Command() uint8 {
+	return CommandUnsubscribe
+}
+
+// Unsubscribe 的包体格式：ID(8字节) + Topic
+func (p *Unsubscribe) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 Unsubscribe 的包体
+func (p *Unsubscribe) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return errors.New("packetBody too short")
+	}
+	p.Topic = string(body)
+	return nil
+}
+
+func (p *Unsubscribe) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 Unsubscribe 的包体
+func (p *Unsubscribe) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 9)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if len(p.Topic) == 0 {
+		return nil, errors.New("topic is empty")
+	}
+	body = append(body, p.Topic...)
+	return body, nil
+}
+
+type UnsubscribeAck struct {
+	ID     string
+	Result uint8 // 响应状态（见 ResultXXX 常量）
+}
+
+func NewUnsubscribeAck(ID string, Result uint8) *UnsubscribeAck {
+	return &UnsubscribeAck{ID: ID, Result: Result}
+}
+
+// Command 返回 UnsubscribeAck 的命令字
+func (p *UnsubscribeAck) Command() uint8 {
+	return CommandUnsubscribeAck
+}
+
+// UnsubscribeAck 的包体格式：ID(8字节) + Result(1字节)
+func (p *UnsubscribeAck) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 UnsubscribeAck 的包体
+func (p *UnsubscribeAck) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	p.Result = body[0]
+	return nil
+}
+
+func (p *UnsubscribeAck) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 UnsubscribeAck 的包体
+func (p *UnsubscribeAck) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 9)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if p.Result > resultMax {
+		return nil, fmt.Errorf("unknown result [%d]", p.Result)
+	}
+	body = append(body, p.Result)
+	return body, nil
+}
+
+// Publish 发布一条消息。服务端向订阅者推送消息时同样使用 Publish 包，客户端无需应答
+type Publish struct {
+	ID      string
+	Topic   string // 发布的主题，不能包含通配符
+	Payload []byte
+}
+
+func NewPublish(ID string, Topic string, Payload []byte) *Publish {
+	return &Publish{ID: ID, Topic: Topic, Payload: Payload}
+}
+
+// Command 返回 Publish 的命令字
+func (p *Publish) Command() uint8 {
+	return CommandPublish
+}
+
+// Publish 的包体格式：ID(8字节) + Topic长度(2字节，大端) + Topic + Payload
+func (p *Publish) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 Publish 的包体
+func (p *Publish) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 2 {
+		return errors.New("packetBody too short")
+	}
+	topicLen := int(binary.BigEndian.Uint16(body))
+	body = body[2:]
+	if topicLen == 0 || len(body) < topicLen {
+		return errors.New("packetBody too short")
+	}
+	p.Topic = string(body[:topicLen])
+	body = body[topicLen:]
+	p.Payload = nil
+	if len(body) > 0 {
+		p.Payload = body
+	}
+	return nil
+}
+
+func (p *Publish) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 Publish 的包体
+func (p *Publish) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 11)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if len(p.Topic) == 0 {
+		return nil, errors.New("topic is empty")
+	}
+	if uint64(len(p.Topic)) > 0xFFFF {
+		return nil, errors.New("topic too long")
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(p.Topic)))
+	body = append(body, p.Topic...)
+	body = append(body, p.Payload...)
+	return body, nil
+}
+
+type PublishAck struct {
+	ID     string
+	Result uint8 // 响应状态（见 ResultXXX 常量）
+}
+
+func NewPublishAck(ID string, Result uint8) *PublishAck {
+	return &PublishAck{ID: ID, Result: Result}
+}
+
+// Command 返回 PublishAck 的命令字
+func (p *PublishAck) Command() uint8 {
+	return CommandPublishAck
+}
+
+// PublishAck 的包体格式：ID(8字节) + Result(1字节)
+func (p *PublishAck) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 PublishAck 的包体
+func (p *PublishAck) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	p.Result = body[0]
+	return nil
+}
+
+func (p *PublishAck) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 PublishAck 的包体
+func (p *PublishAck) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 9)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if p.Result > resultMax {
+		return nil, fmt.Errorf("unknown result [%d]", p.Result)
+	}
+	body = append(body, p.Result)
+	return body, nil
+}
+
+// TransferBegin 开始（或续传）一次分块传输，见 transfer.go
+type TransferBegin struct {
+	ID         string
+	TransferID string // 传输 ID（见 ValidateTransferID）
+}
+
+func NewTransferBegin(ID string, TransferID string) *TransferBegin {
+	return &TransferBegin{ID: ID, TransferID: TransferID}
+}
+
+// Command 返回 TransferBegin 的命令字
+func (p *TransferBegin) Command() uint8 {
+	return CommandTransferBegin
+}
+
+// TransferBegin 的包体格式：ID(8字节) + TransferID长度(1字节) + TransferID
+func (p *TransferBegin) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 TransferBegin 的包体
+func (p *TransferBegin) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	transferIDLen := int(body[0])
+	body = body[1:]
+	if len(body) < transferIDLen {
+		return errors.New("packetBody too short")
+	}
+	p.TransferID = string(body[:transferIDLen])
+	return nil
+}
+
+func (p *TransferBegin) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 TransferBegin 的包体
+func (p *TransferBegin) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 9)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if err := ValidateTransferID(p.TransferID); err != nil {
+		return nil, err
+	}
+	if uint64(len(p.TransferID)) > 0xFF {
+		return nil, errors.New("transferID too long")
+	}
+	body = append(body, uint8(len(p.TransferID)))
+	body = append(body, p.TransferID...)
+	return body, nil
+}
+
+type TransferBeginAck struct {
+	ID     string
+	Result uint8  // 响应状态（见 ResultXXX 常量）
+	Offset uint64 // 服务端已经收到的字节数，客户端从这里继续发送
+}
+
+func NewTransferBeginAck(ID string, Result uint8, Offset uint64) *TransferBeginAck {
+	return &TransferBeginAck{ID: ID, Result: Result, Offset: Offset}
+}
+
+// Command 返回 TransferBeginAck 的命令字
+func (p *TransferBeginAck) Command() uint8 {
+	return CommandTransferBeginAck
+}
+
+// TransferBeginAck 的包体格式：ID(8字节) + Result(1字节) + Offset(8字节，大端)
+func (p *TransferBeginAck) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 TransferBeginAck 的包体
+func (p *TransferBeginAck) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	p.Result = body[0]
+	body = body[1:]
+	if len(body) < 8 {
+		return errors.New("packetBody too short")
+	}
+	p.Offset = binary.BigEndian.Uint64(body)
+	return nil
+}
+
+func (p *TransferBeginAck) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 TransferBeginAck 的包体
+func (p *TransferBeginAck) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 17)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if p.Result > resultMax {
+		return nil, fmt.Errorf("unknown result [%d]", p.Result)
+	}
+	body = append(body, p.Result)
+	body = binary.BigEndian.AppendUint64(body, p.Offset)
+	return body, nil
+}
+
+// TransferChunk 携带一块传输数据
+type TransferChunk struct {
+	ID         string
+	TransferID string
+	Offset     uint64 // Data 在整个传输内容中的偏移量
+	Data       []byte
+}
+
+func NewTransferChunk(ID string, TransferID string, Offset uint64, Data []byte) *TransferChunk {
+	return &TransferChunk{ID: ID, TransferID: TransferID, Offset: Offset, Data: Data}
+}
+
+// Command 返回 TransferChunk 的命令字
+func (p *TransferChunk) Command() uint8 {
+	return CommandTransferChunk
+}
+
+// TransferChunk 的包体格式：ID(8字节) + TransferID长度(1字节) + TransferID + Offset(8字节，大端) + Data
+func (p *TransferChunk) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 TransferChunk 的包体
+func (p *TransferChunk) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	transferIDLen := int(body[0])
+	body = body[1:]
+	if len(body) < transferIDLen {
+		return errors.New("packetBody too short")
+	}
+	p.TransferID = string(body[:transferIDLen])
+	body = body[transferIDLen:]
+	if len(body) < 8 {
+		return errors.New("packetBody too short")
+	}
+	p.Offset = binary.BigEndian.Uint64(body)
+	body = body[8:]
+	p.Data = nil
+	if len(body) > 0 {
+		p.Data = body
+	}
+	return nil
+}
+
+func (p *TransferChunk) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 TransferChunk 的包体
+func (p *TransferChunk) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 17)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if err := ValidateTransferID(p.TransferID); err != nil {
+		return nil, err
+	}
+	if uint64(len(p.TransferID)) > 0xFF {
+		return nil, errors.New("transferID too long")
+	}
+	body = append(body, uint8(len(p.TransferID)))
+	body = append(body, p.TransferID...)
+	body = binary.BigEndian.AppendUint64(body, p.Offset)
+	body = append(body, p.Data...)
+	return body, nil
+}
+
+type TransferChunkAck struct {
+	ID     string
+	Result uint8  // 响应状态（见 ResultXXX 常量）
+	Offset uint64 // 服务端期望的下一个偏移量
+}
+
+func NewTransferChunkAck(ID string, Result uint8, Offset uint64) *TransferChunkAck {
+	return &TransferChunkAck{ID: ID, Result: Result, Offset: Offset}
+}
+
+// Command 返回 TransferChunkAck 的命令字
+func (p *TransferChunkAck) Command() uint8 {
+	return CommandTransferChunkAck
+}
+
+// TransferChunkAck 的包体格式：ID(8字节) + Result(1字节) + Offset(8字节，大端)
+func (p *TransferChunkAck) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 TransferChunkAck 的包体
+func (p *TransferChunkAck) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	p.Result = body[0]
+	body = body[1:]
+	if len(body) < 8 {
+		return errors.New("packetBody too short")
+	}
+	p.Offset = binary.BigEndian.Uint64(body)
+	return nil
+}
+
+func (p *TransferChunkAck) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 TransferChunkAck 的包体
+func (p *TransferChunkAck) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 17)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if p.Result > resultMax {
+		return nil, fmt.Errorf("unknown result [%d]", p.Result)
+	}
+	body = append(body, p.Result)
+	body = binary.BigEndian.AppendUint64(body, p.Offset)
+	return body, nil
+}
+
+// TransferEnd 结束一次传输，服务端校验长度和摘要通过后才算传输完成
+type TransferEnd struct {
+	ID         string
+	TransferID string
+	Size       uint64   // 传输内容的总长度
+	Digest     [32]byte // 传输内容的 SHA-256 摘要（DigestLen 字节）
+}
+
+func NewTransferEnd(ID string, TransferID string, Size uint64, Digest [32]byte) *TransferEnd {
+	return &TransferEnd{ID: ID, TransferID: TransferID, Size: Size, Digest: Digest}
+}
+
+// Command 返回 TransferEnd 的命令字
+func (p *TransferEnd) Command() uint8 {
+	return CommandTransferEnd
+}
+
+// TransferEnd 的包体格式：ID(8字节) + TransferID长度(1字节) + TransferID + Size(8字节，大端) + Digest(32字节)
+func (p *TransferEnd) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 TransferEnd 的包体
+func (p *TransferEnd) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	transferIDLen := int(body[0])
+	body = body[1:]
+	if len(body) < transferIDLen {
+		return errors.New("packetBody too short")
+	}
+	p.TransferID = string(body[:transferIDLen])
+	body = body[transferIDLen:]
+	if len(body) < 8 {
+		return errors.New("packetBody too short")
+	}
+	p.Size = binary.BigEndian.Uint64(body)
+	body = body[8:]
+	if len(body) < 32 {
+		return errors.New("packetBody too short")
+	}
+	copy(p.Digest[:], body[:32])
+	return nil
+}
+
+func (p *TransferEnd) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 TransferEnd 的包体
+func (p *TransferEnd) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 49)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if err := ValidateTransferID(p.TransferID); err != nil {
+		return nil, err
+	}
+	if uint64(len(p.TransferID)) > 0xFF {
+		return nil, errors.New("transferID too long")
+	}
+	body = append(body, uint8(len(p.TransferID)))
+	body = append(body, p.TransferID...)
+	body = binary.BigEndian.AppendUint64(body, p.Size)
+	body = append(body, p.Digest[:]...)
+	return body, nil
+}
+
+type TransferEndAck struct {
+	ID     string
+	Result uint8 // 响应状态（见 ResultXXX 常量）
+}
+
+func NewTransferEndAck(ID string, Result uint8) *TransferEndAck {
+	return &TransferEndAck{ID: ID, Result: Result}
+}
+
+// Command 返回 TransferEndAck 的命令字
+func (p *TransferEndAck) Command() uint8 {
+	return CommandTransferEndAck
+}
+
+// TransferEndAck 的包体格式：ID(8字节) + Result(1字节)
+func (p *TransferEndAck) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 TransferEndAck 的包体
+func (p *TransferEndAck) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	if len(body) < 1 {
+		return errors.New("packetBody too short")
+	}
+	p.Result = body[0]
+	return nil
+}
+
+func (p *TransferEndAck) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 TransferEndAck 的包体
+func (p *TransferEndAck) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 9)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	if p.Result > resultMax {
+		return nil, fmt.Errorf("unknown result [%d]", p.Result)
+	}
+	body = append(body, p.Result)
+	return body, nil
+}
+
+// WindowUpdate 由服务端推送，把客户端允许同时等待应答的 Submit 数量调整为 Window。
+// Window 是绝对值而不是增量，服务端既可以放大也可以缩小窗口，0 表示不再限制
+type WindowUpdate struct {
+	Window uint32 // 允许同时等待应答的 Submit 数量
+}
+
+func NewWindowUpdate(Window uint32) *WindowUpdate {
+	return &WindowUpdate{Window: Window}
+}
+
+// Command 返回 WindowUpdate 的命令字
+func (p *WindowUpdate) Command() uint8 {
+	return CommandWindowUpdate
+}
+
+// WindowUpdate 的包体格式：Window(4字节，大端)
+func (p *WindowUpdate) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 WindowUpdate 的包体
+func (p *WindowUpdate) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	if len(body) < 4 {
+		return errors.New("packetBody too short")
+	}
+	p.Window = binary.BigEndian.Uint32(body)
+	return nil
+}
+
+func (p *WindowUpdate) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 WindowUpdate 的包体
+func (p *WindowUpdate) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 4)
+	body = binary.BigEndian.AppendUint32(body, p.Window)
+	return body, nil
+}
+
+// Cancel 由客户端发送，通知服务端不再需要 ID 对应的 Submit（或 SubmitBatch 整个批次），服务端不回应答，
+// 被取消的 Submit 仍然会收到 SubmitAck
+type Cancel struct {
+	ID string // 要取消的 Submit 或 SubmitBatch 的 ID
+}
+
+func NewCancel(ID string) *Cancel {
+	return &Cancel{ID: ID}
+}
+
+// Command 返回 Cancel 的命令字
+func (p *Cancel) Command() uint8 {
+	return CommandCancel
+}
+
+// Cancel 的包体格式：ID(8字节)
+func (p *Cancel) Decode(packetBody []byte) error {
+	return p.DecodeWith(packetBody, Options{})
+}
+
+// DecodeWith 按 opts 解码 Cancel 的包体
+func (p *Cancel) DecodeWith(packetBody []byte, opts Options) error {
+	if packetBody == nil {
+		return errors.New("packetBody is nil")
+	}
+	body := packetBody
+	var err error
+	if p.ID, body, err = ReadID(body, opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *Cancel) Encode() ([]byte, error) {
+	return p.EncodeWith(Options{})
+}
+
+// EncodeWith 按 opts 编码 Cancel 的包体
+func (p *Cancel) EncodeWith(opts Options) ([]byte, error) {
+	body := make([]byte, 0, 8)
+	var err error
+	if body, err = AppendID(body, p.ID, opts); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func init() {
+	Register(CommandSubscribe, func() Packet { return &Subscribe{} })
+	Register(CommandSubscribeAck, func() Packet { return &SubscribeAck{} })
+	Register(CommandUnsubscribe, func() Packet { return &Unsubscribe{} })
+	Register(CommandUnsubscribeAck, func() Packet { return &UnsubscribeAck{} })
+	Register(CommandPublish, func() Packet { return &Publish{} })
+	Register(CommandPublishAck, func() Packet { return &PublishAck{} })
+	Register(CommandTransferBegin, func() Packet { return &TransferBegin{} })
+	Register(CommandTransferBeginAck, func() Packet { return &TransferBeginAck{} })
+	Register(CommandTransferChunk, func() Packet { return &TransferChunk{} })
+	Register(CommandTransferChunkAck, func() Packet { return &TransferChunkAck{} })
+	Register(CommandTransferEnd, func() Packet { return &TransferEnd{} })
+	Register(CommandTransferEndAck, func() Packet { return &TransferEndAck{} })
+	Register(CommandWindowUpdate, func() Packet { return &WindowUpdate{} })
+	Register(CommandCancel, func() Packet { return &Cancel{} })
+}
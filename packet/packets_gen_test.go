@@ -0,0 +1,67 @@
+// Code generated by packetgen from protocol.json. DO NOT EDIT.
+
+package packet
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGeneratedPackets(t *testing.T) {
+	tests := []struct {
+		name    string
+		command uint8
+		want    uint8
+		packet  Packet
+		minLen  int
+	}{
+		{"Subscribe", CommandSubscribe, 0x03, &Subscribe{ID: "12345678", Topic: "hello"}, 9},
+		{"SubscribeAck", CommandSubscribeAck, 0x82, &SubscribeAck{ID: "12345678", Result: 2}, 9},
+		{"Unsubscribe", CommandUnsubscribe, 0x04, &Unsubscribe{ID: "12345678", Topic: "hello"}, 9},
+		{"UnsubscribeAck", CommandUnsubscribeAck, 0x83, &UnsubscribeAck{ID: "12345678", Result: 2}, 9},
+		{"Publish", CommandPublish, 0x05, &Publish{ID: "12345678", Topic: "hello", Payload: []byte("hello")}, 11},
+		{"PublishAck", CommandPublishAck, 0x84, &PublishAck{ID: "12345678", Result: 2}, 9},
+		{"TransferBegin", CommandTransferBegin, 0x06, &TransferBegin{ID: "12345678", TransferID: "hello"}, 9},
+		{"TransferBeginAck", CommandTransferBeginAck, 0x85, &TransferBeginAck{ID: "12345678", Result: 2, Offset: 3}, 17},
+		{"TransferChunk", CommandTransferChunk, 0x07, &TransferChunk{ID: "12345678", TransferID: "hello", Offset: 3, Data: []byte("hello")}, 17},
+		{"TransferChunkAck", CommandTransferChunkAck, 0x86, &TransferChunkAck{ID: "12345678", Result: 2, Offset: 3}, 17},
+		{"TransferEnd", CommandTransferEnd, 0x08, &TransferEnd{ID: "12345678", TransferID: "hello", Size: 3, Digest: [32]byte{1, 2, 3}}, 49},
+		{"TransferEndAck", CommandTransferEndAck, 0x87, &TransferEndAck{ID: "12345678", Result: 2}, 9},
+		{"WindowUpdate", CommandWindowUpdate, 0x09, &WindowUpdate{Window: 1}, 4},
+		{"Cancel", CommandCancel, 0x0C, &Cancel{ID: "12345678"}, 8},
+	}
+	for _, tt := range tests {
+		if tt.command != tt.want {
+			t.Errorf("%s: want command 0x%02X, actual 0x%02X", tt.name, tt.want, tt.command)
+		}
+		encode, err := Encode(tt.packet)
+		if err != nil {
+			t.Errorf("%s: want nil, actual %s", tt.name, err.Error())
+			continue
+		}
+		if encode[0] != tt.want {
+			t.Errorf("%s: want 0x%02X, actual 0x%02X", tt.name, tt.want, encode[0])
+		}
+		decode, err := Decode(encode)
+		if err != nil {
+			t.Errorf("%s: want nil, actual %s", tt.name, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(decode, tt.packet) {
+			t.Errorf("%s: want %v, actual %v", tt.name, tt.packet, decode)
+		}
+		for i := 0; i < tt.minLen; i++ {
+			if _, err := Decode(encode[:1+i]); err == nil {
+				t.Errorf("%s: %d bytes: want packetBody too short, actual nil", tt.name, i)
+			}
+		}
+		opts := Options{LongIDs: true}
+		if encode, err = EncodeWith(tt.packet, opts); err != nil {
+			t.Errorf("%s: long IDs: want nil, actual %s", tt.name, err.Error())
+			continue
+		}
+		if decode, err = DecodeWith(encode, opts); err != nil || !reflect.DeepEqual(decode, tt.packet) {
+			t.Errorf("%s: long IDs: want %v, actual %v (%v)", tt.name, tt.packet, decode, err)
+		}
+	}
+}
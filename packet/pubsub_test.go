@@ -0,0 +1,89 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPublish_EncodeDecode(t *testing.T) {
+	encode, err := Encode(NewPublish("12345678", "a/b", []byte("hello")))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	expected := append([]byte{CommandPublish}, append([]byte("12345678"), 0x0, 0x3, 'a', '/', 'b', 'h', 'e', 'l', 'l', 'o')...)
+	if !bytes.Equal(encode, expected) {
+		t.Errorf("want %x, actual %x", expected, encode)
+		return
+	}
+	decode, err := Decode(encode)
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	publish, ok := decode.(*Publish)
+	if !ok || publish.ID != "12345678" || publish.Topic != "a/b" || string(publish.Payload) != "hello" {
+		t.Errorf("want publish[12345678] a/b hello, actual %v", decode)
+	}
+
+	// Topic 长度超出包体
+	if _, err = Decode(append([]byte{CommandPublish}, append([]byte("12345678"), 0x0, 0x9, 'a')...)); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+	if _, err = Encode(NewPublish("12345678", "", nil)); err == nil {
+		t.Errorf("want error for empty topic, actual nil")
+	}
+}
+
+func TestSubscribe_EncodeDecode(t *testing.T) {
+	for _, p := range []Packet{NewSubscribe("12345678", "a/+"), NewUnsubscribe("12345678", "a/#")} {
+		encode, err := Encode(p)
+		if err != nil {
+			t.Errorf("want nil, actual %s", err.Error())
+			return
+		}
+		decode, err := Decode(encode)
+		if err != nil {
+			t.Errorf("want nil, actual %s", err.Error())
+			return
+		}
+		switch d := decode.(type) {
+		case *Subscribe:
+			if d.ID != "12345678" || d.Topic != "a/+" {
+				t.Errorf("want subscribe[12345678] a/+, actual %v", d)
+			}
+		case *Unsubscribe:
+			if d.ID != "12345678" || d.Topic != "a/#" {
+				t.Errorf("want unsubscribe[12345678] a/#, actual %v", d)
+			}
+		default:
+			t.Errorf("unexpected packet %T", decode)
+		}
+	}
+
+	if _, err := Decode(append([]byte{CommandSubscribe}, []byte("12345678")...)); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
+
+func TestAcks_EncodeDecode(t *testing.T) {
+	acks := []Packet{NewSubscribeAck("12345678", ResultOK), NewUnsubscribeAck("12345678", ResultError), NewPublishAck("12345678", ResultAuthFailed)}
+	for _, p := range acks {
+		encode, err := Encode(p)
+		if err != nil {
+			t.Errorf("want nil, actual %s", err.Error())
+			return
+		}
+		decode, err := Decode(encode)
+		if err != nil {
+			t.Errorf("want nil, actual %s", err.Error())
+			return
+		}
+		if encode2, _ := Encode(decode); !bytes.Equal(encode, encode2) {
+			t.Errorf("want %x, actual %x", encode, encode2)
+		}
+	}
+	if _, err := Encode(NewPublishAck("12345678", 0xFF)); err == nil {
+		t.Errorf("want unknown result error, actual nil")
+	}
+}
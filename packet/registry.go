@@ -0,0 +1,28 @@
+package packet
+
+import "fmt"
+
+//go:generate go run ../cmd/packetgen -schema protocol.json -go packets_gen.go -test packets_gen_test.go -doc PROTOCOL.md
+
+/* 除了 Encode/Decode 中手写的包，其他包类型（由 cmd/packetgen 根据 protocol.json 生成）在 init 中调用 Register 登记命令字。
+修改 protocol.json 后执行 go generate ./packet 重新生成，不要手动修改生成的文件。
+仍然手写的只有字段列表描述不了的几种：Conn/ConnAck（可选字段之间的占位和依赖）、
+Submit/SubmitAck（Submit 按是否带截止时间使用两个命令字）和 SubmitBatch/SubmitBatchAck（逐条编码的子结构）
+*/
+
+// CommandPacket 是登记过命令字的包，Encode 通过 Command 得到命令字
+type CommandPacket interface {
+	Packet
+	Command() uint8
+}
+
+var registry = make(map[uint8]func() Packet)
+
+// Register 登记命令字对应的包类型，Decode 遇到该命令字时用 newPacket 创建包再解码。
+// 只能在 init 中调用，同一个命令字重复登记会 panic
+func Register(commandID uint8, newPacket func() Packet) {
+	if _, ok := registry[commandID]; ok {
+		panic(fmt.Sprintf("packet: commandID [%d] registered twice", commandID))
+	}
+	registry[commandID] = newPacket
+}
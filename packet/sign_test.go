@@ -0,0 +1,57 @@
+package packet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSignSubmit(t *testing.T) {
+	key := DeriveSessionKey([]byte("secret"), "00000001", bytes.Repeat([]byte{1}, NonceLen), bytes.Repeat([]byte{2}, NonceLen))
+	submit := NewSubmit("12345678", []byte("hello world"))
+	now := time.Now()
+	signed := SignSubmit(key, submit, 7, now)
+	if len(signed.Payload) != len(submit.Payload)+SignatureTrailerLen {
+		t.Errorf("want %d, actual %d", len(submit.Payload)+SignatureTrailerLen, len(signed.Payload))
+	}
+	if string(submit.Payload) != "hello world" {
+		t.Errorf("want original payload untouched, actual %s", submit.Payload)
+	}
+
+	verified, seq, ts, err := VerifySubmit(key, signed)
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if string(verified.Payload) != "hello world" || seq != 7 || !ts.Equal(time.Unix(0, now.UnixNano())) {
+		t.Errorf("want hello world/7/%v, actual %s/%d/%v", now, verified.Payload, seq, ts)
+	}
+}
+
+func TestVerifySubmit_Error(t *testing.T) {
+	key := []byte("key")
+	signed := SignSubmit(key, NewSubmit("12345678", []byte("hello world")), 1, time.Now())
+
+	// case 1: 密钥不一致
+	if _, _, _, err := VerifySubmit([]byte("other"), signed); err != ErrBadSignature {
+		t.Errorf("want ErrBadSignature, actual %v", err)
+	}
+
+	// case 2: 篡改 ID
+	tampered := NewSubmit("87654321", signed.Payload)
+	if _, _, _, err := VerifySubmit(key, tampered); err != ErrBadSignature {
+		t.Errorf("want ErrBadSignature, actual %v", err)
+	}
+
+	// case 3: 篡改 payload
+	payload := append([]byte{}, signed.Payload...)
+	payload[0] = 'H'
+	if _, _, _, err := VerifySubmit(key, NewSubmit("12345678", payload)); err != ErrBadSignature {
+		t.Errorf("want ErrBadSignature, actual %v", err)
+	}
+
+	// case 4: 没有 trailer
+	if _, _, _, err := VerifySubmit(key, NewSubmit("12345678", []byte("short"))); err != ErrBadSignature {
+		t.Errorf("want ErrBadSignature, actual %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package packet
+
+import (
+	"errors"
+	"strings"
+)
+
+// 主题按 "/" 分层，如 "sensor/room1/temp"。订阅时可以使用两种通配符：
+// "+" 匹配恰好一层，如 "sensor/+/temp" 匹配 "sensor/room1/temp"；
+// "#" 匹配零层或多层，只能出现在最后一层，如 "sensor/#" 匹配 "sensor" 和 "sensor/room1/temp"
+
+// ValidateTopic 校验发布用的主题：非空，且不能包含通配符
+func ValidateTopic(topic string) error {
+	if topic == "" {
+		return errors.New("topic is empty")
+	}
+	if strings.ContainsAny(topic, "+#") {
+		return errors.New("topic must not contain wildcards")
+	}
+	return nil
+}
+
+// ValidateTopicFilter 校验订阅用的主题：通配符必须独占一层，# 只能在最后一层
+func ValidateTopicFilter(filter string) error {
+	if filter == "" {
+		return errors.New("topic is empty")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.ContainsAny(level, "+#") && len(level) != 1 {
+			return errors.New("wildcard must occupy an entire topic level")
+		}
+		if level == "#" && i != len(levels)-1 {
+			return errors.New("# must be the last topic level")
+		}
+	}
+	return nil
+}
+
+// MatchTopic 判断主题 topic 是否匹配订阅的 filter
+func MatchTopic(filter string, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -0,0 +1,42 @@
+package packet
+
+import "testing"
+
+func TestMatchTopic(t *testing.T) {
+	cases := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+/c", "a/b/c", true},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"a/b/c", "a/b", false},
+	}
+	for _, c := range cases {
+		if got := MatchTopic(c.filter, c.topic); got != c.want {
+			t.Errorf("MatchTopic(%s, %s): want %v, actual %v", c.filter, c.topic, c.want, got)
+		}
+	}
+}
+
+func TestValidateTopicFilter(t *testing.T) {
+	for _, filter := range []string{"a", "a/+/c", "a/#", "#", "+"} {
+		if err := ValidateTopicFilter(filter); err != nil {
+			t.Errorf("%s: want nil, actual %s", filter, err.Error())
+		}
+	}
+	for _, filter := range []string{"", "a/#/c", "a/b+", "a#"} {
+		if err := ValidateTopicFilter(filter); err == nil {
+			t.Errorf("%s: want error, actual nil", filter)
+		}
+	}
+	if err := ValidateTopic("a/+"); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
@@ -0,0 +1,33 @@
+package packet
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+/* 分块传输相关的包（定义见 protocol.json），用于传输单个帧放不下的大数据，并支持断线后从已确认的位置续传。
+一次传输由 TransferID 标识：
+	TransferBegin  -> TransferBeginAck  服务端返回已经收到的字节数（续传的起点）
+	TransferChunk  -> TransferChunkAck  每块数据都带有偏移量，服务端确认后返回下一个期望的偏移量
+	TransferEnd    -> TransferEndAck    携带总长度和 SHA-256 摘要，服务端校验通过后才算传输完成
+*/
+
+const (
+	MaxTransferIDLen = 64
+	DigestLen        = sha256.Size
+)
+
+// ValidateTransferID 校验传输 ID：1~64 个字母、数字、'-' 或 '_'。
+// 服务端会用它作为文件名，因此不允许出现路径分隔符
+func ValidateTransferID(id string) error {
+	if len(id) == 0 || len(id) > MaxTransferIDLen {
+		return fmt.Errorf("transfer id length must be between 1 and %d", MaxTransferIDLen)
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return errors.New("transfer id contains invalid character")
+		}
+	}
+	return nil
+}
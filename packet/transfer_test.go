@@ -0,0 +1,64 @@
+package packet
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestTransfer_EncodeDecode(t *testing.T) {
+	end := &TransferEnd{ID: "12345678", TransferID: "file-1", Size: 11, Digest: sha256.Sum256([]byte("hello world"))}
+	packets := []Packet{
+		&TransferBegin{ID: "12345678", TransferID: "file-1"},
+		&TransferBeginAck{ID: "12345678", Result: ResultOK, Offset: 1 << 40},
+		&TransferChunk{ID: "12345678", TransferID: "file-1", Offset: 1 << 33, Data: []byte("hello")},
+		&TransferChunkAck{ID: "12345678", Result: ResultBadOffset, Offset: 5},
+		end,
+		&TransferEndAck{ID: "12345678", Result: ResultDigestMismatch},
+	}
+	for _, p := range packets {
+		encode, err := Encode(p)
+		if err != nil {
+			t.Errorf("%T: want nil, actual %s", p, err.Error())
+			continue
+		}
+		decode, err := Decode(encode)
+		if err != nil {
+			t.Errorf("%T: want nil, actual %s", p, err.Error())
+			continue
+		}
+		if encode2, _ := Encode(decode); !bytes.Equal(encode, encode2) {
+			t.Errorf("%T: want %x, actual %x", p, encode, encode2)
+		}
+	}
+
+	decode, _ := Decode(mustEncode(t, end))
+	if d := decode.(*TransferEnd); d.TransferID != "file-1" || d.Size != 11 || d.Digest != end.Digest {
+		t.Errorf("want %v, actual %v", end, d)
+	}
+}
+
+func TestTransfer_Error(t *testing.T) {
+	// TransferID 不能包含路径分隔符
+	if _, err := Encode(&TransferBegin{ID: "12345678", TransferID: "../etc/passwd"}); err == nil {
+		t.Errorf("want invalid transfer id error, actual nil")
+	}
+	// Chunk 缺少 Offset
+	body := append([]byte{CommandTransferChunk}, append([]byte("12345678"), 0x1, 'a', 0x0)...)
+	if _, err := Decode(body); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+	// End 缺少摘要
+	body = append([]byte{CommandTransferEnd}, append([]byte("12345678"), 0x1, 'a', 0, 0, 0, 0, 0, 0, 0, 1)...)
+	if _, err := Decode(body); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
+
+func mustEncode(t *testing.T, p Packet) []byte {
+	encode, err := Encode(p)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	return encode
+}
@@ -0,0 +1,48 @@
+package packet
+
+/* 协议版本和能力协商。
+客户端在 Conn 中携带自己支持的所有版本和希望开启的能力，服务端选择双方都支持的最高版本，
+并在 ConnAck 中回复选中的版本和最终开启的能力（客户端请求的能力与服务端支持的能力的交集）。
+ConnAck 之后的所有帧都按协商结果编解码，不携带版本的 Conn 按 ProtocolVersion1 处理
+*/
+
+const (
+	ProtocolVersion1 = 1 // 最初的协议，不支持能力协商
+	ProtocolVersion2 = 2 // 支持能力协商
+)
+
+// SupportedVersions 为当前实现支持的协议版本
+var SupportedVersions = []uint8{ProtocolVersion1, ProtocolVersion2}
+
+// 能力位，ProtocolVersion2 及以上版本才会协商
+const (
+	CapCompression   = 1 << iota // 帧负载使用 DEFLATE 压缩（较短的帧不压缩）
+	CapChecksum                  // 帧负载末尾追加 CRC-32C 校验和
+	CapBatching                  // 允许发送 SubmitBatch
+	CapSessionResume             // Conn.ID 作为客户端会话 ID，服务端按身份和会话 ID 保留去重缓存，断线重连后仍然有效
+	CapLongIDs                   // ID 使用 1 字节长度前缀的变长编码（见 EncodeWith），不再固定 8 字节
+)
+
+// SupportedCaps 为当前实现支持的所有能力
+const SupportedCaps = CapCompression | CapChecksum | CapBatching | CapSessionResume | CapLongIDs
+
+// NegotiateVersion 返回 client 和 server 都支持的最高版本，没有交集时返回 false
+func NegotiateVersion(client, server []uint8) (uint8, bool) {
+	var best uint8
+	for _, c := range client {
+		for _, s := range server {
+			if c == s && c > best {
+				best = c
+			}
+		}
+	}
+	return best, best > 0
+}
+
+// NegotiateCaps 返回 version 下双方都支持的能力
+func NegotiateCaps(version uint8, client, server uint32) uint32 {
+	if version < ProtocolVersion2 {
+		return 0
+	}
+	return client & server & SupportedCaps
+}
@@ -0,0 +1,34 @@
+package packet
+
+import (
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		client []uint8
+		want   uint8
+		ok     bool
+	}{
+		{[]uint8{ProtocolVersion1}, ProtocolVersion1, true},
+		{[]uint8{ProtocolVersion2, ProtocolVersion1}, ProtocolVersion2, true},
+		{[]uint8{ProtocolVersion1, ProtocolVersion2, 9}, ProtocolVersion2, true},
+		{[]uint8{9}, 0, false},
+		{[]uint8{}, 0, false},
+	}
+	for _, tt := range tests {
+		version, ok := NegotiateVersion(tt.client, SupportedVersions)
+		if version != tt.want || ok != tt.ok {
+			t.Errorf("%v: want %d %v, actual %d %v", tt.client, tt.want, tt.ok, version, ok)
+		}
+	}
+}
+
+func TestNegotiateCaps(t *testing.T) {
+	if caps := NegotiateCaps(ProtocolVersion1, SupportedCaps, SupportedCaps); caps != 0 {
+		t.Errorf("want 0, actual %d", caps)
+	}
+	if caps := NegotiateCaps(ProtocolVersion2, CapCompression|CapChecksum|1<<31, ^uint32(CapChecksum)); caps != CapCompression {
+		t.Errorf("want %d, actual %d", CapCompression, caps)
+	}
+}
@@ -0,0 +1,33 @@
+package packet
+
+import (
+	"testing"
+)
+
+func TestWindowUpdate(t *testing.T) {
+	framePayload, err := Encode(NewWindowUpdate(1024))
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	if framePayload[0] != CommandWindowUpdate {
+		t.Errorf("want %d, actual %d", CommandWindowUpdate, framePayload[0])
+	}
+	p, err := Decode(framePayload)
+	if err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+		return
+	}
+	windowUpdate, ok := p.(*WindowUpdate)
+	if !ok {
+		t.Errorf("want *WindowUpdate, actual %T", p)
+		return
+	}
+	if windowUpdate.Window != 1024 {
+		t.Errorf("want 1024, actual %d", windowUpdate.Window)
+	}
+
+	if _, err = Decode([]byte{CommandWindowUpdate, 0, 1}); err == nil {
+		t.Errorf("want packetBody too short, actual nil")
+	}
+}
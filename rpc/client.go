@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"37_tcp-server-demo1/client"
+	"37_tcp-server-demo1/packet"
+	"context"
+	"fmt"
+)
+
+// Client 通过 client.Client 发起调用，请求和返回值用 codec 编解码
+type Client struct {
+	c     *client.Client
+	codec Codec
+}
+
+// NewClient 的 codec 为 nil 时使用 JSON
+func NewClient(c *client.Client, codec Codec) *Client {
+	if codec == nil {
+		codec = JSON
+	}
+	return &Client{c: c, codec: codec}
+}
+
+// Call 调用服务端的方法 method。服务端返回的错误为 *Error，
+// 网络错误、超时以及 ctx 被取消时返回 client 包的错误或 ctx.Err()
+func Call[Req, Resp any](ctx context.Context, c *Client, method string, req *Req) (*Resp, error) {
+	arg, err := c.codec.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := encodeRequest(method, c.codec.Name(), arg)
+	if err != nil {
+		return nil, err
+	}
+	ack, err := c.c.Call(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	switch ack.Result {
+	case packet.ResultOK:
+		resp := new(Resp)
+		if err = c.codec.Unmarshal(ack.Payload, resp); err != nil {
+			return nil, fmt.Errorf("decode response: %w", err)
+		}
+		return resp, nil
+	case packet.ResultError:
+		return nil, decodeError(ack.Payload)
+	default:
+		return nil, fmt.Errorf("rpc %s: result = %d", method, ack.Result)
+	}
+}
+
+// Stub 把方法名和参数、返回值的类型绑定在一起，调用时不用再写类型参数
+type Stub[Req, Resp any] struct {
+	c      *Client
+	method string
+}
+
+func NewStub[Req, Resp any](c *Client, method string) *Stub[Req, Resp] {
+	return &Stub[Req, Resp]{c: c, method: method}
+}
+
+func (s *Stub[Req, Resp]) Call(ctx context.Context, req *Req) (*Resp, error) {
+	return Call[Req, Resp](ctx, s.c, s.method, req)
+}
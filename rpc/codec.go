@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Codec 负责请求参数和返回值的编解码。客户端在每个请求中带上 Codec 的名字，
+// 服务端按名字找到同一个 Codec 解码参数、编码返回值
+type Codec interface {
+	Name() string // 不超过 255 字节
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSON 使用标准库 encoding/json 编解码，默认已注册
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: map[string]Codec{"json": JSON}}
+
+// RegisterCodec 注册一个 Codec，服务端只接受已注册的 Codec 编码的请求。
+// 同名的 Codec 会被替换
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[c.Name()] = c
+}
+
+// UnregisterCodec 取消注册名为 name 的 Codec，之后用它编码的请求返回 CodeBadRequest
+func UnregisterCodec(name string) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	delete(codecs.m, name)
+}
+
+func lookupCodec(name string) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[name]
+	return c, ok
+}
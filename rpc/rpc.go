@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+)
+
+/* rpc 在 Submit/SubmitAck 之上实现带方法名的请求/应答调用，
+请求复用 Submit 的去重、签名、窗口以及截止时间和取消，服务端通过 server.ReplyHandler 接入。
+请求放在 Submit.Payload 中：
+方法名长度(1字节) + 方法名 + Codec 名长度(1字节) + Codec 名 + 编码后的参数
+应答放在 SubmitAck.Payload 中：
+Result 为 ResultOK 时是编码后的返回值；
+Result 为 ResultError 时是错误：Code 长度(1字节) + Code + Message
+*/
+
+// 服务端生成的错误码，handler 也可以通过 Errorf 返回自定义的错误码
+const (
+	CodeUnknownMethod = "unknown_method" // 服务端没有注册该方法
+	CodeBadRequest    = "bad_request"    // 请求格式错误、Codec 未注册或参数解码失败
+	CodeInternal      = "internal"       // handler panic 或返回值编码失败
+)
+
+// Error 是从服务端传回的错误。handler 返回的 *Error 原样传给调用方，
+// 其他 error 只保留 Error() 的文字，Code 为空
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return e.Message
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Errorf 构造一个带错误码的 *Error
+func Errorf(code string, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func encodeRequest(method string, codec string, arg []byte) ([]byte, error) {
+	if len(method) == 0 || len(method) > 0xFF {
+		return nil, errors.New("method name length must be between 1 and 255")
+	}
+	if len(codec) > 0xFF {
+		return nil, errors.New("codec name too long")
+	}
+	buf := make([]byte, 0, 2+len(method)+len(codec)+len(arg))
+	buf = append(buf, byte(len(method)))
+	buf = append(buf, method...)
+	buf = append(buf, byte(len(codec)))
+	buf = append(buf, codec...)
+	return append(buf, arg...), nil
+}
+
+func decodeRequest(payload []byte) (method string, codec string, arg []byte, err error) {
+	if len(payload) < 1 || len(payload) < 1+int(payload[0])+1 {
+		return "", "", nil, errors.New("request too short")
+	}
+	n := int(payload[0])
+	method, payload = string(payload[1:1+n]), payload[1+n:]
+	n = int(payload[0])
+	if len(payload) < 1+n {
+		return "", "", nil, errors.New("request too short")
+	}
+	return method, string(payload[1 : 1+n]), payload[1+n:], nil
+}
+
+func encodeError(e *Error) []byte {
+	code := e.Code
+	if len(code) > 0xFF {
+		code = code[:0xFF]
+	}
+	buf := make([]byte, 0, 1+len(code)+len(e.Message))
+	buf = append(buf, byte(len(code)))
+	buf = append(buf, code...)
+	return append(buf, e.Message...)
+}
+
+func decodeError(payload []byte) *Error {
+	if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+		return &Error{Message: string(payload)}
+	}
+	n := int(payload[0])
+	return &Error{Code: string(payload[1 : 1+n]), Message: string(payload[1+n:])}
+}
@@ -0,0 +1,170 @@
+package rpc
+
+import (
+	"37_tcp-server-demo1/client"
+	"37_tcp-server-demo1/server"
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type addReq struct {
+	A, B int
+}
+
+type addResp struct {
+	Sum int
+}
+
+// startServer 启动一个挂载了 rpc.Server 的服务端，返回连接好的客户端
+func startServer(t *testing.T, s *Server, codec Codec) *Client {
+	srv := server.NewServer("", nil)
+	srv.ReplyHandler = s.Handle
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	c, err := client.Dial(l.Addr().String(), client.Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	t.Cleanup(func() { c.Close() })
+	return NewClient(c, codec)
+}
+
+func newTestServer() *Server {
+	s := NewServer()
+	Register(s, "add", func(ctx context.Context, req *addReq) (*addResp, error) {
+		if SessionFromContext(ctx) == nil {
+			return nil, errors.New("no session")
+		}
+		return &addResp{Sum: req.A + req.B}, nil
+	})
+	Register(s, "div", func(ctx context.Context, req *addReq) (*addResp, error) {
+		if req.B == 0 {
+			return nil, Errorf("div_by_zero", "%d / 0", req.A)
+		}
+		return &addResp{Sum: req.A / req.B}, nil
+	})
+	Register(s, "fail", func(ctx context.Context, req *addReq) (*addResp, error) {
+		return nil, errors.New("plain error")
+	})
+	Register(s, "panic", func(ctx context.Context, req *addReq) (*addResp, error) {
+		panic("boom")
+	})
+	Register(s, "slow", func(ctx context.Context, req *addReq) (*addResp, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	return s
+}
+
+func TestRPC_Call(t *testing.T) {
+	c := startServer(t, newTestServer(), nil)
+
+	add := NewStub[addReq, addResp](c, "add")
+	resp, err := add.Call(context.Background(), &addReq{A: 1, B: 2})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if resp.Sum != 3 {
+		t.Errorf("want 3, actual %d", resp.Sum)
+	}
+
+	resp, err = Call[addReq, addResp](context.Background(), c, "div", &addReq{A: 9, B: 3})
+	if err != nil || resp.Sum != 3 {
+		t.Errorf("want 3, actual %v %v", resp, err)
+	}
+}
+
+func TestRPC_Error(t *testing.T) {
+	c := startServer(t, newTestServer(), nil)
+	ctx := context.Background()
+
+	cases := []struct {
+		method string
+		code   string
+		msg    string
+	}{
+		{"div", "div_by_zero", "1 / 0"},
+		{"fail", "", "plain error"},
+		{"panic", CodeInternal, "panic panic: boom"},
+		{"missing", CodeUnknownMethod, "missing"},
+	}
+	for _, tc := range cases {
+		_, err := Call[addReq, addResp](ctx, c, tc.method, &addReq{A: 1})
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: want *Error, actual %v", tc.method, err)
+			continue
+		}
+		if e.Code != tc.code || e.Message != tc.msg {
+			t.Errorf("%s: want %q %q, actual %q %q", tc.method, tc.code, tc.msg, e.Code, e.Message)
+		}
+	}
+
+	// 超过截止时间，handler 的 context 被取消
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := Call[addReq, addResp](ctx, c, "slow", &addReq{}); err != context.DeadlineExceeded {
+		t.Errorf("want context.DeadlineExceeded, actual %v", err)
+	}
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func TestRPC_Codec(t *testing.T) {
+	s := newTestServer()
+	c := startServer(t, s, gobCodec{})
+
+	// 服务端没有注册的 Codec
+	_, err := Call[addReq, addResp](context.Background(), c, "add", &addReq{A: 1, B: 2})
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeBadRequest {
+		t.Errorf("want bad_request, actual %v", err)
+	}
+
+	RegisterCodec(gobCodec{})
+	t.Cleanup(func() { UnregisterCodec("gob") })
+	resp, err := Call[addReq, addResp](context.Background(), c, "add", &addReq{A: 1, B: 2})
+	if err != nil || resp.Sum != 3 {
+		t.Errorf("want 3, actual %v %v", resp, err)
+	}
+}
+
+func TestRequest_EncodeDecode(t *testing.T) {
+	payload, err := encodeRequest("add", "json", []byte(`{"A":1}`))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	method, codec, arg, err := decodeRequest(payload)
+	if err != nil || method != "add" || codec != "json" || string(arg) != `{"A":1}` {
+		t.Errorf("want add json {\"A\":1}, actual %s %s %s %v", method, codec, arg, err)
+	}
+	if _, _, _, err = decodeRequest(payload[:5]); err == nil {
+		t.Errorf("want request too short, actual nil")
+	}
+	if _, err = encodeRequest("", "json", nil); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
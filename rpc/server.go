@@ -0,0 +1,101 @@
+package rpc
+
+import (
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/server"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+)
+
+// method 是类型擦除之后的 handler：解码参数、调用、编码返回值
+type method func(ctx context.Context, codec Codec, arg []byte) ([]byte, error)
+
+// Server 按方法名分发请求，Handle 可以直接作为 server.Server 的 ReplyHandler
+type Server struct {
+	// Logger 记录 handler 的 panic，为 nil 时使用 slog.Default()
+	Logger *slog.Logger
+
+	mu      sync.RWMutex
+	methods map[string]method
+}
+
+func NewServer() *Server {
+	return &Server{methods: make(map[string]method)}
+}
+
+// Register 注册方法 name。Req 和 Resp 由请求指定的 Codec 编解码，
+// fn 返回的 error 会传回给调用方（见 Error）。同名的方法会被替换
+func Register[Req, Resp any](s *Server, name string, fn func(ctx context.Context, req *Req) (*Resp, error)) {
+	m := func(ctx context.Context, codec Codec, arg []byte) ([]byte, error) {
+		req := new(Req)
+		if err := codec.Unmarshal(arg, req); err != nil {
+			return nil, Errorf(CodeBadRequest, "decode request: %v", err)
+		}
+		resp, err := fn(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := codec.Marshal(resp)
+		if err != nil {
+			return nil, Errorf(CodeInternal, "encode response: %v", err)
+		}
+		return data, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[name] = m
+}
+
+type sessionKey struct{}
+
+// SessionFromContext 返回发起调用的连接，handler 可以从中拿到客户端身份
+func SessionFromContext(ctx context.Context) *server.Session {
+	sess, _ := ctx.Value(sessionKey{}).(*server.Session)
+	return sess
+}
+
+// Handle 实现 server.ReplyHandler
+func (s *Server) Handle(ctx context.Context, sess *server.Session, submit *packet.Submit) (uint8, []byte) {
+	data, err := s.call(context.WithValue(ctx, sessionKey{}, sess), submit.Payload)
+	if err != nil {
+		var e *Error
+		if !errors.As(err, &e) {
+			e = &Error{Message: err.Error()}
+		}
+		return packet.ResultError, encodeError(e)
+	}
+	return packet.ResultOK, data
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *Server) call(ctx context.Context, payload []byte) (data []byte, err error) {
+	name, codecName, arg, err := decodeRequest(payload)
+	if err != nil {
+		return nil, Errorf(CodeBadRequest, "%v", err)
+	}
+	codec, ok := lookupCodec(codecName)
+	if !ok {
+		return nil, Errorf(CodeBadRequest, "unknown codec %q", codecName)
+	}
+	s.mu.RLock()
+	m, ok := s.methods[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, Errorf(CodeUnknownMethod, "%s", name)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s.logger().Error("rpc handler panic", "method", name, "panic", r)
+			err = Errorf(CodeInternal, "%s panic: %v", name, r)
+		}
+	}()
+	return m(ctx, codec, arg)
+}
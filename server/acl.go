@@ -0,0 +1,119 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/netip"
+)
+
+// ACL 为按来源网络的访问控制，由 Session.admit 在处理任何请求之前检查：连接在读取任何帧之前
+// （开启 PROXY 协议时检查头中的客户端地址），HTTP 网关在处理每个请求之前。
+// 只作用于 IP 连接，Unix domain socket 连接由 socket 文件的权限控制。nil 表示不做限制
+type ACL struct {
+	Allow []netip.Prefix `json:"allow,omitempty"` // 非空时只接受来自这些网络的连接
+	Deny  []netip.Prefix `json:"deny,omitempty"`  // 拒绝来自这些网络的连接，优先于 Allow
+
+	// Principals 把认证后的客户端身份（auth.Principal.Name）限制在指定的来源网络，
+	// 握手时检查，不在列表中的身份不受限制
+	Principals map[string][]netip.Prefix `json:"principals,omitempty"`
+}
+
+// AllowAddr 判断是否接受来自 ip 的连接
+func (a *ACL) AllowAddr(ip netip.Addr) bool {
+	if a == nil {
+		return true
+	}
+	ip = ip.Unmap()
+	if containsAddr(a.Deny, ip) {
+		return false
+	}
+	return len(a.Allow) == 0 || containsAddr(a.Allow, ip)
+}
+
+// AllowPrincipal 判断身份为 name 的客户端能否从 ip 连接
+func (a *ACL) AllowPrincipal(name string, ip netip.Addr) bool {
+	if a == nil {
+		return true
+	}
+	prefixes, ok := a.Principals[name]
+	return !ok || containsAddr(prefixes, ip.Unmap())
+}
+
+func containsAddr(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP 取出连接地址中的 IP，Unix domain socket 等没有 IP 的地址返回 false
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.AddrPort().Addr().Unmap(), true
+	}
+	if addr == nil {
+		return netip.Addr{}, false
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addrPort.Addr().Unmap(), true
+}
+
+// ACLStats 为访问控制拒绝的次数
+type ACLStats struct {
+	DeniedConnections uint64 `json:"denied_connections"` // 来源网络被拒绝的连接
+	DeniedPrincipals  uint64 `json:"denied_principals"`  // 身份不允许从该网络连接的握手
+}
+
+// SetACL 替换访问控制规则，可以在运行中调用，之后建立的连接和握手按新规则检查，已经建立的连接不受影响。
+// acl 在传入之后不应再被修改
+func (s *Server) SetACL(acl *ACL) {
+	s.acl.Store(acl)
+}
+
+// ACL 返回当前的访问控制规则，不做限制时为 nil
+func (s *Server) ACL() *ACL {
+	return s.acl.Load()
+}
+
+// ACLStats 返回服务端启动以来访问控制拒绝的次数
+func (s *Server) ACLStats() ACLStats {
+	return ACLStats{
+		DeniedConnections: s.deniedConns.Load(),
+		DeniedPrincipals:  s.deniedPrincipals.Load(),
+	}
+}
+
+// admit 检查 Session 的来源网络，已经知道身份时（HTTP 网关）同时检查身份，不允许时记录日志和计数。
+// 所有传输方式创建的 Session 在处理任何请求之前都要经过这里（见 Server.serveSession 和 Server.serveSubmit），
+// 新增的传输方式不能绕过访问控制
+func (s *Session) admit() bool {
+	ip, ok := addrIP(s.RemoteAddr())
+	if !ok {
+		return true
+	}
+	if !s.srv.acl.Load().AllowAddr(ip) {
+		s.srv.deniedConns.Add(1)
+		s.srv.logf(slog.LevelWarn, "connection from %s denied by acl", s.RemoteAddr())
+		return false
+	}
+	if principal := s.Principal(); principal != nil {
+		return s.admitPrincipal(principal.Name)
+	}
+	return true
+}
+
+// admitPrincipal 检查认证后的身份能否从 Session 的来源网络连接
+func (s *Session) admitPrincipal(name string) bool {
+	ip, ok := addrIP(s.RemoteAddr())
+	if !ok || s.srv.acl.Load().AllowPrincipal(name, ip) {
+		return true
+	}
+	s.srv.deniedPrincipals.Add(1)
+	s.srv.logf(slog.LevelWarn, "principal %s from %s denied by acl", name, s.RemoteAddr())
+	return false
+}
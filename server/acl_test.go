@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	acl := &ACL{
+		Allow: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+		Deny:  []netip.Prefix{netip.MustParsePrefix("10.0.1.0/24")},
+		Principals: map[string][]netip.Prefix{
+			"alice": {netip.MustParsePrefix("10.2.0.0/16")},
+		},
+	}
+	cases := map[string]bool{
+		"10.1.2.3":        true,
+		"10.0.1.5":        false, // Deny 优先
+		"192.168.1.1":     false, // 不在 Allow 中
+		"::ffff:10.1.2.3": true,  // IPv4-mapped 地址按 IPv4 匹配
+		"2001:db8::1":     true,
+	}
+	for addr, want := range cases {
+		if actual := acl.AllowAddr(netip.MustParseAddr(addr)); actual != want {
+			t.Errorf("%s: want %v, actual %v", addr, want, actual)
+		}
+	}
+
+	if !acl.AllowPrincipal("alice", netip.MustParseAddr("10.2.3.4")) {
+		t.Errorf("want alice allowed from 10.2.3.4, actual denied")
+	}
+	if acl.AllowPrincipal("alice", netip.MustParseAddr("10.1.2.3")) {
+		t.Errorf("want alice denied from 10.1.2.3, actual allowed")
+	}
+	if !acl.AllowPrincipal("bob", netip.MustParseAddr("10.1.2.3")) {
+		t.Errorf("want bob unrestricted, actual denied")
+	}
+
+	var none *ACL
+	if !none.AllowAddr(netip.MustParseAddr("192.168.1.1")) || !none.AllowPrincipal("alice", netip.MustParseAddr("192.168.1.1")) {
+		t.Errorf("want nil acl to allow all, actual denied")
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/* 管理接口，供运维人员在运行中查看和干预连接：
+	GET    /sessions             所有连接的信息和流量统计（见 SessionInfo）
+	GET    /sessions/{key}       单个连接
+	DELETE /sessions/{key}       强制断开连接
+	POST   /sessions/{key}/push  向连接推送一条消息，请求体 {"topic": "...", "payload": "文本", "payload_base64": "二进制，与 payload 二选一"}
+	GET    /loglevel             当前日志级别，响应体 {"level": "INFO"}
+	PUT    /loglevel             调整日志级别，请求体 {"level": "debug|info|warn|error"}
+	GET    /acl                  当前的访问控制规则和拒绝次数，响应体 {"acl": ACL, "stats": ACLStats}
+	PUT    /acl                  替换访问控制规则，请求体为 ACL，例如 {"allow": ["10.0.0.0/8"], "principals": {"alice": ["10.1.0.0/16"]}}
+	GET    /reload               配置重新加载的结果统计（见 ReloadStats）
+{key} 为 SessionInfo.Key。AdminToken 为空时不做认证，此时只应监听在本机或内网地址上
+*/
+
+type pushRequest struct {
+	Topic         string `json:"topic"`
+	Payload       string `json:"payload"`
+	PayloadBase64 []byte `json:"payload_base64"`
+}
+
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+type aclResponse struct {
+	ACL   *ACL     `json:"acl"`
+	Stats ACLStats `json:"stats"`
+}
+
+// AdminHandler 返回管理接口
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.serveAdminSessions)
+	mux.HandleFunc("GET /sessions/{key}", s.serveAdminSession)
+	mux.HandleFunc("DELETE /sessions/{key}", s.serveAdminDisconnect)
+	mux.HandleFunc("POST /sessions/{key}/push", s.serveAdminPush)
+	mux.HandleFunc("GET /loglevel", s.serveAdminGetLogLevel)
+	mux.HandleFunc("PUT /loglevel", s.serveAdminSetLogLevel)
+	mux.HandleFunc("GET /acl", s.serveAdminGetACL)
+	mux.HandleFunc("PUT /acl", s.serveAdminSetACL)
+	mux.HandleFunc("GET /reload", s.serveAdminReloadStats)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authenticateAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) authenticateAdmin(r *http.Request) bool {
+	if s.AdminToken == "" {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1
+}
+
+func (s *Server) serveAdminSessions(w http.ResponseWriter, r *http.Request) {
+	infos := make([]SessionInfo, 0)
+	for _, sess := range s.Sessions() {
+		infos = append(infos, sess.Info())
+	}
+	s.writeJSON(w, http.StatusOK, infos)
+}
+
+// pathSession 按路径中的 {key} 查找连接，找不到时写入错误响应并返回 nil
+func (s *Server) pathSession(w http.ResponseWriter, r *http.Request) *Session {
+	key, err := strconv.ParseUint(r.PathValue("key"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid session key", http.StatusBadRequest)
+		return nil
+	}
+	sess, ok := s.Session(key)
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil
+	}
+	return sess
+}
+
+func (s *Server) serveAdminSession(w http.ResponseWriter, r *http.Request) {
+	if sess := s.pathSession(w, r); sess != nil {
+		s.writeJSON(w, http.StatusOK, sess.Info())
+	}
+}
+
+func (s *Server) serveAdminDisconnect(w http.ResponseWriter, r *http.Request) {
+	sess := s.pathSession(w, r)
+	if sess == nil {
+		return
+	}
+	s.logf(slog.LevelInfo, "admin %s: disconnect session %d (%s)", r.RemoteAddr, sess.Key(), sess.RemoteAddr())
+	if err := sess.Close(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveAdminPush(w http.ResponseWriter, r *http.Request) {
+	sess := s.pathSession(w, r)
+	if sess == nil {
+		return
+	}
+	var req pushRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Payload != "" && req.PayloadBase64 != nil {
+		http.Error(w, "payload and payload_base64 are mutually exclusive", http.StatusBadRequest)
+		return
+	}
+	payload := []byte(req.Payload)
+	if req.PayloadBase64 != nil {
+		payload = req.PayloadBase64
+	}
+	if err := sess.Push(req.Topic, payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) serveAdminGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, logLevelBody{Level: s.LogLevel.Level().String()})
+}
+
+func (s *Server) serveAdminSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req logLevelBody
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	level, err := ParseLogLevel(req.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.LogLevel.Set(level)
+	s.logf(slog.LevelInfo, "admin %s: log level set to %s", r.RemoteAddr, level)
+	s.writeJSON(w, http.StatusOK, logLevelBody{Level: level.String()})
+}
+
+func (s *Server) serveAdminGetACL(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, aclResponse{ACL: s.ACL(), Stats: s.ACLStats()})
+}
+
+func (s *Server) serveAdminSetACL(w http.ResponseWriter, r *http.Request) {
+	acl := new(ACL)
+	if !decodeJSON(w, r, acl) {
+		return
+	}
+	s.SetACL(acl)
+	s.logf(slog.LevelInfo, "admin %s: acl replaced, %d allow, %d deny, %d principal rules", r.RemoteAddr, len(acl.Allow), len(acl.Deny), len(acl.Principals))
+	s.writeJSON(w, http.StatusOK, aclResponse{ACL: acl, Stats: s.ACLStats()})
+}
+
+func (s *Server) serveAdminReloadStats(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, http.StatusOK, s.ReloadStats())
+}
+
+// decodeJSON 解析请求体，出错时写入 400 响应并返回 false
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"37_tcp-server-demo1/packet"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+/* Submit 的处理分两步：
+1. 读 goroutine 中校验签名、占用窗口。签名的 Seq 依赖包的到达顺序，必须按顺序检查；
+2. 单独的 goroutine 中调用 handler 并回复应答，读 goroutine 继续接收后续的包（包括 Cancel）。
+传给 handler 的 context 在截止时间到达、收到 Cancel 或者连接关闭时被取消
+*/
+
+// admitSubmit 校验签名并占用窗口，result 不为 ResultOK 时不会占用窗口。
+// 返回 error 时连接会被关闭
+func (s *Session) admitSubmit(submit *packet.Submit) (*packet.Submit, uint8, error) {
+	if s.auth.Load().SigningKey != nil {
+		if s.signKey == nil {
+			return nil, packet.ResultBadSignature, errors.New("unsigned submit")
+		}
+		verified, result := s.verify(submit)
+		if result != packet.ResultOK {
+			return nil, result, nil
+		}
+		submit = verified
+	}
+	if !s.acquireCredit() {
+		return nil, packet.ResultNoCredit, nil
+	}
+	return submit, packet.ResultOK, nil
+}
+
+// startSubmit 在新的 goroutine 中处理 submit，处理完成后由该 goroutine 回复 SubmitAck
+func (s *Session) startSubmit(submit *packet.Submit) (packet.Packet, error) {
+	admitted, result, err := s.admitSubmit(submit)
+	if result != packet.ResultOK {
+		return packet.NewSubmitAck(submit.ID, result), err
+	}
+	ctx, ok := s.startCall(s.ctx, admitted.ID, admitted.Deadline)
+	if !ok {
+		// 客户端重发了仍在处理中的 Submit，第一次处理完成时的 SubmitAck 就是它的应答
+		s.releaseCredit()
+		return nil, nil
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		result, reply := s.handleSubmit(ctx, admitted)
+		s.finishCall(admitted.ID)
+		// 先归还窗口再回复，否则客户端收到应答后立即发出的 Submit 可能被误判为超出窗口
+		s.releaseCredit()
+		ack := &packet.SubmitAck{ID: admitted.ID, Result: result, Payload: reply}
+		if err := s.Send(ack); err != nil {
+			s.srv.logf(slog.LevelWarn, "error sending submit ack[%s]: %v", admitted.ID, err)
+		}
+	}()
+	return nil, nil
+}
+
+// startSubmitBatch 按顺序处理批次中的每条消息，某一条失败不影响后面的消息。
+// Cancel 批次 ID 会取消整个批次，Cancel 某条消息的 ID 只取消这一条。
+// SubmitBatchAck 只有结果，ReplyHandler 返回的数据会被丢弃
+func (s *Session) startSubmitBatch(batch *packet.SubmitBatch) (packet.Packet, error) {
+	results := make([]uint8, len(batch.Entries))
+	admitted := make([]*packet.Submit, len(batch.Entries))
+	release := func() {
+		for _, submit := range admitted {
+			if submit != nil {
+				s.releaseCredit()
+			}
+		}
+	}
+	for i, submit := range batch.Entries {
+		verified, result, err := s.admitSubmit(submit)
+		if err != nil {
+			release()
+			return packet.NewSubmitBatchAck(batch.ID, result, nil), err
+		}
+		results[i], admitted[i] = result, verified
+	}
+	ctx, ok := s.startCall(s.ctx, batch.ID, time.Time{})
+	if !ok {
+		release() // 重发的批次仍在处理中
+		return nil, nil
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for i, submit := range admitted {
+			if submit == nil {
+				continue
+			}
+			results[i] = s.handleEntry(ctx, submit)
+			s.releaseCredit()
+		}
+		s.finishCall(batch.ID)
+		if err := s.Send(packet.NewSubmitBatchAck(batch.ID, packet.ResultOK, results)); err != nil {
+			s.srv.logf(slog.LevelWarn, "error sending submit batch ack[%s]: %v", batch.ID, err)
+		}
+	}()
+	return nil, nil
+}
+
+func (s *Session) handleEntry(ctx context.Context, submit *packet.Submit) uint8 {
+	ctx, ok := s.startCall(ctx, submit.ID, submit.Deadline)
+	if !ok {
+		s.srv.logf(slog.LevelDebug, "submit[%s] from %s: already in progress", submit.ID, s.RemoteAddr())
+		return packet.ResultError
+	}
+	defer s.finishCall(submit.ID)
+	result, _ := s.handleSubmit(ctx, submit)
+	return result
+}
+
+// startCall 登记一个正在处理的请求，返回传给 handler 的 context。
+// 同一个 ID 已经在处理中时返回 false
+func (s *Session) startCall(parent context.Context, id string, deadline time.Time) (context.Context, bool) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	if _, ok := s.calls[id]; ok {
+		return nil, false
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if deadline.IsZero() {
+		ctx, cancel = context.WithCancel(parent)
+	} else {
+		ctx, cancel = context.WithDeadline(parent, deadline)
+	}
+	s.calls[id] = cancel
+	return ctx, true
+}
+
+func (s *Session) finishCall(id string) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	if cancel, ok := s.calls[id]; ok {
+		cancel()
+		delete(s.calls, id)
+	}
+}
+
+// cancelCall 处理客户端的 Cancel，ID 不存在（已经处理完或从未收到）时忽略
+func (s *Session) cancelCall(id string) {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	if cancel, ok := s.calls[id]; ok {
+		cancel()
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupCache 按 Submit.ID 记录已经处理过的请求的响应状态，
+// 客户端因为丢失应答而重发同一个 ID 时，直接回复原来的 SubmitAck，不再调用 handler。
+// 容量和时间窗口都有上限：超过 window 的记录会过期，超过 size 时淘汰最早的记录。
+// 同一个连接上的 Submit 并发处理，所有方法都会加锁
+type dedupCache struct {
+	mu     sync.Mutex
+	size   int
+	window time.Duration
+	items  map[string]*list.Element
+	order  *list.List // 按写入时间排序，Front 最早
+	// calls 为正在处理的 ID，处理完成时关闭 channel。客户端会话共用缓存时，
+	// 断线重连后重发的 Submit 可能在旧连接的 handler 返回之前到达，需要等第一次处理的结果
+	calls map[string]chan struct{}
+}
+
+type dedupEntry struct {
+	id     string
+	result uint8
+	reply  []byte // SubmitAck 携带的数据
+	at     time.Time
+}
+
+func newDedupCache(size int, window time.Duration) *dedupCache {
+	return &dedupCache{
+		size:   size,
+		window: window,
+		items:  make(map[string]*list.Element),
+		order:  list.New(),
+		calls:  make(map[string]chan struct{}),
+	}
+}
+
+// claim 在处理 id 之前调用：ok 为 true 表示处理过，返回第一次处理的响应状态和数据；
+// wait 不为 nil 表示其他连接正在处理，调用方等 wait 关闭之后重新 claim；
+// 其他情况下 id 登记为正在处理，调用方处理完成后必须调用 finish
+func (c *dedupCache) claim(id string, now time.Time) (result uint8, reply []byte, ok bool, wait <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(now)
+	if e, ok := c.items[id]; ok {
+		entry := e.Value.(*dedupEntry)
+		return entry.result, entry.reply, true, nil
+	}
+	if done, ok := c.calls[id]; ok {
+		return 0, nil, false, done
+	}
+	c.calls[id] = make(chan struct{})
+	return 0, nil, false, nil
+}
+
+// finish 结束 claim 登记的处理并唤醒等待的调用方。store 为 false 时不记录响应（例如被取消的 Submit），
+// 等待的调用方重新 claim 后自己处理
+func (c *dedupCache) finish(id string, result uint8, reply []byte, store bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if done, ok := c.calls[id]; ok {
+		close(done)
+		delete(c.calls, id)
+	}
+	if !store {
+		return
+	}
+	c.expire(now)
+	if e, ok := c.items[id]; ok {
+		c.order.Remove(e)
+	}
+	c.items[id] = c.order.PushBack(&dedupEntry{id: id, result: result, reply: reply, at: now})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Front())
+	}
+}
+
+func (c *dedupCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// expire 从最早的记录开始，删除所有超出时间窗口的记录
+func (c *dedupCache) expire(now time.Time) {
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		if now.Sub(e.Value.(*dedupEntry).at) < c.window {
+			return
+		}
+		c.remove(e)
+	}
+}
+
+func (c *dedupCache) remove(e *list.Element) {
+	c.order.Remove(e)
+	delete(c.items, e.Value.(*dedupEntry).id)
+}
+
+// dedupSessions 保存协商了 packet.CapSessionResume 的客户端会话的去重缓存，
+// key 为认证身份和 Conn.ID（见 dedupSessionKey）。同一个客户端会话断线重连后拿到同一个缓存，
+// 在新连接上重发的 Submit 也不会被重复处理。最后一个连接断开之后缓存再保留一个时间窗口
+type dedupSessions struct {
+	mu      sync.Mutex
+	entries map[string]*dedupSession
+}
+
+type dedupSession struct {
+	cache    *dedupCache
+	refs     int       // 正在使用这个缓存的连接数
+	released time.Time // refs 降为 0 的时间
+}
+
+func dedupSessionKey(principal, sessionID string) string {
+	return principal + "\x00" + sessionID
+}
+
+// acquire 返回 key 对应的去重缓存，不存在时用 create 创建
+func (d *dedupSessions) acquire(key string, now time.Time, create func() *dedupCache) *dedupCache {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sweep(now)
+	if e, ok := d.entries[key]; ok {
+		e.refs++
+		return e.cache
+	}
+	c := create()
+	if c == nil {
+		return nil
+	}
+	if d.entries == nil {
+		d.entries = make(map[string]*dedupSession)
+	}
+	d.entries[key] = &dedupSession{cache: c, refs: 1}
+	return c
+}
+
+// release 在连接关闭时调用，与 acquire 成对出现
+func (d *dedupSessions) release(key string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[key]; ok {
+		if e.refs--; e.refs == 0 {
+			e.released = now
+		}
+	}
+}
+
+// sweep 删除没有连接在使用、并且已经超出时间窗口的缓存，其中的记录都已经过期了
+func (d *dedupSessions) sweep(now time.Time) {
+	for key, e := range d.entries {
+		if e.refs == 0 && now.Sub(e.released) >= e.cache.window {
+			delete(d.entries, key)
+		}
+	}
+}
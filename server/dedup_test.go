@@ -0,0 +1,77 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupCache(t *testing.T) {
+	now := time.Now()
+	c := newDedupCache(2, time.Minute)
+	put := func(id string, result uint8) {
+		if _, _, ok, wait := c.claim(id, now); ok || wait != nil {
+			t.Fatalf("want %s claimed, actual %v %v", id, ok, wait)
+		}
+		c.finish(id, result, nil, true, now)
+	}
+	put("00000001", 0)
+	put("00000002", 1)
+
+	if result, _, ok, _ := c.claim("00000002", now); !ok || result != 1 {
+		t.Errorf("want 1/true, actual %d/%v", result, ok)
+	}
+
+	// 正在处理的 ID 需要等待；没有记录结果时，等待的调用方可以重新 claim
+	if _, _, ok, wait := c.claim("00000003", now); ok || wait != nil {
+		t.Fatalf("want claimed, actual %v %v", ok, wait)
+	}
+	_, _, _, wait := c.claim("00000003", now)
+	if wait == nil {
+		t.Fatalf("want wait, actual nil")
+	}
+	c.finish("00000003", 0, nil, false, now)
+	<-wait
+	if _, _, ok, wait := c.claim("00000003", now); ok || wait != nil {
+		t.Errorf("want claimed again, actual %v %v", ok, wait)
+	}
+	c.finish("00000003", 0, nil, true, now)
+
+	// 超出容量，淘汰最早的 00000001
+	if _, _, ok, _ := c.claim("00000001", now); ok {
+		t.Errorf("want 00000001 evicted, actual still cached")
+	}
+	c.finish("00000001", 0, nil, false, now)
+	if c.len() != 2 {
+		t.Errorf("want 2, actual %d", c.len())
+	}
+
+	// 超出时间窗口，全部过期
+	if _, _, ok, _ := c.claim("00000003", now.Add(time.Minute)); ok {
+		t.Errorf("want 00000003 expired, actual still cached")
+	}
+	if c.len() != 0 {
+		t.Errorf("want 0, actual %d", c.len())
+	}
+}
+
+func TestDedupSessions(t *testing.T) {
+	now := time.Now()
+	var d dedupSessions
+	create := func() *dedupCache { return newDedupCache(16, time.Minute) }
+	c1 := d.acquire(dedupSessionKey("alice", "sess0001"), now, create)
+	c1.claim("00000001", now)
+	c1.finish("00000001", 0, nil, true, now)
+
+	// 第一个连接断开之后重连，拿到同一个缓存
+	d.release(dedupSessionKey("alice", "sess0001"), now)
+	if c2 := d.acquire(dedupSessionKey("alice", "sess0001"), now.Add(time.Second), create); c2 != c1 {
+		t.Errorf("want the same cache, actual a new one")
+	}
+	d.release(dedupSessionKey("alice", "sess0001"), now.Add(time.Second))
+
+	// 没有连接使用超过时间窗口之后被清理
+	d.acquire(dedupSessionKey("alice", "sess0002"), now.Add(2*time.Minute), create)
+	if _, ok := d.entries[dedupSessionKey("alice", "sess0001")]; ok {
+		t.Errorf("want sess0001 swept, actual still present")
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+/* HTTP/JSON 网关，供 curl 等无法使用 TCP 协议的脚本调用：
+	POST /submit    请求体 {"id": "可选，8 字节，需要认证", "payload": "文本", "payload_base64": "二进制，与 payload 二选一", "timeout_ms": 可选}
+	                响应体 {"id": "...", "result": SubmitAck.Result, "payload": "SubmitAck.Payload 的 base64"}
+	GET  /sessions  当前连接的客户端列表
+请求构造为 packet.Submit，与 TCP 连接上的 Submit 经过同样的访问控制、窗口、去重、预写日志和 handler，结果就是 SubmitAck。
+每个请求使用一个单独的 Session，请求结束时取消它的 context。窗口按身份计算同时处理的请求数；
+去重缓存按身份共用（见 dedupSessions），同一身份重试时带上相同的 id，第一次的请求还在处理时等它的结果；
+未开启认证时不接受 id，由服务端随机生成。
+开启认证时通过 Authorization: Bearer <token> 携带令牌。HTTP 请求不携带签名，不受 SigningKey 约束，
+需要完整性保护时应当在 HTTPS 之后使用
+*/
+
+// httpSessionID 为 HTTP 网关的 Session 的连接流水号，Conn 包中的连接流水号总是 8 字节，去重缓存的 key 不会冲突
+const httpSessionID = "http"
+
+type submitRequest struct {
+	ID            string `json:"id"`
+	Payload       string `json:"payload"`
+	PayloadBase64 []byte `json:"payload_base64"`
+	TimeoutMs     int64  `json:"timeout_ms"`
+}
+
+type submitResponse struct {
+	ID      string `json:"id"`
+	Result  uint8  `json:"result"`
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// HTTPHandler 返回 HTTP/JSON 网关
+func (s *Server) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /submit", s.serveSubmit)
+	mux.HandleFunc("GET /sessions", s.serveSessions)
+	return mux
+}
+
+func (s *Server) serveSubmit(w http.ResponseWriter, r *http.Request) {
+	principal, err := s.authenticateHTTP(r)
+	if err != nil {
+		s.logf(slog.LevelWarn, "http submit from %s: %v", r.RemoteAddr, err)
+		s.writeJSON(w, http.StatusUnauthorized, submitResponse{Result: packet.ResultAuthFailed})
+		return
+	}
+	var req submitRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, frame.DefaultMaxFrameSize))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&req); err != nil {
+		http.Error(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Payload != "" && req.PayloadBase64 != nil {
+		http.Error(w, "payload and payload_base64 are mutually exclusive", http.StatusBadRequest)
+		return
+	}
+	if req.ID == "" {
+		req.ID = randomID()
+	} else if principal == nil {
+		// 未认证的调用方共用去重缓存，无法区分重试和其他调用方恰好使用了同一个 id
+		http.Error(w, "id requires authentication, omit it to let the server generate one", http.StatusBadRequest)
+		return
+	}
+	if len(req.ID) != 8 {
+		http.Error(w, "id must be exactly 8 bytes", http.StatusBadRequest)
+		return
+	}
+	submit := packet.NewSubmit(req.ID, []byte(req.Payload))
+	if req.PayloadBase64 != nil {
+		submit.Payload = req.PayloadBase64
+	}
+	if req.TimeoutMs > 0 {
+		submit.Deadline = time.Now().Add(time.Duration(req.TimeoutMs) * time.Millisecond)
+	}
+	if err = s.ensureRecovered(); err != nil {
+		http.Error(w, "server unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	sess := s.newHTTPSession(r, principal)
+	if !sess.admit() {
+		s.writeJSON(w, http.StatusForbidden, submitResponse{ID: submit.ID, Result: packet.ResultAuthFailed})
+		return
+	}
+	if status, result := s.openHTTPSession(sess); status != http.StatusOK {
+		s.writeJSON(w, status, submitResponse{ID: submit.ID, Result: result})
+		return
+	}
+	defer s.closeHTTPSession(sess)
+	// 每个请求一个 Session，startCall 不会遇到重复的 ID；其他请求中正在处理的同一个 ID 由去重缓存等待
+	ctx, _ := sess.startCall(sess.ctx, submit.ID, submit.Deadline)
+	result, reply := sess.handleSubmit(ctx, submit)
+	sess.finishCall(submit.ID)
+	ack := &packet.SubmitAck{ID: submit.ID, Result: result, Payload: reply}
+	s.writeJSON(w, http.StatusOK, submitResponse{ID: ack.ID, Result: ack.Result, Payload: ack.Payload})
+}
+
+func (s *Server) serveSessions(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.authenticateHTTP(r); err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	infos := make([]SessionInfo, 0)
+	for _, sess := range s.Sessions() {
+		infos = append(infos, sess.Info())
+	}
+	s.writeJSON(w, http.StatusOK, infos)
+}
+
+// authenticateHTTP 用 Authorization: Bearer 中的令牌认证，服务端未开启认证时返回 nil
+func (s *Server) authenticateHTTP(r *http.Request) (*auth.Principal, error) {
+	authenticator := s.settings().Authenticator
+	if authenticator == nil {
+		return nil, nil
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, errors.New("missing bearer token")
+	}
+	return authenticator.Authenticate([]byte(token))
+}
+
+// newHTTPSession 为一个请求创建 Session，它的 context 在请求结束（或客户端断开）时取消
+func (s *Server) newHTTPSession(r *http.Request, principal *auth.Principal) *Session {
+	sess := newSession(s, nil)
+	sess.cancel()
+	sess.ctx, sess.cancel = context.WithCancel(r.Context())
+	sess.remote = s.httpRemoteAddr(r)
+	sess.id, sess.principal, sess.connected = httpSessionID, principal, true
+	return sess
+}
+
+// openHTTPSession 登记正在处理的请求，Close 和 Shutdown 时取消它们。同一身份同时处理的请求数受窗口限制，
+// 去重缓存按身份共用。返回的状态码不是 http.StatusOK 时请求被拒绝，不需要调用 closeHTTPSession
+func (s *Server) openHTTPSession(sess *Session) (int, uint8) {
+	name := httpPrincipalName(sess)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		sess.cancel()
+		return http.StatusServiceUnavailable, packet.ResultError
+	}
+	if w := sess.window.Load(); w > 0 && s.httpInflight[name] >= int(w) {
+		sess.cancel()
+		return http.StatusTooManyRequests, packet.ResultNoCredit
+	}
+	if s.httpSessions == nil {
+		s.httpSessions = make(map[*Session]struct{})
+		s.httpInflight = make(map[string]int)
+	}
+	s.httpSessions[sess] = struct{}{}
+	s.httpInflight[name]++
+	if sess.dedup != nil {
+		sess.dedupKey = dedupSessionKey(name, httpSessionID)
+		sess.dedup = s.dedups.acquire(sess.dedupKey, time.Now(), s.newDedupCache)
+	}
+	return http.StatusOK, packet.ResultOK
+}
+
+func (s *Server) closeHTTPSession(sess *Session) {
+	sess.cancel()
+	sess.releaseDedup()
+	name := httpPrincipalName(sess)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.httpSessions, sess)
+	if s.httpInflight[name]--; s.httpInflight[name] == 0 {
+		delete(s.httpInflight, name)
+	}
+}
+
+func httpPrincipalName(sess *Session) string {
+	if sess.principal == nil {
+		return ""
+	}
+	return sess.principal.Name
+}
+
+// httpRemoteAddr 返回请求的客户端地址。请求来自 TrustedProxies 中的反向代理时，取 X-Forwarded-For 的最后一项，
+// 即代理看到的客户端地址（更前面的项由客户端自己填写，不可信）
+func (s *Server) httpRemoteAddr(r *http.Request) net.Addr {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	addr := net.TCPAddrFromAddrPort(addrPort)
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 || !containsAddr(s.TrustedProxies, addrPort.Addr().Unmap()) {
+		return addr
+	}
+	hops := strings.Split(forwarded[len(forwarded)-1], ",")
+	ip, err := netip.ParseAddr(strings.TrimSpace(hops[len(hops)-1]))
+	if err != nil {
+		return addr
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, 0))
+}
+
+// ensureRecovered 保证处理新的 Submit 之前已经重放了预写日志
+func (s *Server) ensureRecovered() error {
+	if s.WAL == nil {
+		return nil
+	}
+	s.recoverOnce.Do(func() { s.recoverErr = s.recover() })
+	return s.recoverErr
+}
+
+func randomID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logf(slog.LevelDebug, "error writing json response: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// defaultLogger 在 Server.Logger 为 nil 时使用，输出到标准输出
+var defaultLogger = slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+// logf 按级别输出一条日志，低于 s.LogLevel 的日志直接丢弃。级别在运行中可以通过 LogLevel.Set 或管理接口调整
+func (s *Server) logf(level slog.Level, format string, args ...any) {
+	if level < s.LogLevel.Level() {
+		return
+	}
+	logger := s.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+	logger.Log(context.Background(), level, fmt.Sprintf(format, args...))
+}
+
+// ParseLogLevel 解析 debug、info、warn、error（不区分大小写）
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"37_tcp-server-demo1/packet"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptions 是服务端的订阅表：订阅的主题（可含通配符）-> 订阅了该主题的连接
+type subscriptions struct {
+	mu      sync.RWMutex
+	filters map[string]map[*Session]struct{}
+	counter atomic.Uint64 // 生成服务端推送的 Publish 包的 ID
+}
+
+func (t *subscriptions) add(filter string, sess *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.filters == nil {
+		t.filters = make(map[string]map[*Session]struct{})
+	}
+	if t.filters[filter] == nil {
+		t.filters[filter] = make(map[*Session]struct{})
+	}
+	t.filters[filter][sess] = struct{}{}
+}
+
+func (t *subscriptions) remove(filter string, sess *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.filters[filter], sess)
+	if len(t.filters[filter]) == 0 {
+		delete(t.filters, filter)
+	}
+}
+
+// removeSession 在连接关闭时删除它的所有订阅
+func (t *subscriptions) removeSession(sess *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for filter, sessions := range t.filters {
+		delete(sessions, sess)
+		if len(sessions) == 0 {
+			delete(t.filters, filter)
+		}
+	}
+}
+
+// match 返回订阅了 topic 的所有连接，一个连接即使有多个订阅匹配也只出现一次
+func (t *subscriptions) match(topic string) []*Session {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	seen := make(map[*Session]struct{})
+	var result []*Session
+	for filter, sessions := range t.filters {
+		if !packet.MatchTopic(filter, topic) {
+			continue
+		}
+		for sess := range sessions {
+			if _, ok := seen[sess]; !ok {
+				seen[sess] = struct{}{}
+				result = append(result, sess)
+			}
+		}
+	}
+	return result
+}
+
+// nextID 生成服务端推送的 Publish 包的 ID
+func (t *subscriptions) nextID() string {
+	return fmt.Sprintf("%08d", t.counter.Add(1)%100000000)
+}
+
+// Publish 把消息放入所有订阅了 topic 的连接的推送队列，返回放入的连接数。
+// Publish 不等待消息写入连接，推送队列已满的连接会被断开（见 Server.PublishQueueSize）
+func (s *Server) Publish(topic string, payload []byte) (int, error) {
+	if err := packet.ValidateTopic(topic); err != nil {
+		return 0, err
+	}
+	p := packet.NewPublish(s.subs.nextID(), topic, payload)
+	delivered := 0
+	for _, sess := range s.subs.match(topic) {
+		if !sess.enqueuePush(p) {
+			s.logf(slog.LevelWarn, "publish queue of %s is full, disconnecting the slow subscriber", sess.RemoteAddr())
+			s.subs.removeSession(sess)
+			sess.conn.Close()
+			continue
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+// startPushLoop 在第一次订阅时创建推送队列和写队列的 goroutine，连接关闭时 goroutine 退出
+func (s *Session) startPushLoop() {
+	s.pushOnce.Do(func() {
+		size := s.srv.PublishQueueSize
+		if size <= 0 {
+			size = 256
+		}
+		s.pushes = make(chan packet.Packet, size)
+		go s.pushLoop()
+	})
+}
+
+func (s *Session) pushLoop() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case p := <-s.pushes:
+			if err := s.Send(p); err != nil {
+				s.srv.logf(slog.LevelDebug, "error publishing to %s: %v", s.RemoteAddr(), err)
+			}
+		}
+	}
+}
+
+// enqueuePush 把消息放入推送队列，不阻塞，队列已满时返回 false
+func (s *Session) enqueuePush(p packet.Packet) bool {
+	select {
+	case s.pushes <- p:
+		return true
+	default:
+		return false
+	}
+}
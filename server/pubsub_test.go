@@ -0,0 +1,31 @@
+package server
+
+import "testing"
+
+func TestSubscriptions(t *testing.T) {
+	var subs subscriptions
+	s1, s2 := &Session{}, &Session{}
+	subs.add("a/+", s1)
+	subs.add("a/#", s1)
+	subs.add("a/b", s2)
+
+	// s1 的两个订阅都匹配，也只推送一次
+	if n := len(subs.match("a/b")); n != 2 {
+		t.Errorf("want 2, actual %d", n)
+	}
+	if n := len(subs.match("a/b/c")); n != 1 {
+		t.Errorf("want 1, actual %d", n)
+	}
+
+	subs.remove("a/b", s2)
+	if n := len(subs.match("a/b")); n != 1 {
+		t.Errorf("want 1, actual %d", n)
+	}
+	subs.removeSession(s1)
+	if n := len(subs.match("a/b")); n != 0 {
+		t.Errorf("want 0, actual %d", n)
+	}
+	if len(subs.filters) != 0 {
+		t.Errorf("want empty table, actual %v", subs.filters)
+	}
+}
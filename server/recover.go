@@ -0,0 +1,94 @@
+package server
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/packet"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+/* 写入预写日志的 Submit 记录格式：
+身份长度(2字节，大端) + 客户端身份 + 连接流水号长度(1字节) + 连接流水号 + Submit 包（packet.Encode 的结果）
+记录客户端身份和连接流水号，是为了崩溃恢复重放时 handler 仍能拿到 Principal。
+Submit.ID 不是 8 字节（连接协商了 packet.CapLongIDs）时，连接流水号长度的最高位置 1，Submit 包按变长 ID 编码
+*/
+
+// walLongIDs 为连接流水号长度中表示 Submit 包按变长 ID 编码的标志位
+const walLongIDs = 0x80
+
+func encodeWALRecord(sess *Session, submit *packet.Submit) ([]byte, error) {
+	var name string
+	if principal := sess.Principal(); principal != nil {
+		name = principal.Name
+	}
+	if len(name) > 0xFFFF || len(sess.id) >= walLongIDs {
+		return nil, errors.New("principal or conn id too long")
+	}
+	idLen := byte(len(sess.id))
+	opts := packet.Options{LongIDs: len(submit.ID) != 8}
+	if opts.LongIDs {
+		idLen |= walLongIDs
+	}
+	p, err := packet.EncodeWith(submit, opts)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 2+len(name)+1+len(sess.id)+len(p))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(name)))
+	buf = append(buf, name...)
+	buf = append(buf, idLen)
+	buf = append(buf, sess.id...)
+	return append(buf, p...), nil
+}
+
+func decodeWALRecord(data []byte) (name string, connID string, submit *packet.Submit, err error) {
+	if len(data) < 2 {
+		return "", "", nil, errors.New("wal record too short")
+	}
+	nameLen := int(binary.BigEndian.Uint16(data[:2]))
+	data = data[2:]
+	if len(data) < nameLen+1 {
+		return "", "", nil, errors.New("wal record too short")
+	}
+	name, data = string(data[:nameLen]), data[nameLen:]
+	idLen := int(data[0] &^ walLongIDs)
+	opts := packet.Options{LongIDs: data[0]&walLongIDs != 0}
+	data = data[1:]
+	if len(data) < idLen {
+		return "", "", nil, errors.New("wal record too short")
+	}
+	connID, data = string(data[:idLen]), data[idLen:]
+	p, err := packet.DecodeWith(data, opts)
+	if err != nil {
+		return "", "", nil, err
+	}
+	submit, ok := p.(*packet.Submit)
+	if !ok {
+		return "", "", nil, fmt.Errorf("unexpected packet %T in wal", p)
+	}
+	return name, connID, submit, nil
+}
+
+// recover 把预写日志中上次进程退出时尚未处理完的 Submit 重新交给 handler。
+// 重放时的 Session 没有底层连接：RemoteAddr 返回 nil，Send 返回错误
+func (s *Server) recover() error {
+	for _, e := range s.WAL.Recovered() {
+		name, connID, submit, err := decodeWALRecord(e.Data)
+		if err != nil {
+			return fmt.Errorf("wal entry %d: %w", e.Index, err)
+		}
+		sess := &Session{srv: s, id: connID, connected: true}
+		if name != "" {
+			sess.principal = &auth.Principal{Name: name}
+		}
+		s.logf(slog.LevelInfo, "recover submit[%s] from wal entry %d", submit.ID, e.Index)
+		s.handle(context.Background(), sess, submit)
+		if err = s.WAL.MarkDone(e.Index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
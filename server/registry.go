@@ -0,0 +1,146 @@
+package server
+
+import (
+	"37_tcp-server-demo1/packet"
+	"cmp"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// sessionStats 为一个连接的流量统计，字节数按帧内容（即编码后的包）计算，不含帧头
+type sessionStats struct {
+	bytesIn      atomic.Uint64
+	bytesOut     atomic.Uint64
+	packetsIn    atomic.Uint64
+	packetsOut   atomic.Uint64
+	lastActivity atomic.Int64 // 最近一次收到包的时间，Unix 纳秒
+}
+
+func (st *sessionStats) received(n int) {
+	st.bytesIn.Add(uint64(n))
+	st.packetsIn.Add(1)
+	st.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (st *sessionStats) sent(n int) {
+	st.bytesOut.Add(uint64(n))
+	st.packetsOut.Add(1)
+}
+
+// SessionInfo 为一个连接的信息和统计，由 GET /sessions 和管理接口返回
+type SessionInfo struct {
+	Key          uint64    `json:"key"` // 服务端分配的唯一编号，管理接口用它指定连接
+	ID           string    `json:"id"`  // 客户端在 Conn 包中携带的连接流水号，不保证唯一
+	RemoteAddr   string    `json:"remote_addr"`
+	Principal    string    `json:"principal,omitempty"`
+	PeerPID      int32     `json:"peer_pid,omitempty"` // Unix domain socket 对端进程的 pid 和 uid
+	PeerUID      *uint32   `json:"peer_uid,omitempty"`
+	Version      uint8     `json:"version"`
+	Caps         uint32    `json:"caps"`
+	Window       uint32    `json:"window"`
+	Inflight     int64     `json:"inflight"` // 已经收到、尚未应答的 Submit 数量
+	ConnectedAt  time.Time `json:"connected_at"`
+	LastActivity time.Time `json:"last_activity"`
+	BytesIn      uint64    `json:"bytes_in"`
+	BytesOut     uint64    `json:"bytes_out"`
+	PacketsIn    uint64    `json:"packets_in"`
+	PacketsOut   uint64    `json:"packets_out"`
+}
+
+// Key 返回服务端为连接分配的唯一编号，从 1 开始递增；不在注册表中的 Session 为 0
+func (s *Session) Key() uint64 {
+	return s.key
+}
+
+// Info 返回连接当前的信息
+func (s *Session) Info() SessionInfo {
+	s.imu.RLock()
+	defer s.imu.RUnlock()
+	info := SessionInfo{
+		Key:          s.key,
+		ID:           s.id,
+		Version:      s.version,
+		Caps:         s.caps,
+		Window:       s.window.Load(),
+		Inflight:     s.inflight.Load(),
+		ConnectedAt:  s.created,
+		LastActivity: s.created,
+		BytesIn:      s.stats.bytesIn.Load(),
+		BytesOut:     s.stats.bytesOut.Load(),
+		PacketsIn:    s.stats.packetsIn.Load(),
+		PacketsOut:   s.stats.packetsOut.Load(),
+	}
+	if last := s.stats.lastActivity.Load(); last != 0 {
+		info.LastActivity = time.Unix(0, last)
+	}
+	if addr := s.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	if principal := s.principalLocked(); principal != nil {
+		info.Principal = principal.Name
+	}
+	if s.peer != nil {
+		info.PeerPID, info.PeerUID = s.peer.PID, &s.peer.UID
+	}
+	return info
+}
+
+// Close 强制断开连接，正在处理的 Submit 的 context 会被取消
+func (s *Session) Close() error {
+	if s.conn == nil {
+		return errors.New("session has no connection")
+	}
+	return s.conn.Close()
+}
+
+// Push 向这一个连接推送一条 Publish 消息，不经过订阅表；客户端按自己的订阅分发
+func (s *Session) Push(topic string, payload []byte) error {
+	if err := packet.ValidateTopic(topic); err != nil {
+		return err
+	}
+	return s.Send(packet.NewPublish(s.srv.subs.nextID(), topic, payload))
+}
+
+// Sessions 返回当前所有连接（包括 WebSocket 网关的连接），按 Key 即连接建立的先后排序
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	slices.SortFunc(sessions, func(a, b *Session) int { return cmp.Compare(a.key, b.key) })
+	return sessions
+}
+
+// Session 按 Key 查找一个当前连接
+func (s *Server) Session(key uint64) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[key]
+	return sess, ok
+}
+
+// track 把连接加入会话注册表并分配唯一的 Key，服务端已经关闭时返回 false
+func (s *Server) track(sess *Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	if s.sessions == nil {
+		s.sessions = make(map[uint64]*Session)
+	}
+	s.nextKey++
+	sess.key = s.nextKey
+	s.sessions[sess.key] = sess
+	return true
+}
+
+func (s *Server) untrack(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sess.key)
+}
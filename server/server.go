@@ -0,0 +1,286 @@
+package server
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/transport"
+	"37_tcp-server-demo1/wal"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrServerClosed = errors.New("server closed")
+
+// shutdownPollInterval 为 Shutdown 检查连接是否已经全部断开的间隔
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Handler 处理客户端发来的 Submit 请求，返回值作为 SubmitAck 的 Result。
+// 同一个连接上的多个 Submit 会被并发处理；ctx 在 Submit 的截止时间到达、客户端发送 Cancel
+// 或者连接关闭时被取消，耗时的 handler 应当及时检查 ctx 并返回（例如 ResultCanceled）
+type Handler func(ctx context.Context, sess *Session, submit *packet.Submit) uint8
+
+// ReplyHandler 与 Handler 相同，返回的 []byte 作为 SubmitAck.Payload 发回客户端（例如 RPC 的返回值）
+type ReplyHandler func(ctx context.Context, sess *Session, submit *packet.Submit) (uint8, []byte)
+
+// DefaultHandler 只打印收到的消息并返回成功，与最初的 demo 行为一致
+func DefaultHandler(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+	fmt.Printf("receive submit: id = %s, payload = %s\n", submit.ID, string(submit.Payload))
+	return packet.ResultOK
+}
+
+type Server struct {
+	Addr          string             // 监听地址，如 ":8080"、"unix:///run/app.sock" 或 "unix://@app"（见 transport.ParseAddr）
+	Handler       Handler            // Submit 处理函数，为 nil 时使用 DefaultHandler
+	ReplyHandler  ReplyHandler       // 非空时代替 Handler 处理 Submit
+	Authenticator auth.Authenticator // 为 nil 时不要求客户端认证
+
+	// SigningKey 为与客户端预共享的签名密钥，非空时要求每个 Submit 都携带签名 trailer，
+	// 用于 TLS 在负载均衡处终结时仍能保证端到端的完整性
+	SigningKey []byte
+	// ReplayWindow 为签名中时间戳与服务端当前时间允许的最大偏差，默认 30s
+	ReplayWindow time.Duration
+
+	// DedupSize 和 DedupWindow 控制每个连接的去重缓存：同一连接在 DedupWindow 内
+	// 重复提交的 Submit.ID 直接返回第一次的 SubmitAck。认证过的客户端协商了 packet.CapSessionResume 时，
+	// 缓存按身份和 Conn.ID 共用，断线重连之后重发的 Submit 同样去重。
+	// 默认分别为 1024 条和 1 分钟，DedupSize 小于 0 时关闭去重
+	DedupSize   int
+	DedupWindow time.Duration
+
+	// WAL 非空时，Submit 在调用 handler 之前先写入预写日志，处理完成后再标记完成；
+	// Serve 启动时会先把上次崩溃时尚未处理完的 Submit 重放给 handler
+	WAL *wal.Log
+
+	// TransferDir 为分块传输暂存数据的目录，为空时不接受分块传输。
+	// TransferHandler 处理校验通过的传输内容，为 nil 时内容保存为以 TransferID 命名的文件，
+	// 认证过的客户端的文件在 TransferDir 下的 "@身份" 子目录中，不会覆盖已经存在的文件
+	TransferDir     string
+	TransferHandler TransferHandler
+
+	// PublishQueueSize 为每个订阅连接等待推送的消息数上限，默认 256。
+	// 推送在每个连接自己的 goroutine 中写入，队列写满（客户端处理不过来）时断开该连接，不影响发布者和其他订阅者
+	PublishQueueSize int
+
+	// Window 为每个连接同时等待应答的 Submit 数量上限，在 ConnAck 中通告给客户端，
+	// 运行中可以通过 Session.SetWindow 调整。超出窗口的 Submit 直接返回 ResultNoCredit，0 表示不限制
+	Window uint32
+
+	// FrameCodec 为每个连接创建帧编解码器（见 frame.ParseBinaryCodec），为 nil 时使用 frame.NewMyFrameCodec
+	FrameCodec func() frame.StreamFrameCodec
+
+	// CheckOrigin 检查 WebSocket 网关收到的握手请求的 Origin，为 nil 时只允许同源（见 ws.Upgrader）
+	CheckOrigin func(r *http.Request) bool
+
+	// DisableCaps 为不与客户端协商的能力（packet.CapXXX），默认开启 packet.SupportedCaps 中的所有能力
+	DisableCaps uint32
+
+	// Logger 为服务端日志的输出，为 nil 时以文本格式输出到标准输出。
+	// LogLevel 为输出日志的最低级别，零值为 slog.LevelInfo，运行中可以调整（见 AdminHandler）
+	Logger   *slog.Logger
+	LogLevel slog.LevelVar
+
+	// Socket 为 Addr 是文件系统 Unix domain socket 时 socket 文件的权限
+	Socket transport.SocketOptions
+
+	// TrustedProxies 非空时开启 PROXY 协议（v1/v2）：来自这些地址的连接（即负载均衡）必须以 PROXY 头开始，
+	// Session.RemoteAddr 为头中携带的客户端地址；其他地址的连接不解析 PROXY 头。
+	// HTTP 网关收到来自这些地址的请求时，客户端地址为 X-Forwarded-For 的最后一项。
+	// ProxyHeaderTimeout 为等待 PROXY 头的时间，默认 5s
+	TrustedProxies     []netip.Prefix
+	ProxyHeaderTimeout time.Duration
+
+	// AdminToken 非空时，管理接口要求请求携带 Authorization: Bearer <AdminToken>
+	AdminToken string
+
+	reloader reloader
+
+	acl              atomic.Pointer[ACL]
+	deniedConns      atomic.Uint64
+	deniedPrincipals atomic.Uint64
+
+	recoverOnce sync.Once
+	recoverErr  error
+
+	subs      subscriptions
+	transfers transfers
+	dedups    dedupSessions
+
+	mu           sync.Mutex
+	listener     net.Listener
+	sessions     map[uint64]*Session // 会话注册表：Session.Key -> Session
+	nextKey      uint64
+	httpSessions map[*Session]struct{} // HTTP 网关正在处理的请求
+	httpInflight map[string]int        // HTTP 网关每个身份正在处理的请求数
+	closed       bool
+}
+
+func NewServer(addr string, handler Handler) *Server {
+	return &Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+}
+
+func (s *Server) newFrameCodec() frame.StreamFrameCodec {
+	if s.FrameCodec == nil {
+		return frame.NewMyFrameCodec()
+	}
+	return s.FrameCodec()
+}
+
+// ListenAndServe 监听 s.Addr 并开始处理连接
+func (s *Server) ListenAndServe() error {
+	l, err := transport.Listen(s.Addr, s.Socket)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 在 l 上接受连接，每个连接一个 goroutine，直到 l 出错或 Close 被调用
+func (s *Server) Serve(l net.Listener) error {
+	if err := s.ensureRecovered(); err != nil {
+		l.Close()
+		return err
+	}
+	if len(s.TrustedProxies) > 0 {
+		l = transport.NewProxyListener(l, s.TrustedProxies, s.ProxyHeaderTimeout)
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept() // 建立 net.Conn 连接
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn 处理一个连接。开启 PROXY 协议时取得客户端地址需要先读出 PROXY 头，
+// 所以访问控制的检查放在连接自己的 goroutine 中，不阻塞 Accept
+func (s *Server) serveConn(conn net.Conn) {
+	s.serveSession(newSession(s, conn))
+}
+
+// serveSession 检查访问控制、加入会话注册表之后处理一个连接，TCP、Unix domain socket 和 WebSocket 连接都经过这里
+func (s *Server) serveSession(sess *Session) {
+	if !sess.admit() || !s.track(sess) {
+		sess.conn.Close()
+		return
+	}
+	defer s.untrack(sess)
+	defer s.subs.removeSession(sess)
+	sess.serve()
+}
+
+// Close 关闭监听并断开所有连接
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for _, sess := range s.sessions {
+		sess.cancel() // 正在 drain 的连接也不再等待 handler
+		sess.conn.Close()
+	}
+	for sess := range s.httpSessions {
+		sess.cancel()
+	}
+	return err
+}
+
+// Shutdown 优雅地关闭服务端：关闭监听，已有的连接不再读取新的请求，等正在处理的 Submit 应答之后断开
+// （见 Session.drain）。所有连接都断开后返回；ctx 先结束时调用 Close 断开剩下的连接并返回 ctx.Err()。
+// 用于升级时把连接交给新进程（见 transport.Upgrade），客户端断开后重连到新进程
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for _, sess := range s.sessions {
+		sess.drain()
+	}
+	for sess := range s.httpSessions {
+		sess.cancel() // HTTP 请求没有重连，直接取消，调用方按结果重试
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		remaining := len(s.sessions)
+		s.mu.Unlock()
+		if remaining == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.logf(slog.LevelWarn, "shutdown: closing %d connections still in flight: %v", remaining, ctx.Err())
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) replayWindow() time.Duration {
+	if w := s.settings().ReplayWindow; w > 0 {
+		return w
+	}
+	return 30 * time.Second
+}
+
+// newDedupCache 按配置创建一个连接的去重缓存，关闭去重时返回 nil
+func (s *Server) newDedupCache() *dedupCache {
+	size, window := s.DedupSize, s.DedupWindow
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = 1024
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	return newDedupCache(size, window)
+}
+
+// handle 调用 ReplyHandler 或 Handler 处理一个 Submit
+func (s *Server) handle(ctx context.Context, sess *Session, submit *packet.Submit) (uint8, []byte) {
+	if s.ReplyHandler != nil {
+		return s.ReplyHandler(ctx, sess, submit)
+	}
+	if s.Handler == nil {
+		return DefaultHandler(ctx, sess, submit), nil
+	}
+	return s.Handler(ctx, sess, submit), nil
+}
@@ -0,0 +1,1286 @@
+package server
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/transport"
+	"37_tcp-server-demo1/wal"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer 在随机端口上启动 srv，测试结束时自动关闭
+func startServer(t *testing.T, srv *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+// roundTrip 发送一个包并读取一个响应包
+func roundTrip(t *testing.T, conn net.Conn, p packet.Packet) (packet.Packet, error) {
+	codec := frame.NewMyFrameCodec()
+	framePayload, err := packet.Encode(p)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if err = codec.Encode(conn, framePayload); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	ackFramePayload, err := codec.Decode(conn)
+	if err != nil {
+		return nil, err
+	}
+	return packet.Decode(ackFramePayload)
+}
+
+func TestServer_Submit(t *testing.T) {
+	addr := startServer(t, NewServer("", nil))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 未开启认证时，不握手也可以直接提交
+	p, err := roundTrip(t, conn, packet.NewSubmit("00000001", []byte("hello")))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	submitAck, ok := p.(*packet.SubmitAck)
+	if !ok || submitAck.ID != "00000001" || submitAck.Result != packet.ResultOK {
+		t.Errorf("want ok submitAck[00000001], actual %v", p)
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	var principal *auth.Principal
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		principal = sess.Principal()
+		return packet.ResultOK
+	})
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	addr := startServer(t, srv)
+
+	// case 1: 令牌错误，返回 ResultAuthFailed 并断开连接
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	p, err := roundTrip(t, conn, packet.NewConn("00000001", []byte("wrong")))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if connAck, ok := p.(*packet.ConnAck); !ok || connAck.Result != packet.ResultAuthFailed {
+		t.Errorf("want auth failed connAck, actual %v", p)
+	}
+	if _, err = frame.NewMyFrameCodec().Decode(conn); err == nil {
+		t.Errorf("want connection closed, actual nil")
+	}
+
+	// case 2: 未握手直接提交
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	p, err = roundTrip(t, conn2, packet.NewSubmit("00000001", []byte("hello")))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if submitAck, ok := p.(*packet.SubmitAck); !ok || submitAck.Result != packet.ResultAuthFailed {
+		t.Errorf("want auth failed submitAck, actual %v", p)
+	}
+
+	// case 3: 令牌正确，handler 能拿到客户端身份
+	conn3, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn3.Close()
+	p, err = roundTrip(t, conn3, packet.NewConn("00000001", []byte("secret")))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if connAck, ok := p.(*packet.ConnAck); !ok || connAck.Result != packet.ResultOK {
+		t.Fatalf("want ok connAck, actual %v", p)
+	}
+	p, err = roundTrip(t, conn3, packet.NewSubmit("00000002", []byte("hello")))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if submitAck, ok := p.(*packet.SubmitAck); !ok || submitAck.Result != packet.ResultOK {
+		t.Errorf("want ok submitAck, actual %v", p)
+	}
+	if principal == nil || principal.Name != "alice" {
+		t.Errorf("want alice, actual %v", principal)
+	}
+}
+
+func TestServer_Signing(t *testing.T) {
+	secret := []byte("signing-secret")
+	srv := NewServer("", nil)
+	srv.SigningKey = secret
+	addr := startServer(t, srv)
+
+	// case 1: 未协商签名，握手失败
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	p, err := roundTrip(t, conn, packet.NewConn("00000001", nil))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if connAck, ok := p.(*packet.ConnAck); !ok || connAck.Result != packet.ResultBadSignature {
+		t.Errorf("want bad signature connAck, actual %v", p)
+	}
+
+	// case 2: 正常签名、重放以及篡改
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	clientNonce := make([]byte, packet.NonceLen)
+	p, err = roundTrip(t, conn2, &packet.Conn{ID: "00000001", Nonce: clientNonce})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	connAck, ok := p.(*packet.ConnAck)
+	if !ok || connAck.Result != packet.ResultOK || connAck.Nonce == nil {
+		t.Fatalf("want ok connAck with nonce, actual %v", p)
+	}
+	key := packet.DeriveSessionKey(secret, "00000001", clientNonce, connAck.Nonce)
+
+	signed := packet.SignSubmit(key, packet.NewSubmit("00000002", []byte("hello")), 1, time.Now())
+	wantResult := func(submit *packet.Submit, result uint8) {
+		t.Helper()
+		p, err := roundTrip(t, conn2, submit)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if submitAck, ok := p.(*packet.SubmitAck); !ok || submitAck.Result != result {
+			t.Errorf("want submitAck result %d, actual %v", result, p)
+		}
+	}
+	wantResult(signed, packet.ResultOK)
+	wantResult(signed, packet.ResultReplay) // 原样重放
+	wantResult(packet.SignSubmit(key, packet.NewSubmit("00000003", []byte("hello")), 2, time.Now().Add(-time.Hour)), packet.ResultReplay)
+	wantResult(packet.SignSubmit([]byte("wrong"), packet.NewSubmit("00000004", []byte("hello")), 3, time.Now()), packet.ResultBadSignature)
+	wantResult(packet.SignSubmit(key, packet.NewSubmit("00000005", []byte("hello")), 3, time.Now()), packet.ResultOK)
+}
+
+func TestServer_Dedup(t *testing.T) {
+	calls := 0
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		calls++
+		return packet.ResultError
+	})
+	addr := startServer(t, srv)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 同一个 ID 提交两次，handler 只被调用一次，两次返回相同的结果
+	for i := 0; i < 2; i++ {
+		p, err := roundTrip(t, conn, packet.NewSubmit("00000001", []byte("hello")))
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if submitAck, ok := p.(*packet.SubmitAck); !ok || submitAck.Result != packet.ResultError {
+			t.Errorf("want error submitAck, actual %v", p)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("want 1, actual %d", calls)
+	}
+
+	// 关闭去重后每次都会调用 handler
+	srv2 := NewServer("", srv.Handler)
+	srv2.DedupSize = -1
+	conn2, err := net.Dial("tcp", startServer(t, srv2))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	for i := 0; i < 2; i++ {
+		if _, err = roundTrip(t, conn2, packet.NewSubmit("00000001", []byte("hello"))); err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+	}
+	if calls != 3 {
+		t.Errorf("want 3, actual %d", calls)
+	}
+}
+
+func TestServer_DedupSessionResume(t *testing.T) {
+	var calls atomic.Int32
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		calls.Add(1)
+		return packet.ResultError
+	})
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	addr := startServer(t, srv)
+	srv2 := NewServer("", srv.Handler)
+	addr2 := startServer(t, srv2)
+
+	// submit 用 sessionID 握手并提交一次，返回协商出的能力
+	submit := func(addr, sessionID, token string) uint32 {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		defer conn.Close()
+		p, err := roundTrip(t, conn, &packet.Conn{ID: sessionID, Token: []byte(token), Versions: packet.SupportedVersions, Caps: packet.CapSessionResume})
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		connAck, ok := p.(*packet.ConnAck)
+		if !ok || connAck.Result != packet.ResultOK {
+			t.Fatalf("want ok connAck, actual %v", p)
+		}
+		p, err = roundTrip(t, conn, packet.NewSubmit("00000001", []byte("hello")))
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if submitAck, ok := p.(*packet.SubmitAck); !ok || submitAck.Result != packet.ResultError {
+			t.Errorf("want error submitAck, actual %v", p)
+		}
+		return connAck.Caps
+	}
+
+	// case 1: 断线重连后用同一个会话 ID 重发，handler 只被调用一次
+	if caps := submit(addr, "sess0001", "secret"); caps&packet.CapSessionResume == 0 {
+		t.Errorf("want CapSessionResume, actual caps %d", caps)
+	}
+	submit(addr, "sess0001", "secret")
+	if calls.Load() != 1 {
+		t.Errorf("want 1, actual %d", calls.Load())
+	}
+
+	// case 2: 不同的会话 ID 各自去重
+	submit(addr, "sess0002", "secret")
+	if calls.Load() != 2 {
+		t.Errorf("want 2, actual %d", calls.Load())
+	}
+
+	// case 3: 未开启认证时不同意接续会话，每个连接使用自己的缓存
+	if caps := submit(addr2, "sess0001", ""); caps&packet.CapSessionResume != 0 {
+		t.Errorf("want no CapSessionResume, actual caps %d", caps)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("want 3, actual %d", calls.Load())
+	}
+}
+
+func TestServer_DedupSessionRetry(t *testing.T) {
+	var calls atomic.Int32
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{}, 2)
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		calls.Add(1)
+		entered <- struct{}{}
+		select {
+		case <-release:
+			return packet.ResultOK
+		case <-ctx.Done():
+			return packet.ResultCanceled
+		}
+	})
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	addr := startServer(t, srv)
+
+	codec := frame.NewMyFrameCodec()
+	dial := func() net.Conn {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if _, err = roundTrip(t, conn, &packet.Conn{ID: "sess0001", Token: []byte("secret"), Versions: packet.SupportedVersions, Caps: packet.CapSessionResume}); err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		return conn
+	}
+	send := func(conn net.Conn, p packet.Packet) {
+		framePayload, _ := packet.Encode(p)
+		if err := codec.Encode(conn, framePayload); err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+	}
+	recv := func(conn net.Conn) uint8 {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		framePayload, err := codec.Decode(conn)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		p, err := packet.Decode(framePayload)
+		submitAck, ok := p.(*packet.SubmitAck)
+		if err != nil || !ok {
+			t.Fatalf("want submitAck, actual %v %v", p, err)
+		}
+		return submitAck.Result
+	}
+
+	// case 1: 旧连接的 handler 还在处理时，新连接上的重发等它的结果，handler 只被调用一次
+	conn1 := dial()
+	defer conn1.Close()
+	send(conn1, packet.NewSubmit("00000001", []byte("hello")))
+	<-entered
+	conn2 := dial()
+	defer conn2.Close()
+	send(conn2, packet.NewSubmit("00000001", []byte("hello")))
+	time.Sleep(50 * time.Millisecond)
+	release <- struct{}{}
+	if result := recv(conn1); result != packet.ResultOK {
+		t.Errorf("want %d, actual %d", packet.ResultOK, result)
+	}
+	if result := recv(conn2); result != packet.ResultOK {
+		t.Errorf("want %d, actual %d", packet.ResultOK, result)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("want 1, actual %d", calls.Load())
+	}
+
+	// case 2: 连接关闭时被取消的 Submit 不记入去重缓存，重连后重发会重新处理
+	conn3 := dial()
+	send(conn3, packet.NewSubmit("00000002", []byte("hello")))
+	<-entered
+	conn3.Close()
+	conn4 := dial()
+	defer conn4.Close()
+	send(conn4, packet.NewSubmit("00000002", []byte("hello")))
+	<-entered
+	release <- struct{}{}
+	if result := recv(conn4); result != packet.ResultOK {
+		t.Errorf("want %d, actual %d", packet.ResultOK, result)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("want 3, actual %d", calls.Load())
+	}
+}
+
+func TestServer_WALRecover(t *testing.T) {
+	dir := t.TempDir()
+	log, err := wal.Open(dir, wal.Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	// 模拟上次进程写完日志、还没处理完就崩溃了
+	sess := &Session{id: "00000001", principal: &auth.Principal{Name: "alice"}}
+	record, err := encodeWALRecord(sess, packet.NewSubmit("00000002", []byte("hello")))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	log.Append(record)
+	// 协商了 CapLongIDs 的连接上的 Submit，ID 不是 8 字节
+	record, err = encodeWALRecord(sess, packet.NewSubmit("long-submit-id", []byte("again")))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	log.Append(record)
+	// 未开启认证时 Unix domain socket 连接以对端 uid 作为身份，恢复后仍然是这个身份
+	peerSess := &Session{id: "00000004", peer: &transport.PeerCred{UID: 1000}}
+	peerSess.auth.Store(&Settings{})
+	record, err = encodeWALRecord(peerSess, packet.NewSubmit("00000005", []byte("peer")))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	log.Append(record)
+	log.Close()
+
+	log, err = wal.Open(dir, wal.Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer log.Close()
+	var recovered []string
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		var name string
+		if sess.Principal() != nil {
+			name = sess.Principal().Name
+		}
+		recovered = append(recovered, name+":"+submit.ID+":"+string(submit.Payload))
+		return packet.ResultOK
+	})
+	srv.WAL = log
+	conn, err := net.Dial("tcp", startServer(t, srv))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 重放在开始接受连接之前完成；新的 Submit 也会先写日志，处理完后不再是未完成状态
+	if _, err = roundTrip(t, conn, packet.NewSubmit("00000003", []byte("world"))); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	want := []string{"alice:00000002:hello", "alice:long-submit-id:again", "uid:1000:00000005:peer", ":00000003:world"}
+	if strings.Join(recovered, " ") != strings.Join(want, " ") {
+		t.Errorf("want %v, actual %v", want, recovered)
+	}
+	if n := log.Pending(); n != 0 {
+		t.Errorf("want 0, actual %d", n)
+	}
+}
+
+func TestServer_SubmitBatch(t *testing.T) {
+	var payloads []string
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		payloads = append(payloads, string(submit.Payload))
+		if string(submit.Payload) == "bad" {
+			return packet.ResultError
+		}
+		return packet.ResultOK
+	})
+	addr := startServer(t, srv)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 第三条与第一条 ID 相同，按去重规则直接返回第一条的结果
+	batch := packet.NewSubmitBatch("00000001", []*packet.Submit{
+		packet.NewSubmit("00000002", []byte("hello")),
+		packet.NewSubmit("00000003", []byte("bad")),
+		packet.NewSubmit("00000002", []byte("hello")),
+		packet.NewSubmit("00000004", []byte("world")),
+	})
+	p, err := roundTrip(t, conn, batch)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	batchAck, ok := p.(*packet.SubmitBatchAck)
+	if !ok || batchAck.ID != "00000001" || batchAck.Result != packet.ResultOK {
+		t.Fatalf("want ok submitBatchAck[00000001], actual %v", p)
+	}
+	want := []uint8{packet.ResultOK, packet.ResultError, packet.ResultOK, packet.ResultOK}
+	if string(batchAck.Results) != string(want) {
+		t.Errorf("want %v, actual %v", want, batchAck.Results)
+	}
+	if len(payloads) != 3 || payloads[2] != "world" {
+		t.Errorf("want [hello bad world], actual %v", payloads)
+	}
+
+	// 未认证时整个批次被拒绝
+	srv2 := NewServer("", nil)
+	srv2.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	conn2, err := net.Dial("tcp", startServer(t, srv2))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	p, err = roundTrip(t, conn2, batch)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if batchAck, ok := p.(*packet.SubmitBatchAck); !ok || batchAck.Result != packet.ResultAuthFailed || len(batchAck.Results) != 0 {
+		t.Errorf("want auth failed submitBatchAck, actual %v", p)
+	}
+}
+
+func TestServer_Cancel(t *testing.T) {
+	errs := make(chan error, 3)
+	entered := make(chan struct{}, 3)
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		entered <- struct{}{}
+		<-ctx.Done()
+		errs <- ctx.Err()
+		return packet.ResultCanceled
+	})
+	addr := startServer(t, srv)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	codec := frame.NewMyFrameCodec()
+	send := func(p packet.Packet) {
+		framePayload, _ := packet.Encode(p)
+		if err := codec.Encode(conn, framePayload); err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+	}
+
+	// case 1: 处理过程中收到 Cancel
+	send(packet.NewSubmit("00000001", []byte("hello")))
+	<-entered
+	p, err := roundTrip(t, conn, packet.NewCancel("00000001"))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if submitAck, ok := p.(*packet.SubmitAck); !ok || submitAck.ID != "00000001" || submitAck.Result != packet.ResultCanceled {
+		t.Errorf("want canceled submitAck[00000001], actual %v", p)
+	}
+	if err = <-errs; err != context.Canceled {
+		t.Errorf("want context.Canceled, actual %v", err)
+	}
+
+	// case 2: 超过截止时间
+	submit := &packet.Submit{ID: "00000002", Payload: []byte("hello"), Deadline: time.Now().Add(50 * time.Millisecond)}
+	if _, err = roundTrip(t, conn, submit); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	<-entered
+	if err = <-errs; err != context.DeadlineExceeded {
+		t.Errorf("want context.DeadlineExceeded, actual %v", err)
+	}
+
+	// case 3: 已经过了截止时间的 Submit 不会调用 handler
+	submit = &packet.Submit{ID: "00000003", Payload: []byte("hello"), Deadline: time.Now().Add(-time.Second)}
+	p, err = roundTrip(t, conn, submit)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if submitAck, ok := p.(*packet.SubmitAck); !ok || submitAck.Result != packet.ResultCanceled {
+		t.Errorf("want canceled submitAck, actual %v", p)
+	}
+
+	// case 4: 连接关闭
+	send(packet.NewSubmit("00000004", []byte("hello")))
+	<-entered
+	conn.Close()
+	select {
+	case err = <-errs:
+		if err != context.Canceled {
+			t.Errorf("want context.Canceled, actual %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("want handler canceled on connection close, actual still running")
+	}
+	if len(entered) != 0 {
+		t.Errorf("want handler not called for expired submit, actual called")
+	}
+}
+
+func TestServer_ReplyHandler(t *testing.T) {
+	calls := 0
+	srv := NewServer("", nil)
+	srv.ReplyHandler = func(ctx context.Context, sess *Session, submit *packet.Submit) (uint8, []byte) {
+		calls++
+		return packet.ResultOK, append([]byte("echo "), submit.Payload...)
+	}
+	conn, err := net.Dial("tcp", startServer(t, srv))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 重发的 Submit 从去重缓存中拿到相同的 Payload
+	for i := 0; i < 2; i++ {
+		p, err := roundTrip(t, conn, packet.NewSubmit("00000001", []byte("hello")))
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if submitAck, ok := p.(*packet.SubmitAck); !ok || string(submitAck.Payload) != "echo hello" {
+			t.Errorf("want payload echo hello, actual %v", p)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("want 1, actual %d", calls)
+	}
+}
+
+func TestServer_Version(t *testing.T) {
+	addr := startServer(t, NewServer("", nil))
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 没有共同支持的版本
+	p, err := roundTrip(t, conn, &packet.Conn{ID: "00000001", Versions: []uint8{9}})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if connAck, ok := p.(*packet.ConnAck); !ok || connAck.Result != packet.ResultBadVersion {
+		t.Errorf("want result %d, actual %v", packet.ResultBadVersion, p)
+	}
+
+	// 只支持 ProtocolVersion1 的客户端不会开启任何能力，之后的帧格式不变
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	p, err = roundTrip(t, conn2, &packet.Conn{ID: "00000001", Versions: []uint8{packet.ProtocolVersion1}, Caps: packet.CapChecksum})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if connAck, ok := p.(*packet.ConnAck); !ok || connAck.Version != packet.ProtocolVersion1 || connAck.Caps != 0 {
+		t.Errorf("want version 1 without caps, actual %v", p)
+	}
+	if _, err = roundTrip(t, conn2, packet.NewSubmit("00000002", []byte("hello"))); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+}
+
+func TestServer_HTTPSubmit(t *testing.T) {
+	var calls atomic.Int64
+	srv := NewServer("", nil)
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	srv.ReplyHandler = func(ctx context.Context, sess *Session, submit *packet.Submit) (uint8, []byte) {
+		calls.Add(1)
+		if sess.Principal() == nil || sess.Principal().Name != "alice" {
+			return packet.ResultError, nil
+		}
+		return packet.ResultOK, append([]byte("echo:"), submit.Payload...)
+	}
+	gateway := httptest.NewServer(srv.HTTPHandler())
+	defer gateway.Close()
+
+	post := func(token, body string) (int, map[string]any) {
+		req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/submit", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		defer resp.Body.Close()
+		var v map[string]any
+		json.NewDecoder(resp.Body).Decode(&v)
+		return resp.StatusCode, v
+	}
+
+	status, v := post("secret", `{"id": "00000001", "payload": "hello"}`)
+	if status != http.StatusOK || v["id"] != "00000001" || v["result"] != float64(packet.ResultOK) {
+		t.Errorf("want ok, actual %d %v", status, v)
+	}
+	if v["payload"] != base64.StdEncoding.EncodeToString([]byte("echo:hello")) {
+		t.Errorf("want echo:hello, actual %v", v["payload"])
+	}
+	// 相同的 id 重试时直接返回第一次的结果
+	post("secret", `{"id": "00000001", "payload": "hello"}`)
+	if calls.Load() != 1 {
+		t.Errorf("want 1 handler call, actual %d", calls.Load())
+	}
+	// 不带 id 时由服务端生成
+	if status, v = post("secret", `{"payload_base64": "AAEC"}`); status != http.StatusOK || len(v["id"].(string)) != 8 {
+		t.Errorf("want generated id, actual %d %v", status, v)
+	}
+
+	if status, v = post("wrong", `{"payload": "hello"}`); status != http.StatusUnauthorized || v["result"] != float64(packet.ResultAuthFailed) {
+		t.Errorf("want unauthorized, actual %d %v", status, v)
+	}
+	for _, body := range []string{`{"id": "short"}`, `not json`, `{"unknown": 1}`, `{"payload": "a", "payload_base64": "AA=="}`} {
+		if status, _ = post("secret", body); status != http.StatusBadRequest {
+			t.Errorf("%s: want %d, actual %d", body, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestServer_HTTPSubmitLimits(t *testing.T) {
+	var calls atomic.Int32
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{}, 2)
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		calls.Add(1)
+		entered <- struct{}{}
+		select {
+		case <-release:
+			return packet.ResultOK
+		case <-ctx.Done():
+			return packet.ResultCanceled
+		}
+	})
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	srv.Window = 1
+	srv.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	gateway := httptest.NewServer(srv.HTTPHandler())
+	defer gateway.Close()
+
+	post := func(body, forwardedFor string) (int, map[string]any) {
+		req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/submit", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		defer resp.Body.Close()
+		var v map[string]any
+		json.NewDecoder(resp.Body).Decode(&v)
+		return resp.StatusCode, v
+	}
+	type response struct {
+		status int
+		v      map[string]any
+	}
+	postAsync := func(body string) chan response {
+		ch := make(chan response, 1)
+		go func() {
+			status, v := post(body, "")
+			ch <- response{status, v}
+		}()
+		return ch
+	}
+
+	// case 1: 与 TCP 连接一样检查来源网络和身份的网络限制，经过反向代理时按 X-Forwarded-For 检查
+	srv.SetACL(&ACL{Deny: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}})
+	if status, v := post(`{"payload": "hello"}`, ""); status != http.StatusForbidden || v["result"] != float64(packet.ResultAuthFailed) {
+		t.Errorf("want forbidden, actual %d %v", status, v)
+	}
+	srv.SetACL(&ACL{Principals: map[string][]netip.Prefix{"alice": {netip.MustParsePrefix("10.0.0.0/8")}}})
+	if status, _ := post(`{"payload": "hello"}`, "192.168.0.1"); status != http.StatusForbidden {
+		t.Errorf("want %d, actual %d", http.StatusForbidden, status)
+	}
+	if stats := srv.ACLStats(); stats.DeniedConnections != 1 || stats.DeniedPrincipals != 1 {
+		t.Errorf("want 1 denied connection and 1 denied principal, actual %+v", stats)
+	}
+	release <- struct{}{}
+	if status, _ := post(`{"payload": "hello"}`, "192.168.0.1, 10.0.0.1"); status != http.StatusOK {
+		t.Errorf("want %d, actual %d", http.StatusOK, status)
+	}
+	<-entered
+	srv.SetACL(nil)
+
+	// case 2: 同一个 id 的请求还在处理时，重试等第一次的结果，handler 只被调用一次
+	srv.Window = 0
+	first := postAsync(`{"id": "00000001", "payload": "hello"}`)
+	<-entered
+	second := postAsync(`{"id": "00000001", "payload": "hello"}`)
+	time.Sleep(50 * time.Millisecond)
+	release <- struct{}{}
+	for _, ch := range []chan response{first, second} {
+		if r := <-ch; r.status != http.StatusOK || r.v["result"] != float64(packet.ResultOK) {
+			t.Errorf("want ok, actual %d %v", r.status, r.v)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("want 2, actual %d", calls.Load())
+	}
+
+	// case 3: 同一身份同时处理的请求数受窗口限制
+	srv.Window = 1
+	first = postAsync(`{"payload": "hello"}`)
+	<-entered
+	if status, v := post(`{"payload": "hello"}`, ""); status != http.StatusTooManyRequests || v["result"] != float64(packet.ResultNoCredit) {
+		t.Errorf("want too many requests, actual %d %v", status, v)
+	}
+
+	// case 4: Close 取消正在处理的请求
+	srv.Close()
+	if r := <-first; r.status != http.StatusOK || r.v["result"] != float64(packet.ResultCanceled) {
+		t.Errorf("want canceled, actual %d %v", r.status, r.v)
+	}
+	if status, _ := post(`{"payload": "hello"}`, ""); status != http.StatusServiceUnavailable {
+		t.Errorf("want %d, actual %d", http.StatusServiceUnavailable, status)
+	}
+}
+
+func TestServer_HTTPSubmitAnonymous(t *testing.T) {
+	gateway := httptest.NewServer(NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		return packet.ResultOK
+	}).HTTPHandler())
+	defer gateway.Close()
+
+	// 未开启认证时所有调用方共用去重缓存，不接受调用方指定的 id
+	resp, err := http.Post(gateway.URL+"/submit", "application/json", strings.NewReader(`{"id": "00000001", "payload": "hello"}`))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("want %d, actual %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp, err = http.Post(gateway.URL+"/submit", "application/json", strings.NewReader(`{"payload": "hello"}`))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("want %d, actual %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestServer_HTTPSessions(t *testing.T) {
+	srv := NewServer("", nil)
+	addr := startServer(t, srv)
+	gateway := httptest.NewServer(srv.HTTPHandler())
+	defer gateway.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	if _, err = roundTrip(t, conn, &packet.Conn{ID: "00000001", Versions: packet.SupportedVersions}); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+
+	resp, err := http.Get(gateway.URL + "/sessions")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer resp.Body.Close()
+	var infos []SessionInfo
+	if err = json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if len(infos) != 1 || infos[0].ID != "00000001" || infos[0].Version != packet.ProtocolVersion2 || infos[0].RemoteAddr != conn.LocalAddr().String() {
+		t.Errorf("want one session, actual %+v", infos)
+	}
+}
+
+func TestServer_Admin(t *testing.T) {
+	srv := NewServer("", nil)
+	srv.AdminToken = "admin"
+	addr := startServer(t, srv)
+	admin := httptest.NewServer(srv.AdminHandler())
+	defer admin.Close()
+
+	do := func(method, path, body string) (int, []byte) {
+		req, _ := http.NewRequest(method, admin.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, data
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	if _, err = roundTrip(t, conn, &packet.Conn{ID: "00000001"}); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if _, err = roundTrip(t, conn, packet.NewSubmit("00000002", []byte("hello"))); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+
+	// case 1: 列出连接及统计
+	status, data := do(http.MethodGet, "/sessions", "")
+	var infos []SessionInfo
+	if err = json.Unmarshal(data, &infos); status != http.StatusOK || err != nil {
+		t.Fatalf("want ok, actual %d %s", status, data)
+	}
+	if len(infos) != 1 || infos[0].ID != "00000001" || infos[0].PacketsIn != 2 || infos[0].PacketsOut != 2 || infos[0].BytesIn == 0 {
+		t.Errorf("want one session with 2 packets in and 2 out, actual %+v", infos)
+	}
+	key := strconv.FormatUint(infos[0].Key, 10)
+
+	// case 2: 推送消息
+	if status, data = do(http.MethodPost, "/sessions/"+key+"/push", `{"topic": "notice", "payload": "hi"}`); status != http.StatusNoContent {
+		t.Errorf("want %d, actual %d %s", http.StatusNoContent, status, data)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	framePayload, err := frame.NewMyFrameCodec().Decode(conn)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	p, _ := packet.Decode(framePayload)
+	if publish, ok := p.(*packet.Publish); !ok || publish.Topic != "notice" || string(publish.Payload) != "hi" {
+		t.Errorf("want publish[notice], actual %v", p)
+	}
+	if status, _ = do(http.MethodPost, "/sessions/"+key+"/push", `{"topic": "a/#/b"}`); status != http.StatusBadRequest {
+		t.Errorf("want %d, actual %d", http.StatusBadRequest, status)
+	}
+
+	// case 3: 调整日志级别
+	if status, data = do(http.MethodPut, "/loglevel", `{"level": "debug"}`); status != http.StatusOK || srv.LogLevel.Level() != slog.LevelDebug {
+		t.Errorf("want debug, actual %d %s", status, data)
+	}
+	if status, _ = do(http.MethodPut, "/loglevel", `{"level": "verbose"}`); status != http.StatusBadRequest {
+		t.Errorf("want %d, actual %d", http.StatusBadRequest, status)
+	}
+
+	// case 4: 强制断开
+	if status, _ = do(http.MethodDelete, "/sessions/"+key, ""); status != http.StatusNoContent {
+		t.Errorf("want %d, actual %d", http.StatusNoContent, status)
+	}
+	if _, err = frame.NewMyFrameCodec().Decode(conn); err == nil {
+		t.Errorf("want connection closed, actual nil")
+	}
+	if status, _ = do(http.MethodGet, "/sessions/12345", ""); status != http.StatusNotFound {
+		t.Errorf("want %d, actual %d", http.StatusNotFound, status)
+	}
+
+	// case 5: 令牌错误
+	resp, err := http.Get(admin.URL + "/sessions")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("want %d, actual %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestServer_Unix(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are linux only")
+	}
+	principals := make(chan *auth.Principal, 1)
+	srv := NewServer("unix://"+filepath.Join(t.TempDir(), "server.sock"), func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		principals <- sess.Principal()
+		return packet.ResultOK
+	})
+	srv.Socket = transport.SocketOptions{Mode: 0600}
+	go srv.ListenAndServe()
+	t.Cleanup(func() { srv.Close() })
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ { // 等待服务端开始监听
+		if conn, err = transport.Dial(srv.Addr, time.Second); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	p, err := roundTrip(t, conn, packet.NewSubmit("00000001", []byte("hello")))
+	if submitAck, ok := p.(*packet.SubmitAck); err != nil || !ok || submitAck.Result != packet.ResultOK {
+		t.Fatalf("want ok submitAck, actual %v %v", p, err)
+	}
+	// 未开启认证时，以对端进程的 uid 作为身份
+	want := fmt.Sprintf("uid:%d", os.Getuid())
+	if principal := <-principals; principal == nil || principal.Name != want {
+		t.Errorf("want %s, actual %v", want, principal)
+	}
+	if sessions := srv.Sessions(); len(sessions) != 1 || sessions[0].PeerCred() == nil || sessions[0].PeerCred().PID != int32(os.Getpid()) {
+		t.Errorf("want peer pid %d, actual %v", os.Getpid(), sessions)
+	}
+}
+
+func TestServer_ProxyProtocol(t *testing.T) {
+	addrs := make(chan string, 1)
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		addrs <- sess.RemoteAddr().String()
+		return packet.ResultOK
+	})
+	srv.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	addr := startServer(t, srv)
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 203.0.113.7 127.0.0.1 51000 8080\r\n"))
+	p, err := roundTrip(t, conn, packet.NewSubmit("00000001", []byte("hello")))
+	if submitAck, ok := p.(*packet.SubmitAck); err != nil || !ok || submitAck.Result != packet.ResultOK {
+		t.Fatalf("want ok submitAck, actual %v %v", p, err)
+	}
+	if actual := <-addrs; actual != "203.0.113.7:51000" {
+		t.Errorf("want 203.0.113.7:51000, actual %s", actual)
+	}
+
+	// 可信来源不带 PROXY 头时断开连接
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	if _, err = roundTrip(t, conn2, packet.NewSubmit("00000002", []byte("hello"))); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
+
+func TestServer_ACL(t *testing.T) {
+	srv := NewServer("", nil)
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	srv.SetACL(&ACL{Deny: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}})
+	addr := startServer(t, srv)
+
+	// case 1: 来源网络被拒绝，连接在读取任何帧之前被关闭
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("want connection closed, actual nil")
+	}
+	conn.Close()
+	if stats := srv.ACLStats(); stats.DeniedConnections != 1 {
+		t.Errorf("want 1 denied connection, actual %+v", stats)
+	}
+
+	// case 2: 运行中替换规则，alice 只能从 10.0.0.0/8 连接
+	srv.SetACL(&ACL{Principals: map[string][]netip.Prefix{"alice": {netip.MustParsePrefix("10.0.0.0/8")}}})
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	p, err := roundTrip(t, conn, &packet.Conn{ID: "00000001", Token: []byte("secret")})
+	if connAck, ok := p.(*packet.ConnAck); err != nil || !ok || connAck.Result != packet.ResultAuthFailed {
+		t.Errorf("want auth failed connAck, actual %v %v", p, err)
+	}
+	if stats := srv.ACLStats(); stats.DeniedPrincipals != 1 {
+		t.Errorf("want 1 denied principal, actual %+v", stats)
+	}
+
+	// case 3: 放开之后可以正常握手
+	srv.SetACL(nil)
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	p, err = roundTrip(t, conn2, &packet.Conn{ID: "00000002", Token: []byte("secret")})
+	if connAck, ok := p.(*packet.ConnAck); err != nil || !ok || connAck.Result != packet.ResultOK {
+		t.Errorf("want ok connAck, actual %v %v", p, err)
+	}
+}
+
+func TestServer_Reload(t *testing.T) {
+	srv := NewServer("", nil)
+	srv.Window = 8
+	addr := startServer(t, srv)
+
+	// 未开启认证时建立的连接
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	p, err := roundTrip(t, conn, packet.NewConn("00000001", nil))
+	if connAck, ok := p.(*packet.ConnAck); err != nil || !ok || connAck.Result != packet.ResultOK || connAck.Window != 8 {
+		t.Fatalf("want ok connAck with window 8, actual %v %v", p, err)
+	}
+
+	// case 1: 加载失败，保留原来的设置
+	if err = srv.Reload(func() (*Settings, error) { return nil, fmt.Errorf("bad config") }); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	if stats := srv.ReloadStats(); stats.Failed != 1 || stats.Succeeded != 0 || stats.LastError != "bad config" {
+		t.Errorf("want 1 failed reload, actual %+v", stats)
+	}
+
+	// case 2: 替换窗口、日志级别和认证方式
+	err = srv.Reload(func() (*Settings, error) {
+		return &Settings{
+			Window:        16,
+			Authenticator: auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"}),
+			LogLevel:      slog.LevelWarn,
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if stats := srv.ReloadStats(); stats.Succeeded != 1 || stats.LastError != "" {
+		t.Errorf("want 1 succeeded reload, actual %+v", stats)
+	}
+	if level := srv.LogLevel.Level(); level != slog.LevelWarn {
+		t.Errorf("want WARN, actual %s", level)
+	}
+	// 已有的连接收到 WindowUpdate，并且不受新的认证方式影响
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	framePayload, err := frame.NewMyFrameCodec().Decode(conn)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if p, _ = packet.Decode(framePayload); p == nil || p.(*packet.WindowUpdate).Window != 16 {
+		t.Errorf("want window update 16, actual %v", p)
+	}
+	p, err = roundTrip(t, conn, packet.NewSubmit("00000002", []byte("hello")))
+	if submitAck, ok := p.(*packet.SubmitAck); err != nil || !ok || submitAck.Result != packet.ResultOK {
+		t.Errorf("want ok submitAck, actual %v %v", p, err)
+	}
+
+	// 新连接需要认证
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	p, err = roundTrip(t, conn2, packet.NewConn("00000003", []byte("wrong")))
+	if connAck, ok := p.(*packet.ConnAck); err != nil || !ok || connAck.Result != packet.ResultAuthFailed {
+		t.Errorf("want auth failed connAck, actual %v %v", p, err)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		if string(submit.Payload) == "slow" {
+			close(started)
+			<-release
+		}
+		return packet.ResultOK
+	})
+	addr := startServer(t, srv)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	codec := frame.NewMyFrameCodec()
+	framePayload, _ := packet.Encode(packet.NewSubmit("00000001", []byte("slow")))
+	if err = codec.Encode(conn, framePayload); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	<-started
+
+	// case 1: 正在处理的 Submit 应答之后连接才断开，期间不再接受新连接
+	done := make(chan error, 1)
+	go func() { done <- srv.Shutdown(context.Background()) }()
+	time.Sleep(100 * time.Millisecond)
+	if c, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		c.Close()
+		t.Errorf("want listener closed, actual nil")
+	}
+	close(release)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	ackFramePayload, err := codec.Decode(conn)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if p, _ := packet.Decode(ackFramePayload); p == nil || p.(*packet.SubmitAck).Result != packet.ResultOK {
+		t.Errorf("want ok submitAck, actual %v", p)
+	}
+	if _, err = codec.Decode(conn); err == nil {
+		t.Errorf("want connection closed, actual nil")
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("want nil, actual %s", err.Error())
+		}
+	case <-time.After(time.Second):
+		t.Errorf("want shutdown finished, actual timeout")
+	}
+
+	// case 2: ctx 结束时直接断开剩下的连接
+	srv2 := NewServer("", func(ctx context.Context, sess *Session, submit *packet.Submit) uint8 {
+		<-ctx.Done()
+		return packet.ResultCanceled
+	})
+	conn2, err := net.Dial("tcp", startServer(t, srv2))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn2.Close()
+	if err = codec.Encode(conn2, framePayload); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = srv2.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want deadline exceeded, actual %v", err)
+	}
+}
+
+func TestServer_PublishSlowSubscriber(t *testing.T) {
+	srv := NewServer("", nil)
+	srv.PublishQueueSize = 4
+	addr := startServer(t, srv)
+
+	// 订阅之后不再读取的连接
+	slow, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer slow.Close()
+	p, err := roundTrip(t, slow, packet.NewSubscribe("00000001", "news"))
+	if ack, ok := p.(*packet.SubscribeAck); err != nil || !ok || ack.Result != packet.ResultOK {
+		t.Fatalf("want ok subscribeAck, actual %v %v", p, err)
+	}
+
+	// 发布不会被慢的订阅者阻塞，写不进去的消息堆满队列后该连接被断开
+	payload := make([]byte, 64<<10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			srv.Publish("news", payload)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("want publish not blocked, actual timeout")
+	}
+	if n, _ := srv.Publish("news", payload); n != 0 {
+		t.Errorf("want slow subscriber removed, actual %d", n)
+	}
+}
+
+func TestSession_AccessorsDuringHandshake(t *testing.T) {
+	srv := NewServer("", nil)
+	srv.Authenticator = auth.NewStaticTokenAuthenticator(map[string]string{"secret": "alice"})
+	addr := startServer(t, srv)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	var sess *Session
+	for deadline := time.Now().Add(time.Second); sess == nil && time.Now().Before(deadline); {
+		if sessions := srv.Sessions(); len(sessions) == 1 {
+			sess = sessions[0]
+		}
+	}
+	if sess == nil {
+		t.Fatalf("want 1 session, actual 0")
+	}
+
+	// 管理接口等其他 goroutine 在握手的同时读取握手得到的字段（配合 -race 检查）
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				sess.ID()
+				sess.Principal()
+				sess.Version()
+				sess.Caps()
+			}
+		}
+	}()
+	p, err := roundTrip(t, conn, packet.NewConn("00000001", []byte("secret")))
+	close(stop)
+	<-done
+	if connAck, ok := p.(*packet.ConnAck); err != nil || !ok || connAck.Result != packet.ResultOK {
+		t.Fatalf("want ok connAck, actual %v %v", p, err)
+	}
+	if sess.ID() != "00000001" || sess.Principal() == nil || sess.Principal().Name != "alice" {
+		t.Errorf("want 00000001 alice, actual %s %v", sess.ID(), sess.Principal())
+	}
+}
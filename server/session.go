@@ -0,0 +1,486 @@
+package server
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/frame"
+	"37_tcp-server-demo1/packet"
+	"37_tcp-server-demo1/transport"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session 表示服务端的一个客户端连接
+type Session struct {
+	srv        *Server
+	conn       net.Conn
+	remote     net.Addr // HTTP 网关的请求没有连接，为请求的客户端地址
+	frameCodec frame.StreamFrameCodec
+	packetOpts packet.Options // 协商出的包编码方式，与 frameCodec 一起在握手之后替换
+
+	wmu sync.Mutex // 保证同一时刻只有一个 goroutine 往连接里写帧
+
+	// 以下字段在握手时由 serve 所在的 goroutine 写入一次，其他 goroutine 读取时需要持有 imu
+	imu       sync.RWMutex
+	connected bool                // 是否已经完成 Conn/ConnAck 握手
+	id        string              // Conn 包中的连接流水号
+	principal *auth.Principal     // 认证通过后的客户端身份，未认证时为 nil
+	peer      *transport.PeerCred // Unix domain socket 对端进程的凭据，其他连接为 nil
+
+	version uint8  // 协商出的协议版本，客户端未携带版本时为 packet.ProtocolVersion1
+	caps    uint32 // 协商出的能力
+
+	// auth 为连接建立时的设置，握手时替换为当时的设置，之后 Reload 替换的认证设置不影响这个连接
+	auth    atomic.Pointer[Settings]
+	signKey []byte // 握手时派生出的会话签名密钥，为 nil 表示不校验签名
+	lastSeq uint64 // 最近一次通过校验的签名 Seq，用于拒绝重放
+
+	dedup    *dedupCache // 按 Submit.ID 去重，为 nil 表示不去重
+	dedupKey string      // 非空时 dedup 为客户端会话共用的缓存（见 Server.dedups）
+
+	window   atomic.Uint32 // 通告给客户端的窗口，0 表示不限制
+	inflight atomic.Int64  // 已经收到、尚未应答的 Submit 数量
+	draining atomic.Bool   // Server.Shutdown 时置位：不再读取新的请求，等正在处理的 Submit 应答之后断开
+
+	// Submit 在单独的 goroutine 中处理，读 goroutine 可以继续接收 Cancel。
+	// ctx 在连接关闭时取消，每个请求的 context 都由它派生
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	cmu    sync.Mutex
+	calls  map[string]context.CancelFunc // 正在处理的 Submit/SubmitBatch 的 ID -> 取消函数
+
+	transfers map[string]*os.File // 当前连接正在进行的分块传输 -> 暂存文件
+
+	// pushes 为等待推送给这个连接的订阅消息，第一次订阅时创建，由 pushLoop 写入连接（见 Server.Publish）
+	pushOnce sync.Once
+	pushes   chan packet.Packet
+
+	key     uint64       // 会话注册表中的唯一编号，在 serve 之前分配
+	created time.Time    // 连接建立的时间
+	stats   sessionStats // 流量统计
+}
+
+func newSession(srv *Server, conn net.Conn) *Session {
+	s := &Session{
+		srv:        srv,
+		conn:       conn,
+		frameCodec: srv.newFrameCodec(),
+		version:    packet.ProtocolVersion1,
+		dedup:      srv.newDedupCache(),
+		calls:      make(map[string]context.CancelFunc),
+		created:    time.Now(),
+	}
+	if conn != nil {
+		s.peer, _ = transport.ReadPeerCred(conn)
+	}
+	st := srv.settings()
+	s.auth.Store(st)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.window.Store(st.Window)
+	return s
+}
+
+// ID 返回客户端在 Conn 包中携带的连接流水号，未握手时为空
+func (s *Session) ID() string {
+	s.imu.RLock()
+	defer s.imu.RUnlock()
+	return s.id
+}
+
+// Principal 返回认证通过后的客户端身份。服务端未开启认证时，Unix domain socket 连接以对端进程的
+// uid 作为身份（Name 为 "uid:1000" 的形式），其他连接为 nil
+func (s *Session) Principal() *auth.Principal {
+	s.imu.RLock()
+	defer s.imu.RUnlock()
+	return s.principalLocked()
+}
+
+// principalLocked 与 Principal 相同，调用方需要持有 imu
+func (s *Session) principalLocked() *auth.Principal {
+	if s.principal == nil && s.peer != nil && s.auth.Load().Authenticator == nil {
+		return &auth.Principal{Name: fmt.Sprintf("uid:%d", s.peer.UID)}
+	}
+	return s.principal
+}
+
+// PeerCred 返回 Unix domain socket 对端进程的凭据（SO_PEERCRED），其他连接返回 nil
+func (s *Session) PeerCred() *transport.PeerCred {
+	return s.peer
+}
+
+// Version 返回握手时协商出的协议版本
+func (s *Session) Version() uint8 {
+	s.imu.RLock()
+	defer s.imu.RUnlock()
+	return s.version
+}
+
+// Caps 返回握手时协商出的能力（packet.CapXXX）
+func (s *Session) Caps() uint32 {
+	s.imu.RLock()
+	defer s.imu.RUnlock()
+	return s.caps
+}
+
+// RemoteAddr 返回客户端地址，从预写日志重放的 Session 没有连接，返回 nil
+func (s *Session) RemoteAddr() net.Addr {
+	if s.conn == nil {
+		return s.remote
+	}
+	return s.conn.RemoteAddr()
+}
+
+// Send 向客户端发送一个包，可以被多个 goroutine 并发调用
+func (s *Session) Send(p packet.Packet) error {
+	if s.conn == nil {
+		return errors.New("session has no connection")
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	framePayload, err := packet.EncodeWith(p, s.packetOpts)
+	if err != nil {
+		return err
+	}
+	if err = s.frameCodec.Encode(s.conn, framePayload); err != nil {
+		return err
+	}
+	s.stats.sent(len(framePayload))
+	return nil
+}
+
+// SetWindow 调整客户端同时等待应答的 Submit 数量上限，并通过 WindowUpdate 通知客户端。
+// 缩小窗口时已经发出的 Submit 不受影响，0 表示不限制
+func (s *Session) SetWindow(n uint32) error {
+	s.window.Store(n)
+	return s.Send(packet.NewWindowUpdate(n))
+}
+
+// acquireCredit 为一个 Submit 占用窗口，客户端超出窗口时返回 false
+func (s *Session) acquireCredit() bool {
+	inflight := s.inflight.Add(1)
+	if w := s.window.Load(); w > 0 && inflight > int64(w) {
+		s.inflight.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (s *Session) releaseCredit() {
+	s.inflight.Add(-1)
+}
+
+// serve 循环读取客户端发来的帧并处理，直到连接出错或被关闭
+func (s *Session) serve() {
+	defer s.conn.Close()
+	defer s.closeTransfers()
+	defer s.releaseDedup()
+	defer s.wg.Wait() // 等待正在处理的 Submit 结束，它们的 context 已经被取消
+	defer s.cancel()
+	for {
+		// 从输入流中读出 framePayLoad 数据（[]byte）
+		framePayload, err := s.frameCodec.Decode(s.conn)
+		if err != nil {
+			if s.draining.Load() {
+				// 读超时是 drain 设置的，先等正在处理的 Submit 把应答发出去，再取消 ctx 并断开
+				s.srv.logf(slog.LevelDebug, "draining %s, %d submits in flight", s.RemoteAddr(), s.inflight.Load())
+				s.wg.Wait()
+				return
+			}
+			level := slog.LevelWarn
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				level = slog.LevelDebug // 客户端断开或连接被关闭
+			}
+			s.srv.logf(level, "error decoding frame from %s: %v", s.RemoteAddr(), err)
+			return
+		}
+		s.stats.received(len(framePayload))
+		p, err := packet.DecodeWith(framePayload, s.packetOpts)
+		if err != nil {
+			s.srv.logf(slog.LevelWarn, "error decoding packet from %s: %v", s.RemoteAddr(), err)
+			return
+		}
+		// 解析出的包交给 handlePacket 处理，并得到响应包
+		ack, err := s.handlePacket(p)
+		if ack != nil {
+			if err := s.Send(ack); err != nil {
+				s.srv.logf(slog.LevelWarn, "error encoding ack packet: %v", err)
+				return
+			}
+		}
+		if err != nil {
+			s.srv.logf(slog.LevelWarn, "error handling packet: %v", err)
+			return
+		}
+	}
+}
+
+// drain 让 serve 停止读取新的请求：已经读到的请求照常处理，读 goroutine 等它们应答之后关闭连接。
+// 读到一半的帧被丢弃，客户端会看到连接断开，重连后重试
+func (s *Session) drain() {
+	s.draining.Store(true)
+	s.conn.SetReadDeadline(time.Now())
+}
+
+// handlePacket 处理一个客户端请求包，返回需要回给客户端的响应包。
+// 返回 error 时连接会被关闭（如果同时返回了响应包，会先把响应发出去）
+func (s *Session) handlePacket(p packet.Packet) (packet.Packet, error) {
+	switch t := p.(type) {
+	case *packet.Conn:
+		return s.handleConn(t)
+	case *packet.Submit:
+		if err := s.checkConnected(); err != nil {
+			return packet.NewSubmitAck(t.ID, packet.ResultAuthFailed), err
+		}
+		return s.startSubmit(t)
+	case *packet.SubmitBatch:
+		if err := s.checkConnected(); err != nil {
+			return packet.NewSubmitBatchAck(t.ID, packet.ResultAuthFailed, nil), err
+		}
+		if s.version >= packet.ProtocolVersion2 && s.caps&packet.CapBatching == 0 {
+			// 协商过能力的客户端只有开启了批量发送才能发送 SubmitBatch
+			return packet.NewSubmitBatchAck(t.ID, packet.ResultError, nil), nil
+		}
+		return s.startSubmitBatch(t)
+	case *packet.Cancel:
+		if err := s.checkConnected(); err != nil {
+			return nil, err
+		}
+		s.cancelCall(t.ID)
+		return nil, nil
+	case *packet.Subscribe:
+		if err := s.checkConnected(); err != nil {
+			return packet.NewSubscribeAck(t.ID, packet.ResultAuthFailed), err
+		}
+		if err := packet.ValidateTopicFilter(t.Topic); err != nil {
+			s.srv.logf(slog.LevelWarn, "subscribe[%s] from %s: %v", t.Topic, s.RemoteAddr(), err)
+			return packet.NewSubscribeAck(t.ID, packet.ResultError), nil
+		}
+		s.startPushLoop()
+		s.srv.subs.add(t.Topic, s)
+		return packet.NewSubscribeAck(t.ID, packet.ResultOK), nil
+	case *packet.Unsubscribe:
+		if err := s.checkConnected(); err != nil {
+			return packet.NewUnsubscribeAck(t.ID, packet.ResultAuthFailed), err
+		}
+		s.srv.subs.remove(t.Topic, s)
+		return packet.NewUnsubscribeAck(t.ID, packet.ResultOK), nil
+	case *packet.Publish:
+		if err := s.checkConnected(); err != nil {
+			return packet.NewPublishAck(t.ID, packet.ResultAuthFailed), err
+		}
+		if _, err := s.srv.Publish(t.Topic, t.Payload); err != nil {
+			s.srv.logf(slog.LevelWarn, "publish[%s] from %s: %v", t.Topic, s.RemoteAddr(), err)
+			return packet.NewPublishAck(t.ID, packet.ResultError), nil
+		}
+		return packet.NewPublishAck(t.ID, packet.ResultOK), nil
+	case *packet.TransferBegin:
+		if err := s.checkConnected(); err != nil {
+			return &packet.TransferBeginAck{ID: t.ID, Result: packet.ResultAuthFailed}, err
+		}
+		return s.handleTransferBegin(t), nil
+	case *packet.TransferChunk:
+		if err := s.checkConnected(); err != nil {
+			return &packet.TransferChunkAck{ID: t.ID, Result: packet.ResultAuthFailed}, err
+		}
+		return s.handleTransferChunk(t), nil
+	case *packet.TransferEnd:
+		if err := s.checkConnected(); err != nil {
+			return &packet.TransferEndAck{ID: t.ID, Result: packet.ResultAuthFailed}, err
+		}
+		return s.handleTransferEnd(t), nil
+	default:
+		return nil, fmt.Errorf("unknwon packet type")
+	}
+}
+
+// checkConnected 开启认证后，必须先完成握手才能发送其他请求
+func (s *Session) checkConnected() error {
+	if s.auth.Load().Authenticator != nil && !s.connected {
+		return errors.New("request before authentication")
+	}
+	return nil
+}
+
+func (s *Session) handleConn(c *packet.Conn) (packet.Packet, error) {
+	if s.connected {
+		return nil, errors.New("duplicate conn packet")
+	}
+	st := s.srv.settings() // 握手使用当前的设置，并在握手成功后保存到连接上
+	var principal *auth.Principal
+	if st.Authenticator != nil {
+		var err error
+		principal, err = st.Authenticator.Authenticate(c.Token)
+		if err != nil {
+			return packet.NewConnAck(c.ID, packet.ResultAuthFailed), fmt.Errorf("authenticate %s: %w", s.conn.RemoteAddr(), err)
+		}
+		if !s.admitPrincipal(principal.Name) {
+			return packet.NewConnAck(c.ID, packet.ResultAuthFailed), fmt.Errorf("principal %s not allowed from %s", principal.Name, s.conn.RemoteAddr())
+		}
+	}
+	connAck := packet.NewConnAck(c.ID, packet.ResultOK)
+	version, caps := uint8(packet.ProtocolVersion1), uint32(0)
+	if c.Versions != nil {
+		var ok bool
+		version, ok = packet.NegotiateVersion(c.Versions, packet.SupportedVersions)
+		if !ok {
+			connAck.Result = packet.ResultBadVersion
+			return connAck, fmt.Errorf("client %s: no mutual protocol version in %v", s.conn.RemoteAddr(), c.Versions)
+		}
+		caps = packet.NegotiateCaps(version, c.Caps, ^s.srv.DisableCaps)
+		if principal == nil || s.dedup == nil {
+			// 未认证的连接无法确认会话属于谁，不允许接续其他连接的去重缓存
+			caps &^= packet.CapSessionResume
+		}
+		connAck.Version, connAck.Caps = version, caps
+	}
+	if st.SigningKey != nil {
+		// 服务端要求签名，客户端必须在 Conn 中携带随机数
+		if c.Nonce == nil {
+			connAck.Result = packet.ResultBadSignature
+			return connAck, fmt.Errorf("client %s did not negotiate signing", s.conn.RemoteAddr())
+		}
+		connAck.Nonce = make([]byte, packet.NonceLen)
+		if _, err := rand.Read(connAck.Nonce); err != nil {
+			return nil, err
+		}
+		s.signKey = packet.DeriveSessionKey(st.SigningKey, c.ID, c.Nonce, connAck.Nonce)
+	}
+	if caps&packet.CapSessionResume != 0 {
+		// 开启认证时握手之前不处理 Submit，这里替换去重缓存不会与 handleSubmit 并发
+		s.dedupKey = dedupSessionKey(principal.Name, c.ID)
+		s.dedup = s.srv.dedups.acquire(s.dedupKey, time.Now(), s.srv.newDedupCache)
+	}
+	// 其他 goroutine（例如 Info）可能同时读取握手的结果
+	s.imu.Lock()
+	s.connected, s.id, s.principal = true, c.ID, principal
+	s.version, s.caps = version, caps
+	s.imu.Unlock()
+	s.auth.Store(st)
+	connAck.Window = s.window.Load()
+	// ConnAck 仍按协商前的格式发送，之后的帧才按协商出的能力编解码
+	if err := s.Send(connAck); err != nil {
+		return nil, err
+	}
+	s.setCodec(frame.NewTransformCodec(s.frameCodec, frameOptions(s.caps)), packet.OptionsFromCaps(s.caps))
+	return nil, nil
+}
+
+// setCodec 替换帧编解码器和包编码方式。读帧只发生在 serve 所在的 goroutine，写帧都持有 wmu
+func (s *Session) setCodec(codec frame.StreamFrameCodec, opts packet.Options) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.frameCodec, s.packetOpts = codec, opts
+}
+
+func frameOptions(caps uint32) frame.Options {
+	return frame.Options{
+		Compress: caps&packet.CapCompression != 0,
+		Checksum: caps&packet.CapChecksum != 0,
+	}
+}
+
+// releaseDedup 在连接关闭时归还客户端会话共用的去重缓存
+func (s *Session) releaseDedup() {
+	if s.dedupKey != "" {
+		s.srv.dedups.release(s.dedupKey, time.Now())
+	}
+}
+
+// handleSubmit 调用 handler 处理 Submit；重复的 ID 直接返回第一次处理的结果，
+// 同一个 ID 正在其他连接上处理时等它的结果，
+// 排队期间已经被取消或超过截止时间的 Submit 不再调用 handler。
+// 返回值为 SubmitAck 的 Result 和 Payload
+func (s *Session) handleSubmit(ctx context.Context, submit *packet.Submit) (uint8, []byte) {
+	if s.dedup == nil {
+		result, reply, _ := s.invoke(ctx, submit)
+		return result, reply
+	}
+	for {
+		result, reply, ok, wait := s.dedup.claim(submit.ID, time.Now())
+		if ok {
+			s.srv.logf(slog.LevelDebug, "duplicate submit[%s] from %s, resend ack", submit.ID, s.RemoteAddr())
+			return result, reply
+		}
+		if wait == nil {
+			break
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return packet.ResultCanceled, nil
+		}
+	}
+	result, reply, done := s.invoke(ctx, submit)
+	s.dedup.finish(submit.ID, result, reply, done, time.Now())
+	return result, reply
+}
+
+// invoke 调用 handler，done 表示 Submit 处理完了、结果可以记入去重缓存。
+// 没有落盘或者被取消（包括连接关闭）的 Submit 没有处理完，客户端重发时应当重新处理
+func (s *Session) invoke(ctx context.Context, submit *packet.Submit) (result uint8, reply []byte, done bool) {
+	if ctx.Err() != nil {
+		return packet.ResultCanceled, nil, false
+	}
+	result, reply, err := s.process(ctx, submit)
+	if err != nil {
+		// handler 没有被调用
+		s.srv.logf(slog.LevelError, "error persisting submit[%s]: %v", submit.ID, err)
+		return packet.ResultError, nil, false
+	}
+	return result, reply, !canceled(ctx, result)
+}
+
+// canceled 判断 handler 是否因为 context 结束而没有处理完
+func canceled(ctx context.Context, result uint8) bool {
+	return result == packet.ResultCanceled || ctx.Err() != nil
+}
+
+// process 调用 handler；开启预写日志时先落盘，写日志失败则不调用 handler 并返回错误
+func (s *Session) process(ctx context.Context, submit *packet.Submit) (uint8, []byte, error) {
+	if s.srv.WAL == nil {
+		result, reply := s.srv.handle(ctx, s, submit)
+		return result, reply, nil
+	}
+	record, err := encodeWALRecord(s, submit)
+	if err != nil {
+		return 0, nil, err
+	}
+	index, err := s.srv.WAL.Append(record)
+	if err != nil {
+		return 0, nil, err
+	}
+	result, reply := s.srv.handle(ctx, s, submit)
+	if canceled(ctx, result) {
+		// 没有处理完，不标记完成，进程崩溃后从日志重放
+		return result, reply, nil
+	}
+	if err = s.srv.WAL.MarkDone(index); err != nil {
+		s.srv.logf(slog.LevelError, "error marking wal entry %d done: %v", index, err)
+	}
+	return result, reply, nil
+}
+
+// verify 校验签名并检查重放：Seq 必须严格递增，时间戳必须落在 ReplayWindow 内
+func (s *Session) verify(submit *packet.Submit) (*packet.Submit, uint8) {
+	verified, seq, ts, err := packet.VerifySubmit(s.signKey, submit)
+	if err != nil {
+		s.srv.logf(slog.LevelWarn, "submit[%s] from %s: %v", submit.ID, s.RemoteAddr(), err)
+		return nil, packet.ResultBadSignature
+	}
+	window := s.srv.replayWindow()
+	if seq <= s.lastSeq || time.Since(ts).Abs() > window {
+		s.srv.logf(slog.LevelWarn, "submit[%s] from %s: replay rejected, seq = %d", submit.ID, s.RemoteAddr(), seq)
+		return nil, packet.ResultReplay
+	}
+	s.lastSeq = seq
+	return verified, packet.ResultOK
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"37_tcp-server-demo1/auth"
+	"37_tcp-server-demo1/packet"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings 为运行中可以替换的设置（见 Server.Reload），零值字段的含义与 Server 中的同名字段相同。
+//   - Window、ReplayWindow、ACL、LogLevel 对已有的连接和新连接都立即生效，
+//     Window 只调整仍在使用原默认窗口的连接，通过 Session.SetWindow 单独调整过的连接不受影响；
+//   - Authenticator、SigningKey 在握手时使用，已经完成握手的连接保持握手时的认证结果和会话密钥
+type Settings struct {
+	Window        uint32
+	Authenticator auth.Authenticator
+	SigningKey    []byte
+	ReplayWindow  time.Duration
+	ACL           *ACL
+	LogLevel      slog.Level
+}
+
+// ReloadStats 为 Reload 的结果统计
+type ReloadStats struct {
+	Succeeded  uint64    `json:"succeeded"`
+	Failed     uint64    `json:"failed"`
+	LastReload time.Time `json:"last_reload,omitempty"` // 最近一次调用 Reload 的时间
+	LastError  string    `json:"last_error,omitempty"`  // 最近一次失败的原因，之后成功时清空
+}
+
+// reloader 保存替换后的设置，以及 Reload 的统计
+type reloader struct {
+	settings atomic.Pointer[Settings] // 为 nil 时使用 Server 中的字段
+
+	mu    sync.Mutex // 保证同一时刻只有一个 Reload
+	stats ReloadStats
+}
+
+// settings 返回当前的设置，调用方在一次处理中应当只取一次，保证看到的各项设置属于同一次 Reload
+func (s *Server) settings() *Settings {
+	if st := s.reloader.settings.Load(); st != nil {
+		return st
+	}
+	return &Settings{
+		Window:        s.Window,
+		Authenticator: s.Authenticator,
+		SigningKey:    s.SigningKey,
+		ReplayWindow:  s.ReplayWindow,
+	}
+}
+
+// Reload 调用 load 取得新的设置并原子地替换当前设置，已有的连接不会断开。
+// load 返回错误时（例如新的配置文件校验失败）保留原来的设置并返回该错误。结果记录在日志和 ReloadStats 中
+func (s *Server) Reload(load func() (*Settings, error)) error {
+	s.reloader.mu.Lock()
+	defer s.reloader.mu.Unlock()
+	s.reloader.stats.LastReload = time.Now()
+	st, err := load()
+	if err != nil {
+		s.reloader.stats.Failed++
+		s.reloader.stats.LastError = err.Error()
+		s.logf(slog.LevelError, "reload failed, keeping the current settings: %v", err)
+		return err
+	}
+
+	old := s.settings()
+	s.reloader.settings.Store(st)
+	s.SetACL(st.ACL)
+	s.LogLevel.Set(st.LogLevel)
+	adjusted := 0
+	if st.Window != old.Window {
+		for _, sess := range s.Sessions() {
+			if sess.window.CompareAndSwap(old.Window, st.Window) {
+				if err := sess.Send(packet.NewWindowUpdate(st.Window)); err != nil {
+					s.logf(slog.LevelDebug, "error sending window update to %s: %v", sess.RemoteAddr(), err)
+				}
+				adjusted++
+			}
+		}
+	}
+	s.reloader.stats.Succeeded++
+	s.reloader.stats.LastError = ""
+	s.logf(slog.LevelInfo, "reloaded settings: window = %d (%d sessions adjusted), log level = %s", st.Window, adjusted, st.LogLevel)
+	return nil
+}
+
+// ReloadStats 返回 Reload 的结果统计
+func (s *Server) ReloadStats() ReloadStats {
+	s.reloader.mu.Lock()
+	defer s.reloader.mu.Unlock()
+	return s.reloader.stats
+}
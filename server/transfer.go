@@ -0,0 +1,221 @@
+package server
+
+import (
+	"37_tcp-server-demo1/packet"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// TransferHandler 处理一次校验通过的分块传输，r 从头读出完整的传输内容，
+// 返回值作为 TransferEndAck 的 Result。handler 返回后临时文件会被删除
+type TransferHandler func(sess *Session, transferID string, r io.Reader, size int64) uint8
+
+// transfers 记录每个正在进行的传输属于哪个连接，同一个传输同一时刻只能有一个连接在写。
+// key 为传输的暂存文件路径，已经按客户端身份区分（见 Session.transferDir）
+type transfers struct {
+	mu     sync.Mutex
+	owners map[string]*Session
+}
+
+func (t *transfers) acquire(id string, sess *Session) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if owner, ok := t.owners[id]; ok && owner != sess {
+		return false
+	}
+	if t.owners == nil {
+		t.owners = make(map[string]*Session)
+	}
+	t.owners[id] = sess
+	return true
+}
+
+func (t *transfers) release(id string, sess *Session) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.owners[id] == sess {
+		delete(t.owners, id)
+	}
+}
+
+// transferDir 返回这个连接的传输所在的目录。TransferID 只在同一个客户端身份内唯一：
+// 认证过的身份使用 TransferDir 下的 "@身份" 子目录（TransferID 不含 @，不会与文件冲突），
+// 其他客户端不能续传、追加或者覆盖它的文件；未认证的连接共用 TransferDir
+func (s *Session) transferDir() string {
+	principal := s.Principal()
+	if principal == nil {
+		return s.srv.TransferDir
+	}
+	return filepath.Join(s.srv.TransferDir, "@"+url.PathEscape(principal.Name))
+}
+
+// partPath 返回传输过程中数据暂存的文件路径。数据直接落盘，
+// 断线重连（甚至服务端重启）之后都可以从文件大小处续传
+func (s *Session) partPath(transferID string) string {
+	return filepath.Join(s.transferDir(), transferID+".part")
+}
+
+func (s *Session) handleTransferBegin(p *packet.TransferBegin) packet.Packet {
+	ack := &packet.TransferBeginAck{ID: p.ID, Result: packet.ResultOK}
+	f, err := s.openTransfer(p.TransferID)
+	if err != nil {
+		s.srv.logf(slog.LevelWarn, "transfer[%s] from %s: %v", p.TransferID, s.RemoteAddr(), err)
+		ack.Result = packet.ResultError
+		return ack
+	}
+	info, err := f.Stat()
+	if err != nil {
+		ack.Result = packet.ResultError
+		return ack
+	}
+	ack.Offset = uint64(info.Size())
+	return ack
+}
+
+func (s *Session) handleTransferChunk(p *packet.TransferChunk) packet.Packet {
+	ack := &packet.TransferChunkAck{ID: p.ID, Result: packet.ResultOK}
+	f, ok := s.transfers[p.TransferID]
+	if !ok {
+		ack.Result = packet.ResultError // 没有先发送 TransferBegin
+		return ack
+	}
+	info, err := f.Stat()
+	if err != nil {
+		ack.Result = packet.ResultError
+		return ack
+	}
+	size := uint64(info.Size())
+	ack.Offset = size
+	switch {
+	case p.Offset+uint64(len(p.Data)) <= size:
+		// 重发的数据块已经写过了，直接确认
+		return ack
+	case p.Offset != size:
+		ack.Result = packet.ResultBadOffset
+		return ack
+	}
+	if _, err = f.Write(p.Data); err != nil {
+		s.srv.logf(slog.LevelWarn, "transfer[%s] from %s: %v", p.TransferID, s.RemoteAddr(), err)
+		ack.Result = packet.ResultError
+		return ack
+	}
+	ack.Offset = size + uint64(len(p.Data))
+	return ack
+}
+
+func (s *Session) handleTransferEnd(p *packet.TransferEnd) packet.Packet {
+	ack := &packet.TransferEndAck{ID: p.ID, Result: packet.ResultOK}
+	if _, ok := s.transfers[p.TransferID]; !ok {
+		ack.Result = packet.ResultError
+		return ack
+	}
+	defer s.closeTransfer(p.TransferID)
+
+	path := s.partPath(p.TransferID)
+	size, digest, err := digestFile(path)
+	if err != nil {
+		s.srv.logf(slog.LevelWarn, "transfer[%s] from %s: %v", p.TransferID, s.RemoteAddr(), err)
+		ack.Result = packet.ResultError
+		return ack
+	}
+	if uint64(size) != p.Size || digest != p.Digest {
+		// 不知道是哪一段数据出错了，只能删掉让客户端从头再传
+		s.srv.logf(slog.LevelWarn, "transfer[%s] from %s: digest mismatch", p.TransferID, s.RemoteAddr())
+		os.Remove(path)
+		ack.Result = packet.ResultDigestMismatch
+		return ack
+	}
+
+	if s.srv.TransferHandler == nil {
+		// 没有 handler 时把完整的内容保存为 transferDir 下以 TransferID 命名的文件。
+		// 用 Link 而不是 Rename，已经存在的文件不会被覆盖
+		err = os.Link(path, filepath.Join(s.transferDir(), p.TransferID))
+		os.Remove(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				err = errors.New("completed file already exists")
+			}
+			s.srv.logf(slog.LevelWarn, "transfer[%s] from %s: %v", p.TransferID, s.RemoteAddr(), err)
+			ack.Result = packet.ResultError
+		}
+		return ack
+	}
+	r, err := os.Open(path)
+	if err != nil {
+		ack.Result = packet.ResultError
+		return ack
+	}
+	defer os.Remove(path)
+	defer r.Close()
+	ack.Result = s.srv.TransferHandler(s, p.TransferID, r, size)
+	return ack
+}
+
+// openTransfer 打开（或新建）传输的暂存文件，并登记为当前连接所有
+func (s *Session) openTransfer(transferID string) (*os.File, error) {
+	if s.srv.TransferDir == "" {
+		return nil, errors.New("transfer disabled")
+	}
+	if err := packet.ValidateTransferID(transferID); err != nil {
+		return nil, err
+	}
+	if f, ok := s.transfers[transferID]; ok {
+		return f, nil
+	}
+	path := s.partPath(transferID)
+	if !s.srv.transfers.acquire(path, s) {
+		return nil, errors.New("transfer in progress on another connection")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		s.srv.transfers.release(path, s)
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		s.srv.transfers.release(path, s)
+		return nil, err
+	}
+	if s.transfers == nil {
+		s.transfers = make(map[string]*os.File)
+	}
+	s.transfers[transferID] = f
+	return f, nil
+}
+
+func (s *Session) closeTransfer(transferID string) {
+	if f, ok := s.transfers[transferID]; ok {
+		f.Close()
+		delete(s.transfers, transferID)
+	}
+	s.srv.transfers.release(s.partPath(transferID), s)
+}
+
+// closeTransfers 在连接关闭时释放所有未完成的传输，暂存文件保留以便续传
+func (s *Session) closeTransfers() {
+	for id := range s.transfers {
+		s.closeTransfer(id)
+	}
+}
+
+func digestFile(path string) (int64, [packet.DigestLen]byte, error) {
+	var digest [packet.DigestLen]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, digest, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, digest, err
+	}
+	copy(digest[:], h.Sum(nil))
+	return n, digest, nil
+}
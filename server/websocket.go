@@ -0,0 +1,28 @@
+package server
+
+import (
+	"37_tcp-server-demo1/ws"
+	"log/slog"
+	"net/http"
+)
+
+// WebSocketHandler 返回 WebSocket 网关：浏览器等无法直接建立 TCP 连接的客户端通过 WebSocket 连接，
+// 每条二进制消息承载一个协议帧，之后的握手、认证、Submit 处理与 TCP 连接完全相同。
+// 只接受 Origin 通过 s.CheckOrigin 检查的请求
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.ensureRecovered(); err != nil {
+			http.Error(w, "server unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		upgrader := ws.Upgrader{CheckOrigin: s.CheckOrigin}
+		conn, err := upgrader.Upgrade(w, r)
+		if err != nil {
+			s.logf(slog.LevelWarn, "websocket upgrade from %s: %v", r.RemoteAddr, err)
+			return
+		}
+		sess := newSession(s, conn)
+		sess.frameCodec = ws.NewFrameCodec()
+		s.serveSession(sess)
+	})
+}
@@ -0,0 +1,115 @@
+package systemd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* 与 systemd 的两个约定，都通过环境变量传入，不由 systemd 启动时全部为空：
+	LISTEN_PID=<pid> LISTEN_FDS=<n> LISTEN_FDNAMES=a:b   socket activation 传入的监听 socket 为文件描述符 3 .. 3+n-1
+	NOTIFY_SOCKET=/run/systemd/notify 或 @abstract        向该 unixgram socket 发送 "READY=1"、"STOPPING=1" 等状态
+	WATCHDOG_USEC=<usec> WATCHDOG_PID=<pid>              需要在这个间隔内发送 "WATCHDOG=1"，否则 systemd 认为服务卡死
+监听 socket 的名字来自 socket unit 的 FileDescriptorName=
+*/
+
+// 常用的通知状态，多个状态可以用换行连接后一次发送
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// listenFDsStart 为 systemd 传入的第一个文件描述符（SD_LISTEN_FDS_START）
+const listenFDsStart = 3
+
+// Listeners 返回 systemd 通过 socket activation 传入的监听 socket，key 为 FileDescriptorName，
+// 没有设置时为 socket unit 的名字。不是由 socket activation 启动（或 LISTEN_PID 不是本进程）时返回空 map。
+// 调用后清除 LISTEN_ 开头的环境变量，之后启动的子进程不会误用它们
+func Listeners() (map[string]net.Listener, error) {
+	listeners := make(map[string]net.Listener)
+	pid, fds, fdNames := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid == "" || pid != strconv.Itoa(os.Getpid()) {
+		return listeners, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	var names []string
+	if fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	var errs []error
+	for i := 0; i < n; i++ {
+		name := "unknown" // 与 sd_listen_fds_with_names 相同
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		l, err := net.FileListener(f)
+		f.Close() // FileListener 复制了文件描述符，复制出的带有 close-on-exec
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listener %s: %w", name, err))
+			continue
+		}
+		if _, ok := listeners[name]; ok {
+			l.Close()
+			errs = append(errs, fmt.Errorf("listener %s: duplicate FileDescriptorName", name))
+			continue
+		}
+		listeners[name] = l
+	}
+	if err = errors.Join(errs...); err != nil {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil, err
+	}
+	return listeners, nil
+}
+
+// Notify 向 NOTIFY_SOCKET 发送状态，NOTIFY_SOCKET 为空（不是由 systemd 以 Type=notify 启动）时返回 false, nil
+func Notify(state string) (bool, error) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return false, nil
+	}
+	if addr[0] != '/' && addr[0] != '@' { // @ 开头为 Linux 抽象命名空间，net 包直接支持
+		return false, fmt.Errorf("unsupported NOTIFY_SOCKET %q", addr)
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval 返回 systemd 要求发送 WATCHDOG=1 的间隔（WatchdogSec=），没有开启或者
+// WATCHDOG_PID 不是本进程时返回 0。调用方通常每隔一半的间隔发送一次
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(usec, 10, 63)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC %q", usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
@@ -0,0 +1,144 @@
+package systemd
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestMain 在 SYSTEMD_TEST_CHILD 非空时扮演由 systemd 启动的服务：LISTEN_PID 只能在子进程启动后确定，
+// 所以由子进程自己补上。取出名为 server 的监听 socket，通知就绪后接受一个连接并回复 "activated"
+func TestMain(m *testing.M) {
+	if os.Getenv("SYSTEMD_TEST_CHILD") == "" {
+		os.Exit(m.Run())
+	}
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	listeners, err := Listeners()
+	if err != nil || listeners["server"] == nil || os.Getenv("LISTEN_FDS") != "" {
+		os.Exit(1)
+	}
+	if ok, err := Notify(Ready); !ok || err != nil {
+		os.Exit(1)
+	}
+	conn, err := listeners["server"].Accept()
+	if err != nil {
+		os.Exit(1)
+	}
+	conn.Write([]byte("activated"))
+	conn.Close()
+	os.Exit(0)
+}
+
+// fakeNotifySocket 创建一个代替 systemd 接收通知的 unixgram socket，并设置 NOTIFY_SOCKET
+func fakeNotifySocket(t *testing.T) *net.UnixConn {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readState(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	return string(buf[:n])
+}
+
+func TestListeners(t *testing.T) {
+	notify := fakeNotifySocket(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer f.Close()
+
+	// 子进程的文件描述符 3 为监听 socket，与 systemd 传入的方式相同
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Env = append(os.Environ(), "SYSTEMD_TEST_CHILD=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=server")
+	if err = cmd.Start(); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer cmd.Wait()
+	if state := readState(t, notify); state != Ready {
+		cmd.Process.Kill()
+		t.Fatalf("want %s, actual %s", Ready, state)
+	}
+	l.Close() // 之后的连接只能由子进程接受
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		cmd.Process.Kill()
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(conn)
+	if err != nil || string(reply) != "activated" {
+		t.Errorf("want activated, actual %q %v", reply, err)
+	}
+}
+
+func TestListeners_NotActivated(t *testing.T) {
+	// LISTEN_PID 不是本进程（例如从父进程继承来的环境变量）时忽略
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := Listeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("want no listeners, actual %v %v", listeners, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Errorf("want LISTEN_FDS unset, actual %s", os.Getenv("LISTEN_FDS"))
+	}
+}
+
+func TestNotify(t *testing.T) {
+	notify := fakeNotifySocket(t)
+	for _, state := range []string{Ready, Watchdog, Stopping} {
+		if ok, err := Notify(state); !ok || err != nil {
+			t.Fatalf("want ok, actual %v %v", ok, err)
+		}
+		if actual := readState(t, notify); actual != state {
+			t.Errorf("want %s, actual %s", state, actual)
+		}
+	}
+
+	t.Setenv("NOTIFY_SOCKET", "")
+	if ok, err := Notify(Ready); ok || err != nil {
+		t.Errorf("want not sent, actual %v %v", ok, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "3000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d, err := WatchdogInterval(); err != nil || d != 3*time.Second {
+		t.Errorf("want 3s, actual %s %v", d, err)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if d, err := WatchdogInterval(); err != nil || d != 0 {
+		t.Errorf("want 0, actual %s %v", d, err)
+	}
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "soon")
+	if _, err := WatchdogInterval(); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
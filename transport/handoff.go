@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* 零停机升级：正在运行的进程（父进程）用相同的命令行参数启动可执行文件的新版本（子进程），
+把监听 socket 作为继承的文件描述符传给它。子进程直接在这些 socket 上接受连接，就绪后通知父进程，
+父进程随后停止接受连接、排空已有连接后退出。监听 socket 始终有进程在 accept，客户端最多看到已有连接断开，
+重连时由子进程接受。父子进程之间通过两个环境变量约定：
+	TRANSPORT_HANDOFF_FDS=name1,name2,...   继承的监听 socket 的名字，第 i 个对应文件描述符 3+i
+	TRANSPORT_HANDOFF_READY=N               子进程就绪后向文件描述符 N 写一个字节并关闭
+*/
+
+const (
+	handoffFDsEnv   = "TRANSPORT_HANDOFF_FDS"
+	handoffReadyEnv = "TRANSPORT_HANDOFF_READY"
+)
+
+// ErrUpgradeFailed 表示子进程没有就绪，父进程应当继续服务
+var ErrUpgradeFailed = errors.New("upgrade failed")
+
+// Inheritance 为子进程从父进程继承的监听 socket，不是由 Upgrade 启动时为空
+type Inheritance struct {
+	listeners map[string]net.Listener
+	ready     *os.File
+}
+
+// Inherit 取出从父进程继承的监听 socket，进程启动时调用一次。
+// 调用后清除约定的环境变量，之后由这个进程启动的子进程不会误用它们
+func Inherit() (*Inheritance, error) {
+	h := &Inheritance{listeners: make(map[string]net.Listener)}
+	names, ok := os.LookupEnv(handoffFDsEnv)
+	if !ok {
+		return h, nil
+	}
+	readyFD := os.Getenv(handoffReadyEnv)
+	os.Unsetenv(handoffFDsEnv)
+	os.Unsetenv(handoffReadyEnv)
+
+	for i, name := range strings.Split(names, ",") {
+		f := os.NewFile(uintptr(3+i), name)
+		l, err := net.FileListener(f)
+		f.Close() // FileListener 复制了文件描述符
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("inherited listener %s: %w", name, err)
+		}
+		h.listeners[name] = l
+	}
+	if fd, err := strconv.Atoi(readyFD); err == nil {
+		h.ready = os.NewFile(uintptr(fd), "ready")
+	}
+	return h, nil
+}
+
+// Listener 返回名为 name 的继承的监听 socket，没有时返回 nil。每个名字只能取一次
+func (h *Inheritance) Listener(name string) net.Listener {
+	l := h.listeners[name]
+	delete(h.listeners, name)
+	return l
+}
+
+// Ready 通知父进程子进程已经开始接受连接，父进程收到后开始排空。不是由 Upgrade 启动时什么也不做
+func (h *Inheritance) Ready() error {
+	if h.ready == nil {
+		return nil
+	}
+	defer func() { h.ready = nil }()
+	_, err := h.ready.Write([]byte{1})
+	if closeErr := h.ready.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Close 关闭没有被取走的继承的监听 socket（例如新的配置关闭了某个监听）
+func (h *Inheritance) Close() error {
+	var errs []error
+	for name, l := range h.listeners {
+		errs = append(errs, l.Close())
+		delete(h.listeners, name)
+	}
+	return errors.Join(errs...)
+}
+
+// Upgrade 以当前进程的命令行参数和环境变量启动可执行文件（重新按路径查找，即部署后的新版本），
+// 把 listeners 按名字传给它（见 Inherit），等待子进程调用 Ready。
+// 子进程在 timeout 内没有就绪或者提前退出时结束子进程并返回 ErrUpgradeFailed，listeners 不受影响。
+// 成功后文件系统 Unix domain socket 的监听在关闭时不再删除 socket 文件，它已经属于子进程
+func Upgrade(listeners map[string]net.Listener, timeout time.Duration) (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		if strings.Contains(name, ",") {
+			return nil, fmt.Errorf("invalid listener name %q", name)
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	files := make([]*os.File, 0, len(names)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, name := range names {
+		fl, ok := listeners[name].(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener %s does not support handoff", name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("listener %s: %w", name, err)
+		}
+		files = append(files, f)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	files = append(files, w) // 子进程就绪后写入，父进程在 r 上等待
+
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, handoffFDsEnv+"=") || strings.HasPrefix(kv, handoffReadyEnv+"=")
+	}), handoffFDsEnv+"="+strings.Join(names, ","), handoffReadyEnv+"="+strconv.Itoa(3+len(names)))
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	w.Close() // 子进程退出时 r 读到 EOF
+	files = files[:len(files)-1]
+
+	r.SetReadDeadline(time.Now().Add(timeout))
+	if _, err = io.ReadFull(r, make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if errors.Is(err, io.EOF) {
+			err = errors.New("child exited before it was ready")
+		}
+		return nil, fmt.Errorf("%w: %w", ErrUpgradeFailed, err)
+	}
+	go cmd.Wait() // 父进程排空后退出，子进程继续运行
+	for _, l := range listeners {
+		if ul, ok := l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return cmd.Process, nil
+}
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMain 在被 Upgrade 启动时扮演子进程：取出继承的监听 socket，通知父进程就绪，
+// 接受一个连接并回复 "child"。TRANSPORT_TEST_FAIL 非空时模拟新版本启动失败
+func TestMain(m *testing.M) {
+	if _, ok := os.LookupEnv(handoffFDsEnv); !ok {
+		os.Exit(m.Run())
+	}
+	if os.Getenv("TRANSPORT_TEST_FAIL") != "" {
+		os.Exit(1)
+	}
+	h, err := Inherit()
+	if err != nil {
+		os.Exit(1)
+	}
+	l := h.Listener("test")
+	if l == nil || h.Ready() != nil {
+		os.Exit(1)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		os.Exit(1)
+	}
+	conn.Write([]byte("child"))
+	conn.Close()
+	os.Exit(0)
+}
+
+func TestUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	l, err := Listen("unix://"+path, SocketOptions{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	listeners := map[string]net.Listener{"test": l}
+
+	// case 1: 子进程没有就绪，父进程继续持有监听
+	t.Setenv("TRANSPORT_TEST_FAIL", "1")
+	if _, err = Upgrade(listeners, 5*time.Second); !errors.Is(err, ErrUpgradeFailed) {
+		t.Errorf("want ErrUpgradeFailed, actual %v", err)
+	}
+
+	// case 2: 子进程就绪后父进程关闭监听，socket 文件保留，新连接由子进程接受
+	os.Unsetenv("TRANSPORT_TEST_FAIL")
+	child, err := Upgrade(listeners, 5*time.Second)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	l.Close()
+	conn, err := Dial("unix://"+path, time.Second)
+	if err != nil {
+		child.Kill()
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(conn)
+	if err != nil || string(reply) != "child" {
+		t.Errorf("want child, actual %q %v", reply, err)
+	}
+}
+
+func TestInherit_None(t *testing.T) {
+	h, err := Inherit()
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if h.Listener("test") != nil || h.Ready() != nil {
+		t.Errorf("want empty inheritance, actual %+v", h)
+	}
+}
@@ -0,0 +1,13 @@
+package transport
+
+import "errors"
+
+// ErrPeerCredUnsupported 表示连接不是 Unix domain socket，或者当前系统不支持获取对端凭据
+var ErrPeerCredUnsupported = errors.New("peer credentials not supported")
+
+// PeerCred 为 Unix domain socket 对端进程的凭据（SO_PEERCRED），在对端 connect 时由内核记录，无法伪造
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
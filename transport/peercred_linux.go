@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"net"
+	"syscall"
+)
+
+// ReadPeerCred 读取 Unix domain socket 对端进程的凭据
+func ReadPeerCred(conn net.Conn) (*PeerCred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, ErrPeerCredUnsupported
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, sockErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+	return &PeerCred{PID: cred.Pid, UID: cred.Uid, GID: cred.Gid}, nil
+}
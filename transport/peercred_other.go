@@ -0,0 +1,10 @@
+//go:build !linux
+
+package transport
+
+import "net"
+
+// ReadPeerCred 在非 Linux 系统上不支持，总是返回 ErrPeerCredUnsupported
+func ReadPeerCred(conn net.Conn) (*PeerCred, error) {
+	return nil, ErrPeerCredUnsupported
+}
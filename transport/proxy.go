@@ -0,0 +1,259 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* HAProxy PROXY 协议（v1 文本格式和 v2 二进制格式）。负载均衡在转发的连接开头写入一个头，
+携带客户端的真实地址，之后才是正常的帧。只有来自可信地址的连接才解析这个头，而且必须携带；
+其他连接原样使用，任意客户端都无法伪造地址。
+	v1: "PROXY TCP4 源地址 目的地址 源端口 目的端口\r\n"，最长 107 字节
+	v2: 12 字节签名 + 版本/命令(1) + 地址族/协议(1) + 长度(2) + 地址 + TLV
+LOCAL 命令（v2）和 UNKNOWN（v1）表示负载均衡自己的健康检查，保留连接本身的地址
+*/
+
+var (
+	ErrProxyHeader = errors.New("invalid proxy protocol header")
+
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	proxyV1MaxLen        = 107
+	defaultHeaderTimeout = 5 * time.Second
+)
+
+type proxyListener struct {
+	net.Listener
+	trusted []netip.Prefix
+	timeout time.Duration
+}
+
+// NewProxyListener 返回解析 PROXY 协议头的 Listener：来自 trusted 中地址的连接必须以 PROXY 头开始，
+// 连接的 RemoteAddr/LocalAddr 替换为头中的地址。头在第一次 Read 或 RemoteAddr 时解析，不阻塞 Accept；
+// 在 headerTimeout 内（0 表示 5s）没有收到完整的头则读取失败
+func NewProxyListener(l net.Listener, trusted []netip.Prefix, headerTimeout time.Duration) net.Listener {
+	if headerTimeout <= 0 {
+		headerTimeout = defaultHeaderTimeout
+	}
+	return &proxyListener{Listener: l, trusted: trusted, timeout: headerTimeout}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, timeout: l.timeout}, nil
+}
+
+func (l *proxyListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcpAddr.AddrPort().Addr().Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyConn 在第一次使用时读取 PROXY 头，之后的数据先从 br 中读出头后面已经缓冲的部分
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+
+	once       sync.Once
+	err        error
+	br         *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// NetConn 返回底层的连接
+func (c *proxyConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.br = bufio.NewReaderSize(c.Conn, 256)
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remoteAddr, c.localAddr, c.err = readProxyHeader(c.br)
+		c.Conn.SetReadDeadline(time.Time{})
+		if c.err != nil {
+			c.err = fmt.Errorf("proxy header from %s: %w", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.br.Buffered() > 0 {
+		return c.br.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.init()
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyHeader 读取一个 v1 或 v2 的头，LOCAL/UNKNOWN 时返回的地址为 nil
+func readProxyHeader(br *bufio.Reader) (src, dst net.Addr, err error) {
+	sig, err := br.Peek(len(proxyV2Signature))
+	if err != nil { // 最短的 v1 头 "PROXY UNKNOWN\r\n" 也超过签名的长度
+		return nil, nil, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2(br)
+	}
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return readProxyV1(br)
+	}
+	return nil, nil, ErrProxyHeader
+}
+
+func readProxyV1(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLen {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, ErrProxyHeader
+	}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, ErrProxyHeader
+	}
+	src, err := parseV1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func parseV1Addr(host, port string, v4 bool) (net.Addr, error) {
+	ip, err := netip.ParseAddr(host)
+	if err != nil || ip.Is4() != v4 {
+		return nil, ErrProxyHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(p))), nil
+}
+
+func readProxyV2(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, nil, err
+	}
+	verCmd, family := header[12], header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, nil, err
+	}
+	if verCmd>>4 != 2 {
+		return nil, nil, ErrProxyHeader
+	}
+	switch verCmd & 0x0F {
+	case 0x00: // LOCAL
+		return nil, nil, nil
+	case 0x01: // PROXY
+	default:
+		return nil, nil, ErrProxyHeader
+	}
+	var size int
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		size = 4
+	case 0x2: // AF_INET6
+		size = 16
+	default: // AF_UNSPEC、AF_UNIX 没有可用的 IP 地址
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, ErrProxyHeader
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:size])
+	dstIP, _ := netip.AddrFromSlice(body[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(body[2*size:])
+	dstPort := binary.BigEndian.Uint16(body[2*size+2:])
+	// 地址之后的 TLV 不使用，已经随 body 一起读出丢弃
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort)),
+		net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort)), nil
+}
+
+// ParsePrefixes 解析逗号分隔的 CIDR 列表，单个 IP 视为只包含它自己的前缀，空字符串返回 nil
+func ParsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// proxyV2 构造一个 v2 的头，addrs 为源/目的地址和端口，按地址族编码
+func proxyV2(cmd byte, src, dst netip.AddrPort) []byte {
+	body := append(src.Addr().AsSlice(), dst.Addr().AsSlice()...)
+	body = binary.BigEndian.AppendUint16(body, src.Port())
+	body = binary.BigEndian.AppendUint16(body, dst.Port())
+	body = append(body, 0x04, 0x00, 0x01, 0xFF) // 一个不使用的 TLV
+	family := byte(0x11)
+	if src.Addr().Is6() {
+		family = 0x21
+	}
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|cmd, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...)
+}
+
+// dialProxy 通过 trusted 的 ProxyListener 建立连接并写入 data，返回服务端的连接
+func dialProxy(t *testing.T, trusted string, data []byte) net.Conn {
+	prefixes, err := ParsePrefixes(trusted)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	l := NewProxyListener(inner, prefixes, 200*time.Millisecond)
+	t.Cleanup(func() { l.Close() })
+	client, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	t.Cleanup(func() { client.Close() })
+	client.Write(data)
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestProxyListener(t *testing.T) {
+	src4 := netip.MustParseAddrPort("203.0.113.7:51000")
+	dst4 := netip.MustParseAddrPort("198.51.100.1:8080")
+	src6 := netip.MustParseAddrPort("[2001:db8::7]:51000")
+	dst6 := netip.MustParseAddrPort("[2001:db8::1]:8080")
+	cases := []struct {
+		name   string
+		header []byte
+		remote string // 空表示保留连接本身的地址
+		local  string
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 203.0.113.7 198.51.100.1 51000 8080\r\n"), src4.String(), dst4.String()},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51000 8080\r\n"), src6.String(), dst6.String()},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", ""},
+		{"v2 ipv4", proxyV2(0x1, src4, dst4), src4.String(), dst4.String()},
+		{"v2 ipv6", proxyV2(0x1, src6, dst6), src6.String(), dst6.String()},
+		{"v2 local", proxyV2(0x0, src4, dst4), "", ""},
+	}
+	for _, c := range cases {
+		conn := dialProxy(t, "127.0.0.0/8", append(c.header, "frame"...))
+		want := c.remote
+		if want == "" {
+			want = conn.(*proxyConn).Conn.RemoteAddr().String()
+		}
+		if conn.RemoteAddr().String() != want {
+			t.Errorf("%s: want %s, actual %s", c.name, want, conn.RemoteAddr())
+		}
+		if c.local != "" && conn.LocalAddr().String() != c.local {
+			t.Errorf("%s: want %s, actual %s", c.name, c.local, conn.LocalAddr())
+		}
+		// 头后面的数据原样读出
+		b := make([]byte, 5)
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != "frame" {
+			t.Errorf("%s: want frame, actual %q %v", c.name, b, err)
+		}
+	}
+}
+
+func TestProxyListener_Untrusted(t *testing.T) {
+	// 不可信来源的 PROXY 头不解析，作为普通数据读出
+	header := "PROXY TCP4 203.0.113.7 198.51.100.1 51000 8080\r\n"
+	conn := dialProxy(t, "10.0.0.0/8", []byte(header))
+	if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != "127.0.0.1" {
+		t.Errorf("want 127.0.0.1, actual %s", conn.RemoteAddr())
+	}
+	b := make([]byte, len(header))
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != header {
+		t.Errorf("want %q, actual %q %v", header, b, err)
+	}
+}
+
+func TestProxyListener_Invalid(t *testing.T) {
+	cases := map[string][]byte{
+		"no header":  []byte("\x00\x00\x00\x09hello world"),
+		"bad v1":     []byte("PROXY TCP4 203.0.113.7 2001:db8::1 51000 8080\r\n"),
+		"long v1":    append([]byte("PROXY TCP4 "), make([]byte, 200)...),
+		"bad v2 ver": append(append([]byte{}, proxyV2Signature...), 0x11, 0x11, 0, 0),
+	}
+	for name, data := range cases {
+		conn := dialProxy(t, "127.0.0.1", data)
+		if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, ErrProxyHeader) {
+			t.Errorf("%s: want %v, actual %v", name, ErrProxyHeader, err)
+		}
+	}
+	// 可信来源迟迟不发送头时读取超时
+	conn := dialProxy(t, "127.0.0.1", []byte("PROXY"))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Errorf("want timeout, actual nil")
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	prefixes, err := ParsePrefixes("10.1.2.3/8, 192.168.1.5,::1")
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.5/32", "::1/128"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("want %v, actual %v", want, prefixes)
+	}
+	for i := range want {
+		if prefixes[i].String() != want[i] {
+			t.Errorf("want %s, actual %s", want[i], prefixes[i])
+		}
+	}
+	if _, err = ParsePrefixes("10.0.0.0/33"); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* 服务端和客户端共用的地址格式：
+	host:port 或 tcp://host:port   TCP
+	unix:///run/app.sock          文件系统中的 Unix domain socket
+	unix://@app                   Linux 抽象命名空间中的 Unix domain socket，不占用文件，进程退出后自动释放
+Unix domain socket 上的帧格式与 TCP 完全相同
+*/
+
+// SocketOptions 为文件系统 Unix domain socket 的权限设置，对 TCP 和抽象 socket 不起作用。
+// 零值表示按进程的 umask 创建、属组不变
+type SocketOptions struct {
+	Mode  os.FileMode // socket 文件的权限，例如 0660，0 表示不修改
+	Group string      // socket 文件的属组（组名或 gid），空表示不修改
+}
+
+// ParseAddr 把地址拆分为 net.Listen/net.Dial 使用的 network 和 address
+func ParseAddr(addr string) (network, address string, err error) {
+	if rest, ok := strings.CutPrefix(addr, "unix://"); ok {
+		if rest == "" || rest == "@" {
+			return "", "", fmt.Errorf("empty unix socket path in %q", addr)
+		}
+		return "unix", rest, nil
+	}
+	if rest, ok := strings.CutPrefix(addr, "tcp://"); ok {
+		return "tcp", rest, nil
+	}
+	if strings.Contains(addr, "://") {
+		return "", "", fmt.Errorf("unsupported address scheme in %q", addr)
+	}
+	return "tcp", addr, nil
+}
+
+// Listen 按地址监听。文件系统 socket 已经存在时，如果没有进程在监听（上次异常退出留下的）就删除后重建，
+// 否则返回错误；监听成功后按 opts 设置权限，Close 时删除 socket 文件
+func Listen(addr string, opts SocketOptions) (net.Listener, error) {
+	network, address, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" || isAbstract(address) {
+		return net.Listen(network, address)
+	}
+	if err = removeStaleSocket(address); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if err = applySocketOptions(address, opts); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Dial 按地址建立连接
+func Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	network, address, err := ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout(network, address, timeout)
+}
+
+func isAbstract(address string) bool {
+	return strings.HasPrefix(address, "@")
+}
+
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+func applySocketOptions(path string, opts SocketOptions) error {
+	if opts.Mode != 0 {
+		if err := os.Chmod(path, opts.Mode); err != nil {
+			return err
+		}
+	}
+	if opts.Group == "" {
+		return nil
+	}
+	gid, err := lookupGroup(opts.Group)
+	if err != nil {
+		return err
+	}
+	return os.Lchown(path, -1, gid)
+}
+
+// lookupGroup 把组名或数字形式的 gid 解析为 gid
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
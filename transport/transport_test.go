@@ -0,0 +1,147 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct {
+		addr, network, address string
+	}{
+		{":8080", "tcp", ":8080"},
+		{"tcp://127.0.0.1:8080", "tcp", "127.0.0.1:8080"},
+		{"unix:///run/app.sock", "unix", "/run/app.sock"},
+		{"unix://@app", "unix", "@app"},
+	}
+	for _, c := range cases {
+		network, address, err := ParseAddr(c.addr)
+		if err != nil || network != c.network || address != c.address {
+			t.Errorf("%s: want %s %s, actual %s %s %v", c.addr, c.network, c.address, network, address, err)
+		}
+	}
+	for _, addr := range []string{"unix://", "unix://@", "udp://:53"} {
+		if _, _, err := ParseAddr(addr); err == nil {
+			t.Errorf("%s: want error, actual nil", addr)
+		}
+	}
+}
+
+// echoOnce 接受一个连接，返回对端凭据并把收到的一个字节写回去
+func echoOnce(t *testing.T, l net.Listener) <-chan *PeerCred {
+	ch := make(chan *PeerCred, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(ch)
+			return
+		}
+		defer conn.Close()
+		cred, _ := ReadPeerCred(conn)
+		ch <- cred
+		b := make([]byte, 1)
+		conn.Read(b)
+		conn.Write(b)
+	}()
+	return ch
+}
+
+func TestListen_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	addr := "unix://" + path
+	l, err := Listen(addr, SocketOptions{Mode: 0600})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("want mode 0600, actual %v %v", fi.Mode(), err)
+	}
+	creds := echoOnce(t, l)
+	conn, err := Dial(addr, time.Second)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte{7})
+	b := make([]byte, 1)
+	if _, err = conn.Read(b); err != nil || b[0] != 7 {
+		t.Errorf("want 7, actual %v %v", b, err)
+	}
+	conn.Close()
+	cred := <-creds
+	// 还有进程在监听时不能覆盖
+	if _, err = Listen(addr, SocketOptions{}); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+	if runtime.GOOS == "linux" {
+		if cred == nil || cred.UID != uint32(os.Getuid()) || cred.PID != int32(os.Getpid()) {
+			t.Errorf("want uid %d pid %d, actual %+v", os.Getuid(), os.Getpid(), cred)
+		}
+	}
+	l.Close()
+
+	// 异常退出留下的 socket 文件在下次监听时被替换
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	if l, err = Listen(addr, SocketOptions{}); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	l.Close()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("want socket removed on close, actual %v", err)
+	}
+
+	// 不是 socket 的文件不会被删除
+	os.WriteFile(path, []byte("data"), 0600)
+	if _, err = Listen(addr, SocketOptions{}); err == nil {
+		t.Errorf("want error, actual nil")
+	}
+}
+
+func TestListen_Abstract(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are linux only")
+	}
+	addr := fmt.Sprintf("unix://@transport-test-%d", os.Getpid())
+	l, err := Listen(addr, SocketOptions{Mode: 0600})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	creds := echoOnce(t, l)
+	conn, err := Dial(addr, time.Second)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	if cred := <-creds; cred == nil || cred.UID != uint32(os.Getuid()) {
+		t.Errorf("want uid %d, actual %+v", os.Getuid(), cred)
+	}
+}
+
+func TestReadPeerCred_TCP(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", SocketOptions{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	creds := echoOnce(t, l)
+	conn, err := Dial("tcp://"+l.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+	if cred := <-creds; cred != nil {
+		t.Errorf("want nil, actual %+v", cred)
+	}
+}
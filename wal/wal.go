@@ -0,0 +1,465 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* 预写日志（write-ahead log）：只追加写，按段（segment）切分文件。
+每条记录的格式：
+	Len(4字节) + CRC32(4字节) + Type(1字节) + Index(8字节) + Data
+Len 为 Type+Index+Data 的长度，CRC32 覆盖同样的范围。
+Type 为 recordEntry 时表示一条新日志，为 recordDone 时表示 Index 对应的日志已处理完。
+段文件以该段第一条日志的 Index 命名，如 00000000000000000001.wal
+*/
+
+const (
+	recordEntry = iota + 1
+	recordDone
+)
+
+const (
+	headerLen     = 4 + 4 // Len + CRC32
+	recordMetaLen = 1 + 8 // Type + Index
+	segmentSuffix = ".wal"
+	maxRecordLen  = 64 << 20 // 单条记录上限，防止损坏的 Len 导致巨大的内存分配
+)
+
+var (
+	ErrClosed  = errors.New("wal closed")
+	ErrCorrupt = errors.New("wal corrupt")
+)
+
+// SyncPolicy 决定什么时候调用 fsync 把数据刷到磁盘
+type SyncPolicy int
+
+const (
+	SyncEveryWrite SyncPolicy = iota // 每次写入后都 fsync，最安全也最慢
+	SyncBatch                        // 每写入 BatchSize 条记录 fsync 一次
+	SyncInterval                     // 后台每隔 Interval fsync 一次
+)
+
+type Options struct {
+	SegmentSize int64         // 单个段文件的大小上限，超过后切换到新段，默认 64MB
+	Sync        SyncPolicy    // fsync 策略，默认 SyncEveryWrite
+	BatchSize   int           // SyncBatch 时每批的记录数，默认 64
+	Interval    time.Duration // SyncInterval 时的刷盘间隔，默认 100ms
+}
+
+// Entry 是一条日志
+type Entry struct {
+	Index uint64
+	Data  []byte
+}
+
+type segment struct {
+	first   uint64 // 段内第一条日志的 Index
+	path    string
+	pending int // 段内尚未处理完的日志条数
+}
+
+// segmentFile 是当前写入的段文件，测试时可以替换为注入写入或刷盘失败的实现
+type segmentFile interface {
+	io.Writer
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+type Log struct {
+	dir  string
+	opts Options
+
+	mu        sync.Mutex
+	segments  []*segment  // 按 first 升序，最后一个为当前写入的段
+	file      segmentFile // 当前写入的段文件
+	size      int64       // 当前段文件已写入的字节数
+	nextIndex uint64
+	pending   map[uint64]*segment // 尚未处理完的日志 -> 所在的段
+	recovered []Entry             // Open 时发现的尚未处理完的日志
+	unsynced  int                 // 自上次 fsync 以来写入的记录数
+	closed    bool
+	// err 为无法恢复的写入错误（写入失败后截断也失败），之后所有的写入都返回它
+	err error
+	// syncErr 为 SyncInterval 时后台 fsync 的错误，由下一次 Append、MarkDone 或 Sync 返回
+	syncErr error
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open 打开（或新建）dir 下的日志，扫描已有的段文件，找出尚未处理完的日志。
+// 最后一个段末尾不完整的记录（写到一半进程崩溃）会被截断
+func Open(dir string, opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 64 << 20
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 64
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 100 * time.Millisecond
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &Log{
+		dir:       dir,
+		opts:      opts,
+		nextIndex: 1,
+		pending:   make(map[uint64]*segment),
+		stop:      make(chan struct{}),
+	}
+	if err := l.load(); err != nil {
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		l.wg.Add(1)
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+// load 按顺序扫描所有段文件，恢复 nextIndex 和尚未处理完的日志
+func (l *Log) load() error {
+	names, err := filepath.Glob(filepath.Join(l.dir, "*"+segmentSuffix))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentSuffix), 10, 64)
+		if err != nil {
+			continue // 不是段文件
+		}
+		l.segments = append(l.segments, &segment{first: first, path: name})
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i].first < l.segments[j].first })
+
+	data := make(map[uint64][]byte)
+	for i, seg := range l.segments {
+		if seg.first > l.nextIndex {
+			l.nextIndex = seg.first // 段内的日志可能都已处理完，Index 至少从段名开始
+		}
+		last := i == len(l.segments)-1
+		validLen, err := l.scan(seg, data)
+		if err != nil && !(last && errors.Is(err, ErrCorrupt)) {
+			return fmt.Errorf("%s: %w", seg.path, err)
+		}
+		if err != nil {
+			// 最后一个段末尾的记录不完整，截断到最后一条完整记录之后
+			if err = os.Truncate(seg.path, validLen); err != nil {
+				return err
+			}
+		}
+	}
+	for index := range l.pending {
+		l.recovered = append(l.recovered, Entry{Index: index, Data: data[index]})
+	}
+	sort.Slice(l.recovered, func(i, j int) bool { return l.recovered[i].Index < l.recovered[j].Index })
+
+	if len(l.segments) == 0 {
+		return l.rotate()
+	}
+	if err = l.compact(); err != nil {
+		return err
+	}
+	active := l.segments[len(l.segments)-1]
+	f, err := os.OpenFile(active.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, info.Size()
+	return nil
+}
+
+// scan 读取一个段文件中的所有记录，返回最后一条完整记录结束的位置
+func (l *Log) scan(seg *segment, data map[uint64][]byte) (int64, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		typ, index, body, n, err := readRecord(r)
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset += n
+		switch typ {
+		case recordEntry:
+			seg.pending++
+			l.pending[index] = seg
+			data[index] = body
+			if index >= l.nextIndex {
+				l.nextIndex = index + 1
+			}
+		case recordDone:
+			if s, ok := l.pending[index]; ok {
+				s.pending--
+				delete(l.pending, index)
+				delete(data, index)
+			}
+		default:
+			return offset, ErrCorrupt
+		}
+	}
+}
+
+func readRecord(r io.Reader) (typ uint8, index uint64, data []byte, n int64, err error) {
+	header := make([]byte, headerLen)
+	if _, err = io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrCorrupt
+		}
+		return
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < recordMetaLen || length > maxRecordLen {
+		err = ErrCorrupt
+		return
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = ErrCorrupt
+		return
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+		err = ErrCorrupt
+		return
+	}
+	return body[0], binary.BigEndian.Uint64(body[1:9]), body[9:], int64(headerLen) + int64(length), nil
+}
+
+func encodeRecord(typ uint8, index uint64, data []byte) []byte {
+	buf := make([]byte, headerLen+recordMetaLen+len(data))
+	binary.BigEndian.PutUint32(buf[:4], uint32(recordMetaLen+len(data)))
+	buf[8] = typ
+	binary.BigEndian.PutUint64(buf[9:17], index)
+	copy(buf[17:], data)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+	return buf
+}
+
+// Append 追加一条日志并按 SyncPolicy 刷盘，返回日志的 Index
+func (l *Log) Append(data []byte) (uint64, error) {
+	if len(data)+recordMetaLen > maxRecordLen {
+		return 0, errors.New("wal entry too large")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	if l.size >= l.opts.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	index := l.nextIndex
+	if err := l.write(encodeRecord(recordEntry, index, data)); err != nil {
+		return 0, err
+	}
+	l.nextIndex++
+	seg := l.segments[len(l.segments)-1]
+	seg.pending++
+	l.pending[index] = seg
+	return index, nil
+}
+
+// MarkDone 记录 index 对应的日志已经处理完，崩溃恢复时不会再被重放。
+// 最早的段中所有日志都处理完后，该段文件会被删除
+func (l *Log) MarkDone(index uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	seg, ok := l.pending[index]
+	if !ok {
+		return nil
+	}
+	if err := l.write(encodeRecord(recordDone, index, nil)); err != nil {
+		return err
+	}
+	delete(l.pending, index)
+	seg.pending--
+	for i, e := range l.recovered {
+		if e.Index == index {
+			l.recovered = append(l.recovered[:i], l.recovered[i+1:]...)
+			break
+		}
+	}
+	return l.compact()
+}
+
+// Recovered 返回 Open 时发现的、至今仍未 MarkDone 的日志，按 Index 升序
+func (l *Log) Recovered() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Entry(nil), l.recovered...)
+}
+
+// Pending 返回尚未处理完的日志条数
+func (l *Log) Pending() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.pending)
+}
+
+func (l *Log) write(record []byte) error {
+	if l.err != nil {
+		return l.err
+	}
+	if err := l.syncErr; err != nil {
+		l.syncErr = nil
+		return err
+	}
+	size := l.size
+	if _, err := l.file.Write(record); err != nil {
+		// 写了一半的记录留在文件中的话，之后追加的记录都跟在它后面，Open 时会被当作损坏，
+		// 所以截断回写入之前的大小（文件以 O_APPEND 打开，下一次写入从新的末尾开始）
+		l.truncate(size)
+		return err
+	}
+	l.size += int64(len(record))
+	l.unsynced++
+	var err error
+	switch l.opts.Sync {
+	case SyncEveryWrite:
+		err = l.sync()
+	case SyncBatch:
+		if l.unsynced >= l.opts.BatchSize {
+			err = l.sync()
+		}
+	}
+	if err != nil {
+		// 返回错误时调用方认为这条记录没有写入：Append 不分配 Index，MarkDone 不移出 pending。
+		// 记录留在文件中的话，Index 会被下一次 Append 重用，Open 时还会重放一条已经应答失败的日志
+		l.truncate(size)
+	}
+	return err
+}
+
+// truncate 把当前段截断回 size，截断失败时之后所有的写入都返回错误
+func (l *Log) truncate(size int64) {
+	if err := l.file.Truncate(size); err != nil {
+		l.err = fmt.Errorf("wal: truncating failed record: %w", err)
+		return
+	}
+	l.size = size
+}
+
+func (l *Log) sync() error {
+	if l.unsynced == 0 {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.unsynced = 0
+	return nil
+}
+
+// Sync 立即把已写入的记录刷到磁盘
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	if err := l.syncErr; err != nil {
+		l.syncErr = nil
+		return err
+	}
+	return l.sync()
+}
+
+func (l *Log) syncLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.mu.Lock()
+			if !l.closed {
+				if err := l.sync(); err != nil && l.syncErr == nil {
+					l.syncErr = fmt.Errorf("wal: background sync: %w", err)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// rotate 关闭当前段，以 nextIndex 为名新建一个段
+func (l *Log) rotate() error {
+	if l.file != nil {
+		if err := l.file.Sync(); err != nil {
+			return err
+		}
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+	}
+	seg := &segment{first: l.nextIndex, path: filepath.Join(l.dir, fmt.Sprintf("%020d%s", l.nextIndex, segmentSuffix))}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.file, l.size, l.unsynced = f, 0, 0
+	l.segments = append(l.segments, seg)
+	return nil
+}
+
+// compact 从最早的段开始，删除所有日志都已处理完的段（当前写入的段除外）。
+// 必须从最早的段开始删：处理完成的标记总是写在日志所在的段或之后的段中
+func (l *Log) compact() error {
+	for len(l.segments) > 1 && l.segments[0].pending == 0 {
+		if err := os.Remove(l.segments[0].path); err != nil {
+			return err
+		}
+		l.segments = l.segments[1:]
+	}
+	return nil
+}
+
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.stop)
+	err := l.file.Sync()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.mu.Unlock()
+	l.wg.Wait()
+	return err
+}
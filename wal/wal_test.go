@@ -0,0 +1,255 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLog_Recover(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	i1, _ := l.Append([]byte("first"))
+	i2, _ := l.Append([]byte("second"))
+	i3, err := l.Append([]byte("third"))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if i1 != 1 || i2 != 2 || i3 != 3 {
+		t.Errorf("want 1/2/3, actual %d/%d/%d", i1, i2, i3)
+	}
+	if err = l.MarkDone(i2); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	l.Close()
+
+	// 模拟进程崩溃后重启：1 和 3 没有处理完，需要重放
+	l, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	entries := l.Recovered()
+	if len(entries) != 2 || entries[0].Index != 1 || string(entries[0].Data) != "first" ||
+		entries[1].Index != 3 || string(entries[1].Data) != "third" {
+		t.Errorf("want [1 first, 3 third], actual %v", entries)
+	}
+	// 新日志的 Index 接着上次的继续
+	i4, _ := l.Append([]byte("fourth"))
+	if i4 != 4 {
+		t.Errorf("want 4, actual %d", i4)
+	}
+	l.MarkDone(1)
+	if n := len(l.Recovered()); n != 1 {
+		t.Errorf("want 1, actual %d", n)
+	}
+	if n := l.Pending(); n != 2 {
+		t.Errorf("want 2, actual %d", n)
+	}
+}
+
+func TestLog_TornWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	l.Append([]byte("complete"))
+	l.Close()
+
+	// 模拟写到一半崩溃：在段文件末尾追加半条记录
+	names, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	f, _ := os.OpenFile(names[0], os.O_WRONLY|os.O_APPEND, 0o644)
+	f.Write(encodeRecord(recordEntry, 2, []byte("partial"))[:10])
+	f.Close()
+
+	l, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	entries := l.Recovered()
+	if len(entries) != 1 || string(entries[0].Data) != "complete" {
+		t.Errorf("want [complete], actual %v", entries)
+	}
+	// 截断后可以继续正常写入
+	if i, err := l.Append([]byte("next")); err != nil || i != 2 {
+		t.Errorf("want 2/nil, actual %d/%v", i, err)
+	}
+}
+
+func TestLog_Segments(t *testing.T) {
+	dir := t.TempDir()
+	// 段大小很小，每条记录都会切换到新段
+	l, err := Open(dir, Options{SegmentSize: 1, Sync: SyncBatch, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	for i := 0; i < 3; i++ {
+		if _, err = l.Append([]byte("entry")); err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+	}
+	names, _ := filepath.Glob(filepath.Join(dir, "*.wal"))
+	if len(names) != 3 {
+		t.Errorf("want 3 segments, actual %d", len(names))
+	}
+
+	// 先处理完第 2 条，第 1 段还有未处理的日志，不能删
+	l.MarkDone(2)
+	names, _ = filepath.Glob(filepath.Join(dir, "*.wal"))
+	if len(names) != 3 {
+		t.Errorf("want 3 segments, actual %d", len(names))
+	}
+	// 第 1 条也处理完后，前两段都可以删掉
+	l.MarkDone(1)
+	names, _ = filepath.Glob(filepath.Join(dir, "*.wal"))
+	if len(names) != 1 {
+		t.Errorf("want 1 segment, actual %d", len(names))
+	}
+}
+
+func TestLog_SyncInterval(t *testing.T) {
+	l, err := Open(t.TempDir(), Options{Sync: SyncInterval})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if _, err = l.Append([]byte("entry")); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+	if err = l.Close(); err != nil {
+		t.Errorf("want nil, actual %s", err.Error())
+	}
+	if _, err = l.Append([]byte("entry")); err != ErrClosed {
+		t.Errorf("want ErrClosed, actual %v", err)
+	}
+}
+
+func TestLog_SyncIntervalError(t *testing.T) {
+	l, err := Open(t.TempDir(), Options{Sync: SyncInterval, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	if _, err = l.Append([]byte("entry")); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	// 模拟后台 fsync 失败：错误由下一次 Sync 返回
+	l.mu.Lock()
+	l.file.Close()
+	l.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if err = l.Sync(); err == nil || !strings.Contains(err.Error(), "background sync") {
+		t.Errorf("want background sync error, actual %v", err)
+	}
+}
+
+// faultyFile 包装段文件，注入写入或刷盘失败
+type faultyFile struct {
+	segmentFile
+	short   int   // 大于 0 时下一次 Write 只写入前 short 字节并返回错误
+	syncErr error // 不为 nil 时 Sync 返回它
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.short > 0 {
+		n, _ := f.segmentFile.Write(p[:f.short])
+		f.short = 0
+		return n, errors.New("short write")
+	}
+	return f.segmentFile.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.syncErr != nil {
+		return f.syncErr
+	}
+	return f.segmentFile.Sync()
+}
+
+func TestLog_PartialWrite(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if _, err = l.Append([]byte("first")); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	// 下一条记录只写入一部分
+	l.file = &faultyFile{segmentFile: l.file, short: 10}
+	if _, err = l.Append(make([]byte, 100)); err == nil {
+		t.Fatalf("want error, actual nil")
+	}
+
+	// 写了一半的记录已经被截断，之后的记录在重新打开时完整可读
+	if _, err = l.Append([]byte("second")); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	l.Close()
+	l, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	entries := l.Recovered()
+	if len(entries) != 2 || string(entries[0].Data) != "first" || entries[1].Index != 2 || string(entries[1].Data) != "second" {
+		t.Errorf("want [1 first, 2 second], actual %v", entries)
+	}
+}
+
+func TestLog_SyncError(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if _, err = l.Append([]byte("first")); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	// 记录已经写入文件、fsync 失败：Append 返回错误，记录被截断，Index 不会被重用
+	f := &faultyFile{segmentFile: l.file, syncErr: errors.New("sync failed")}
+	l.file = f
+	if _, err = l.Append([]byte("failed")); err == nil {
+		t.Fatalf("want error, actual nil")
+	}
+	f.syncErr = nil
+	index, err := l.Append([]byte("second"))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	if index != 2 {
+		t.Errorf("want 2, actual %d", index)
+	}
+	if err = l.MarkDone(1); err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	// MarkDone 刷盘失败时日志仍未处理完
+	f.syncErr = errors.New("sync failed")
+	if err = l.MarkDone(2); err == nil {
+		t.Fatalf("want error, actual nil")
+	}
+	f.syncErr = nil
+	l.Close()
+
+	l, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer l.Close()
+	entries := l.Recovered()
+	if len(entries) != 1 || entries[0].Index != 2 || string(entries[0].Data) != "second" {
+		t.Errorf("want [2 second], actual %v", entries)
+	}
+	// 失败的记录不在文件中，段内未处理完的日志只有一条
+	if n := l.segments[0].pending; n != 1 {
+		t.Errorf("want 1, actual %d", n)
+	}
+}
@@ -0,0 +1,39 @@
+package ws
+
+import (
+	"37_tcp-server-demo1/frame"
+	"errors"
+	"io"
+)
+
+// messageCodec 让每条二进制 WebSocket 消息承载一个协议帧，消息本身已经有边界，不再需要长度前缀
+type messageCodec struct{}
+
+// NewFrameCodec 返回基于 WebSocket 消息的帧编解码器，只能用于 *Conn
+func NewFrameCodec() frame.StreamFrameCodec {
+	return messageCodec{}
+}
+
+func (messageCodec) Encode(w io.Writer, framePayload frame.FramePayload) error {
+	c, ok := w.(*Conn)
+	if !ok {
+		return errors.New("websocket frame codec requires *ws.Conn")
+	}
+	return c.WriteMessage(OpBinary, framePayload)
+}
+
+func (messageCodec) Decode(r io.Reader) (frame.FramePayload, error) {
+	c, ok := r.(*Conn)
+	if !ok {
+		return nil, errors.New("websocket frame codec requires *ws.Conn")
+	}
+	opcode, data, err := c.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	if opcode != OpBinary {
+		c.writeClose(CloseUnsupportedData)
+		return nil, ErrTextMessage
+	}
+	return data, nil
+}
@@ -0,0 +1,266 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* WebSocket（RFC 6455）连接的最小实现：只支持二进制和文本消息、分片、ping/pong 和关闭握手，不支持扩展（如压缩）。
+帧格式：FIN(1位) + RSV(3位) + Opcode(4位) + MASK(1位) + 长度(7位，126/127 时后跟 2/8 字节的长度) + [掩码(4字节)] + 数据
+客户端发出的帧必须带掩码，服务端发出的帧不带掩码
+*/
+
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// 关闭帧中的状态码
+const (
+	CloseNormal          = 1000
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseMessageTooBig   = 1009
+)
+
+// DefaultMaxMessageSize 为默认允许的最大消息长度（分片合并之后）
+const DefaultMaxMessageSize = 16 << 20
+
+var (
+	ErrProtocol        = errors.New("websocket protocol error")
+	ErrMessageTooLarge = errors.New("websocket message too large")
+	ErrTextMessage     = errors.New("websocket text message not supported")
+)
+
+// Conn 是完成握手之后的 WebSocket 连接。
+// Read/Write 把二进制消息当作字节流读写，ReadMessage/WriteMessage 按消息读写
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool // 客户端发出的帧需要掩码
+
+	MaxMessageSize int // 不大于 0 时使用 DefaultMaxMessageSize
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+	closeSent atomic.Bool // 关闭帧只发送一次
+	unread    []byte      // Read 时当前消息中尚未读取的部分
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, client: client}
+}
+
+// ReadMessage 读取一条完整的数据消息，期间收到的 ping 会自动回复 pong。
+// 收到关闭帧时回复关闭帧并返回 io.EOF
+func (c *Conn) ReadMessage() (opcode byte, data []byte, err error) {
+	max := c.MaxMessageSize
+	if max <= 0 {
+		max = DefaultMaxMessageSize
+	}
+	opcode = 0xFF // 尚未收到消息的第一个分片
+	for {
+		fin, op, payload, err := c.readFrame(max - len(data))
+		if err != nil {
+			if errors.Is(err, ErrMessageTooLarge) {
+				c.writeClose(CloseMessageTooBig)
+			} else if errors.Is(err, ErrProtocol) {
+				c.writeClose(CloseProtocolError)
+			}
+			return 0, nil, err
+		}
+		switch op {
+		case OpClose:
+			code := uint16(CloseNormal)
+			if len(payload) >= 2 {
+				code = binary.BigEndian.Uint16(payload)
+			}
+			c.writeClose(code)
+			return 0, nil, io.EOF
+		case OpPing:
+			if err = c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpContinuation:
+			if opcode == 0xFF {
+				return 0, nil, fmt.Errorf("%w: unexpected continuation frame", ErrProtocol)
+			}
+		case OpText, OpBinary:
+			if opcode != 0xFF {
+				return 0, nil, fmt.Errorf("%w: expected continuation frame", ErrProtocol)
+			}
+			opcode = op
+		default:
+			return 0, nil, fmt.Errorf("%w: unknown opcode %d", ErrProtocol, op)
+		}
+		data = append(data, payload...)
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+// readFrame 读取一个帧并去掉掩码，数据帧的长度超过 max 时返回 ErrMessageTooLarge
+func (c *Conn) readFrame(max int) (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0F
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		// 服务端只接受带掩码的帧，客户端只接受不带掩码的帧
+		return false, 0, nil, fmt.Errorf("%w: bad mask bit", ErrProtocol)
+	}
+	size := uint64(header[1] & 0x7F)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= OpClose {
+		// 控制帧不能分片，长度不超过 125
+		if !fin || size > 125 {
+			return false, 0, nil, fmt.Errorf("%w: bad control frame", ErrProtocol)
+		}
+	} else if size > uint64(max) {
+		return false, 0, nil, ErrMessageTooLarge
+	}
+	var key [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, key[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(key, payload)
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteMessage 把 data 作为一条不分片的消息发送，可以被多个 goroutine 并发调用
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xFFFF:
+		buf = binary.BigEndian.AppendUint16(append(buf, maskBit|126), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint64(append(buf, maskBit|127), uint64(n))
+	}
+	if c.client {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(key, buf[start:])
+	} else {
+		buf = append(buf, payload...)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *Conn) writeClose(code uint16) {
+	if c.closeSent.CompareAndSwap(false, true) {
+		c.writeFrame(OpClose, binary.BigEndian.AppendUint16(nil, code))
+	}
+}
+
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+// Read 依次读取二进制消息的内容，消息边界不保留；收到文本消息时返回 ErrTextMessage
+func (c *Conn) Read(b []byte) (int, error) {
+	for len(c.unread) == 0 {
+		opcode, data, err := c.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if opcode != OpBinary {
+			c.writeClose(CloseUnsupportedData)
+			return 0, ErrTextMessage
+		}
+		c.unread = data
+	}
+	n := copy(b, c.unread)
+	c.unread = c.unread[n:]
+	return n, nil
+}
+
+// Write 把 b 作为一条二进制消息发送
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.WriteMessage(OpBinary, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close 发送关闭帧（尽力而为）并关闭底层连接
+func (c *Conn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeClose(CloseNormal)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
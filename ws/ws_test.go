@@ -0,0 +1,164 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer 把收到的每条消息原样发回
+func echoServer(t *testing.T, u *Upgrader) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			opcode, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(opcode, data); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func TestConn_Echo(t *testing.T) {
+	conn, err := Dial(echoServer(t, &Upgrader{}), nil, time.Second)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	defer conn.Close()
+
+	// 覆盖 7 位、16 位和 64 位三种长度编码
+	for _, size := range []int{0, 5, 125, 126, 0xFFFF, 0x10000} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		if err = conn.WriteMessage(OpBinary, data); err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		opcode, echo, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("want nil, actual %s", err.Error())
+		}
+		if opcode != OpBinary || !bytes.Equal(echo, data) {
+			t.Errorf("want %d bytes, actual %d", size, len(echo))
+		}
+	}
+
+	// Read/Write 把消息当作字节流
+	conn.Write([]byte("hello"))
+	buf := make([]byte, 3)
+	if n, _ := conn.Read(buf); string(buf[:n]) != "hel" {
+		t.Errorf("want hel, actual %s", buf[:n])
+	}
+	if n, _ := conn.Read(buf); string(buf[:n]) != "lo" {
+		t.Errorf("want lo, actual %s", buf[:n])
+	}
+}
+
+func TestConn_FragmentsAndControl(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	c := newConn(server, nil, false)
+	go func() {
+		// 分片的消息中间夹着一个 ping，服务端应当先回复 pong
+		cc := newConn(client, nil, true)
+		writeRaw(cc, false, OpBinary, []byte("hel"))
+		writeRaw(cc, true, OpPing, []byte("p"))
+		writeRaw(cc, true, OpContinuation, []byte("lo"))
+		writeRaw(cc, true, OpClose, []byte{0x03, 0xE8})
+	}()
+	pongCh := make(chan []byte, 1)
+	go func() {
+		// 读出服务端回复的 pong 和 close
+		br := bufio.NewReader(client)
+		header := make([]byte, 2)
+		io.ReadFull(br, header)
+		pong := make([]byte, header[1]&0x7F)
+		io.ReadFull(br, pong)
+		pongCh <- pong
+		io.Copy(io.Discard, br)
+	}()
+	opcode, data, err := c.ReadMessage()
+	if err != nil || opcode != OpBinary || string(data) != "hello" {
+		t.Errorf("want hello, actual %q %v", data, err)
+	}
+	if pong := <-pongCh; string(pong) != "p" {
+		t.Errorf("want pong p, actual %q", pong)
+	}
+	if _, _, err = c.ReadMessage(); err != io.EOF {
+		t.Errorf("want EOF, actual %v", err)
+	}
+}
+
+// writeRaw 写入一个可以不带 FIN 的帧
+func writeRaw(c *Conn, fin bool, opcode byte, payload []byte) {
+	if fin {
+		c.writeFrame(opcode, payload)
+		return
+	}
+	key := [4]byte{1, 2, 3, 4}
+	buf := append([]byte{opcode, 0x80 | byte(len(payload))}, key[:]...)
+	masked := bytes.Clone(payload)
+	maskBytes(key, masked)
+	c.conn.Write(append(buf, masked...))
+}
+
+func TestConn_Errors(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	c := newConn(server, bufio.NewReader(bytes.NewReader([]byte{0x82, 0x05, 'h', 'e', 'l', 'l', 'o'})), false)
+	// 客户端发来的帧必须带掩码
+	if _, _, err := c.ReadMessage(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("want %v, actual %v", ErrProtocol, err)
+	}
+
+	c = newConn(server, bufio.NewReader(bytes.NewReader([]byte{0x82, 0xFF, 0, 0, 0, 0, 1, 0, 0, 0})), false)
+	c.MaxMessageSize = 1024
+	if _, _, err := c.ReadMessage(); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("want %v, actual %v", ErrMessageTooLarge, err)
+	}
+}
+
+func TestUpgrade_Reject(t *testing.T) {
+	url := echoServer(t, &Upgrader{})
+	// 其他网站的页面不能连接
+	if _, err := Dial(url, http.Header{"Origin": {"http://evil.example"}}, time.Second); err == nil {
+		t.Errorf("want handshake error, actual nil")
+	}
+	resp, err := http.Get("http" + strings.TrimPrefix(url, "ws"))
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Errorf("want %d, actual %d", http.StatusUpgradeRequired, resp.StatusCode)
+	}
+
+	allowAll := echoServer(t, &Upgrader{CheckOrigin: func(r *http.Request) bool { return true }})
+	conn, err := Dial(allowAll, http.Header{"Origin": {"http://dashboard.example"}}, time.Second)
+	if err != nil {
+		t.Fatalf("want nil, actual %s", err.Error())
+	}
+	conn.Close()
+}
+
+func TestAcceptKey(t *testing.T) {
+	// RFC 6455 第 1.3 节的例子
+	if key := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); key != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("want s3pPLMBiTxaQ9kYGzzhZRbK+xOo=, actual %s", key)
+	}
+}